@@ -0,0 +1,11 @@
+DROP TABLE IF EXISTS timeseries;
+DROP TABLE IF EXISTS votes_answers;
+DROP TABLE IF EXISTS votes_questions;
+DROP TABLE IF EXISTS answers;
+DROP TABLE IF EXISTS questions;
+DROP TABLE IF EXISTS likes;
+DROP TABLE IF EXISTS purchases;
+DROP TABLE IF EXISTS tags;
+DROP TABLE IF EXISTS brands;
+DROP TABLE IF EXISTS followers;
+DROP TABLE IF EXISTS users;
@@ -1,17 +1,3 @@
--- remove all tables
-DROP TABLE IF EXISTS votes_questions;
-DROP TABLE IF EXISTS votes_answers;
-DROP TABLE IF EXISTS likes;
-DROP TABLE IF EXISTS followers;
-DROP TABLE IF EXISTS answers;
-DROP TABLE IF EXISTS questions;
-DROP TABLE IF EXISTS tags;
-DROP TABLE IF EXISTS purchases;
-DROP TABLE IF EXISTS brands;
-DROP TABLE IF EXISTS users_login;
-DROP TABLE IF EXISTS users;
-DROP TABLE IF EXISTS timeseries;
-
 -- Users
 CREATE TABLE "users" (
     "id" serial,
@@ -116,6 +102,7 @@ CREATE TABLE "purchases" (
     FOREIGN KEY ("brand_id") REFERENCES "brands"("id") ON DELETE SET NULL,
     FOREIGN KEY ("user_id") REFERENCES "users"("id")
 );
+CREATE INDEX purchases_issued_at_id_idx ON purchases (issued_at DESC, id DESC);
 COMMENT ON TABLE "purchases" IS 'All purchases in the system';
 COMMENT ON COLUMN "purchases"."id" IS 'ID of a purchase';
 COMMENT ON COLUMN "purchases"."image" IS 'Path to the location of the image';
@@ -189,6 +176,7 @@ CREATE TABLE "votes_questions" (
     FOREIGN KEY ("user_id") REFERENCES "users"("id"),
     FOREIGN KEY ("question_id") REFERENCES "questions"("id")
 );
+CREATE UNIQUE INDEX votes_questions_user_id_question_id_pkey ON votes_questions (user_id, question_id);
 COMMENT ON TABLE "votes_questions" IS 'All votes for questions in the system';
 COMMENT ON COLUMN "votes_questions"."user_id" IS 'ID of a user who voted';
 COMMENT ON COLUMN "votes_questions"."question_id" IS 'ID of a question which was voted';
@@ -203,6 +191,7 @@ CREATE TABLE "votes_answers" (
     FOREIGN KEY ("user_id") REFERENCES "users"("id"),
     FOREIGN KEY ("answer_id") REFERENCES "answers"("id")
 );
+CREATE UNIQUE INDEX votes_answers_user_id_answer_id_pkey ON votes_answers (user_id, answer_id);
 COMMENT ON TABLE "votes_answers" IS 'All votes for answers in the system';
 COMMENT ON COLUMN "votes_answers"."user_id" IS 'ID of a user who voted';
 COMMENT ON COLUMN "votes_answers"."answer_id" IS 'ID of an answer which was voted';
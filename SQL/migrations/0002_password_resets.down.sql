@@ -0,0 +1,2 @@
+ALTER TABLE "users" DROP COLUMN "jwt_valid_after";
+DROP TABLE IF EXISTS password_resets;
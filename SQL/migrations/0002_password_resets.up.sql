@@ -0,0 +1,20 @@
+-- Tokens to reset forgotten passwords
+CREATE TABLE "password_resets" (
+    "id" serial,
+    "user_id" integer NOT NULL,
+    "token_hash" bytea NOT NULL,
+    "issued_at" timestamp NOT NULL DEFAULT (now() at time zone 'utc'),
+    "expires_at" timestamp NOT NULL,
+    "used_at" timestamp,
+    PRIMARY KEY ("id"),
+    UNIQUE ("token_hash"),
+    FOREIGN KEY ("user_id") REFERENCES "users"("id")
+);
+COMMENT ON TABLE "password_resets" IS 'One-time tokens which allow to set a new password without knowing the old one';
+COMMENT ON COLUMN "password_resets"."user_id" IS 'ID of a user who asked to reset the password';
+COMMENT ON COLUMN "password_resets"."token_hash" IS 'sha256 of the token sent by email. The token itself is never stored';
+COMMENT ON COLUMN "password_resets"."expires_at" IS 'The token can not be used after this time';
+COMMENT ON COLUMN "password_resets"."used_at" IS 'Time when the token was used. NULL if it is still unused';
+
+ALTER TABLE "users" ADD COLUMN "jwt_valid_after" integer NOT NULL DEFAULT 0;
+COMMENT ON COLUMN "users"."jwt_valid_after" IS 'Unix time. JWT tokens issued before it are not valid anymore';
@@ -0,0 +1,2 @@
+ALTER TABLE "users" ADD COLUMN "pending_email" varchar(256) NOT NULL DEFAULT '';
+COMMENT ON COLUMN "users"."pending_email" IS 'New email of a user. Replaces email after it is confirmed with confirmation_code';
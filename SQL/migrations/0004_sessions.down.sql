@@ -0,0 +1,3 @@
+ALTER TABLE "users" ADD COLUMN "jwt_valid_after" integer NOT NULL DEFAULT 0;
+DROP TABLE IF EXISTS refresh_tokens;
+DROP TABLE IF EXISTS sessions;
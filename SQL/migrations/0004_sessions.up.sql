@@ -0,0 +1,38 @@
+-- Sessions
+CREATE TABLE "sessions" (
+    "id" serial,
+    "user_id" integer NOT NULL,
+    "user_agent" varchar(256) NOT NULL DEFAULT '',
+    "ip" varchar(64) NOT NULL DEFAULT '',
+    "issued_at" timestamp NOT NULL DEFAULT (now() at time zone 'utc'),
+    "last_used_at" timestamp NOT NULL DEFAULT (now() at time zone 'utc'),
+    "expires_at" timestamp NOT NULL,
+    "revoked_at" timestamp,
+    PRIMARY KEY ("id"),
+    FOREIGN KEY ("user_id") REFERENCES "users"("id")
+);
+CREATE INDEX "sessions_user_id_idx" ON "sessions" ("user_id");
+COMMENT ON TABLE "sessions" IS 'Every login creates a session. Access JWT tokens are valid only while their session is';
+COMMENT ON COLUMN "sessions"."user_agent" IS 'User-Agent of a client which used the session the last time';
+COMMENT ON COLUMN "sessions"."ip" IS 'IP of a client which used the session the last time';
+COMMENT ON COLUMN "sessions"."last_used_at" IS 'Time when the session was refreshed the last time';
+COMMENT ON COLUMN "sessions"."expires_at" IS 'After this time the session can not be refreshed';
+COMMENT ON COLUMN "sessions"."revoked_at" IS 'Time when a user logged out or the session was killed. NULL if it is active';
+
+-- Refresh tokens
+CREATE TABLE "refresh_tokens" (
+    "id" serial,
+    "session_id" integer NOT NULL,
+    "token_hash" bytea NOT NULL,
+    "issued_at" timestamp NOT NULL DEFAULT (now() at time zone 'utc'),
+    "used_at" timestamp,
+    PRIMARY KEY ("id"),
+    UNIQUE ("token_hash"),
+    FOREIGN KEY ("session_id") REFERENCES "sessions"("id")
+);
+COMMENT ON TABLE "refresh_tokens" IS 'Opaque tokens to get new access JWT tokens. Each can be used only once';
+COMMENT ON COLUMN "refresh_tokens"."token_hash" IS 'sha256 of the token. The token itself is never stored';
+COMMENT ON COLUMN "refresh_tokens"."used_at" IS 'Time when the token was exchanged for a new one. Using it again revokes the session';
+
+-- sessions are revoked instead
+ALTER TABLE "users" DROP COLUMN "jwt_valid_after";
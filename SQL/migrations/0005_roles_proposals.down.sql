@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS proposals;
+ALTER TABLE "users" DROP COLUMN "role";
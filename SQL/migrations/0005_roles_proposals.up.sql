@@ -0,0 +1,31 @@
+-- Roles
+ALTER TABLE "users" ADD COLUMN "role" varchar(20) NOT NULL DEFAULT 'user';
+ALTER TABLE "users" ADD CONSTRAINT "users_role_check" CHECK ("role" IN ('user', 'moderator', 'admin'));
+COMMENT ON COLUMN "users"."role" IS 'user, moderator (manages brands and tags) or admin (also manages roles). Carried in JWT tokens';
+
+-- Proposals
+CREATE TABLE "proposals" (
+    "id" serial,
+    "kind" varchar(20) NOT NULL,
+    "name" varchar(40) NOT NULL,
+    "description" varchar(1000) NOT NULL DEFAULT '',
+    "user_id" integer NOT NULL,
+    "status" varchar(20) NOT NULL DEFAULT 'pending',
+    "issued_at" timestamp NOT NULL DEFAULT (now() at time zone 'utc'),
+    "reviewer_id" integer,
+    "reviewed_at" timestamp,
+    "created_id" integer,
+    PRIMARY KEY ("id"),
+    CHECK ("kind" IN ('brand', 'tag')),
+    CHECK ("status" IN ('pending', 'approved', 'rejected')),
+    FOREIGN KEY ("user_id") REFERENCES "users"("id"),
+    FOREIGN KEY ("reviewer_id") REFERENCES "users"("id")
+);
+CREATE INDEX "proposals_status_idx" ON "proposals" ("status");
+CREATE INDEX "proposals_user_id_idx" ON "proposals" ("user_id");
+COMMENT ON TABLE "proposals" IS 'Brands and tags proposed by users. Moderators approve or reject them';
+COMMENT ON COLUMN "proposals"."kind" IS 'What is proposed: brand or tag';
+COMMENT ON COLUMN "proposals"."description" IS 'Description of a tag. Empty for brands';
+COMMENT ON COLUMN "proposals"."status" IS 'pending, approved or rejected';
+COMMENT ON COLUMN "proposals"."reviewer_id" IS 'Moderator who approved or rejected the proposal';
+COMMENT ON COLUMN "proposals"."created_id" IS 'ID of a brand or a tag created after the approval';
@@ -0,0 +1,15 @@
+-- Failed logins
+CREATE TABLE "login_attempts" (
+    "scope" varchar(20) NOT NULL,
+    "key" varchar(256) NOT NULL,
+    "failures" integer NOT NULL DEFAULT 0,
+    "last_failure_at" timestamp NOT NULL,
+    "blocked_until" timestamp NOT NULL,
+    PRIMARY KEY ("scope", "key")
+);
+CREATE INDEX "login_attempts_last_failure_at_idx" ON "login_attempts" ("last_failure_at");
+COMMENT ON TABLE "login_attempts" IS 'Consecutive failed logins. Used when the service runs as many instances';
+COMMENT ON COLUMN "login_attempts"."scope" IS 'What is tracked: account or ip';
+COMMENT ON COLUMN "login_attempts"."key" IS 'Email of an account or an IP';
+COMMENT ON COLUMN "login_attempts"."failures" IS 'Number of failures since the last success. Forgotten after a while without failures';
+COMMENT ON COLUMN "login_attempts"."blocked_until" IS 'Next login is not allowed before this time';
@@ -0,0 +1,5 @@
+DROP TABLE IF EXISTS mfa_challenges;
+DROP TABLE IF EXISTS recovery_codes;
+ALTER TABLE "users" DROP COLUMN "totp_last_counter";
+ALTER TABLE "users" DROP COLUMN "totp_enabled";
+ALTER TABLE "users" DROP COLUMN "totp_secret";
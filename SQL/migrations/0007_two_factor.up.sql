@@ -0,0 +1,39 @@
+-- TOTP
+ALTER TABLE "users" ADD COLUMN "totp_secret" varchar(64) NOT NULL DEFAULT '';
+ALTER TABLE "users" ADD COLUMN "totp_enabled" boolean NOT NULL DEFAULT FALSE;
+ALTER TABLE "users" ADD COLUMN "totp_last_counter" bigint NOT NULL DEFAULT 0;
+COMMENT ON COLUMN "users"."totp_secret" IS 'Base32 TOTP secret. Set during the setup, used only when totp_enabled';
+COMMENT ON COLUMN "users"."totp_enabled" IS 'Whether a login requires a code from an authenticator app';
+COMMENT ON COLUMN "users"."totp_last_counter" IS 'Time step of the last accepted code. A code can not be used twice';
+
+-- Recovery codes
+CREATE TABLE "recovery_codes" (
+    "id" serial,
+    "user_id" integer NOT NULL,
+    "code_hash" bytea NOT NULL,
+    "used_at" timestamp,
+    PRIMARY KEY ("id"),
+    FOREIGN KEY ("user_id") REFERENCES "users"("id")
+);
+CREATE INDEX "recovery_codes_user_id_idx" ON "recovery_codes" ("user_id");
+COMMENT ON TABLE "recovery_codes" IS 'One-time codes to log in without an authenticator app';
+COMMENT ON COLUMN "recovery_codes"."code_hash" IS 'sha256 of the code. The code itself is shown to a user only once';
+COMMENT ON COLUMN "recovery_codes"."used_at" IS 'Time when the code was used. NULL if it is still unused';
+
+-- Second step of a login
+CREATE TABLE "mfa_challenges" (
+    "id" serial,
+    "user_id" integer NOT NULL,
+    "token_hash" bytea NOT NULL,
+    "attempts" integer NOT NULL DEFAULT 0,
+    "issued_at" timestamp NOT NULL DEFAULT (now() at time zone 'utc'),
+    "expires_at" timestamp NOT NULL,
+    "used_at" timestamp,
+    PRIMARY KEY ("id"),
+    UNIQUE ("token_hash"),
+    FOREIGN KEY ("user_id") REFERENCES "users"("id")
+);
+COMMENT ON TABLE "mfa_challenges" IS 'Tokens given after a correct password to users with 2FA. Exchanged with a code for a session';
+COMMENT ON COLUMN "mfa_challenges"."token_hash" IS 'sha256 of the token. The token itself is never stored';
+COMMENT ON COLUMN "mfa_challenges"."attempts" IS 'Number of wrong codes. The token stops working after a couple of them';
+COMMENT ON COLUMN "mfa_challenges"."used_at" IS 'Time when the token was exchanged. NULL if it is still unused';
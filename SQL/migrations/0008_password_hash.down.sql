@@ -0,0 +1,8 @@
+-- Only scrypt hashes with the old parameters can be reverted. Users with other hashes have to reset passwords
+ALTER TABLE "users" ADD COLUMN "salt" bytea;
+UPDATE "users" SET "salt" = decode(rpad(split_part("password", '$', 4), (length(split_part("password", '$', 4)) + 3) / 4 * 4, '='), 'base64');
+ALTER TABLE "users" ALTER COLUMN "password" TYPE bytea USING
+    decode(rpad(split_part("password", '$', 5), (length(split_part("password", '$', 5)) + 3) / 4 * 4, '='), 'base64');
+ALTER TABLE "users" ALTER COLUMN "salt" SET NOT NULL;
+COMMENT ON COLUMN "users"."password" IS 'Scrypt of a password';
+COMMENT ON COLUMN "users"."salt" IS 'Salt for a password';
@@ -0,0 +1,7 @@
+-- Self-describing password hashes. Existing scrypt hashes keep working and are upgraded on login
+ALTER TABLE "users" ALTER COLUMN "password" TYPE varchar(512) USING
+    '$scrypt$ln=15,r=8,p=1$' ||
+    rtrim(replace(encode("salt", 'base64'), E'\n', ''), '=') || '$' ||
+    rtrim(replace(encode("password", 'base64'), E'\n', ''), '=');
+ALTER TABLE "users" DROP COLUMN "salt";
+COMMENT ON COLUMN "users"."password" IS 'Hash of a password in the PHC string format: $algorithm$parameters$salt$hash';
@@ -0,0 +1,6 @@
+-- Hashed codes can't be restored. Users who have not confirmed an email yet need a new code
+ALTER TABLE "users" DROP COLUMN "confirmation_hash";
+ALTER TABLE "users" DROP COLUMN "confirmation_expires_at";
+ALTER TABLE "users" DROP COLUMN "confirmation_sent_at";
+ALTER TABLE "users" ADD COLUMN "confirmation_code" varchar(20) NOT NULL DEFAULT '';
+COMMENT ON COLUMN "users"."confirmation_code" IS 'Confirmation code sent to a person on registration. Empty when a person is verified.';
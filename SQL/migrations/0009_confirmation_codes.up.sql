@@ -0,0 +1,9 @@
+-- Confirmation codes are stored hashed and expire. Old codes can't be hashed here, so they stop
+-- working and users ask for new ones with POST /users/verify/resend
+ALTER TABLE "users" DROP COLUMN "confirmation_code";
+ALTER TABLE "users" ADD COLUMN "confirmation_hash" bytea;
+ALTER TABLE "users" ADD COLUMN "confirmation_expires_at" timestamp;
+ALTER TABLE "users" ADD COLUMN "confirmation_sent_at" timestamp;
+COMMENT ON COLUMN "users"."confirmation_hash" IS 'Sha256 of a confirmation code sent to a new email. NULL when there is nothing to confirm';
+COMMENT ON COLUMN "users"."confirmation_expires_at" IS 'A confirmation code is not accepted after this time';
+COMMENT ON COLUMN "users"."confirmation_sent_at" IS 'When the last confirmation email was sent. Limits how often it is resent';
@@ -0,0 +1,3 @@
+-- Emails are rendered from templates of a language which a user chooses with PUT /users/me/language
+ALTER TABLE "users" ADD COLUMN "language" varchar(8) NOT NULL DEFAULT 'en';
+COMMENT ON COLUMN "users"."language" IS 'Language of emails sent to a person. Templates are in mailer/templates/<language>';
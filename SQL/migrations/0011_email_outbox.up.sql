@@ -0,0 +1,23 @@
+-- Outgoing emails. They are inserted in the transaction of the change which causes them and
+-- delivered by background workers, so a failure of a mail service does not lose them
+CREATE TABLE "email_outbox" (
+    "id" serial,
+    "sender" varchar(256) NOT NULL,
+    "recipient" varchar(256) NOT NULL,
+    "subject" varchar(256) NOT NULL,
+    "text_body" text NOT NULL,
+    "html_body" text NOT NULL,
+    "status" varchar(20) NOT NULL DEFAULT 'pending',
+    "attempts" integer NOT NULL DEFAULT 0,
+    "next_attempt_at" timestamp NOT NULL DEFAULT (now() at time zone 'utc'),
+    "last_error" text NOT NULL DEFAULT '',
+    "created_at" timestamp NOT NULL DEFAULT (now() at time zone 'utc'),
+    PRIMARY KEY ("id"),
+    CHECK ("status" IN ('pending', 'dead'))
+);
+CREATE INDEX "email_outbox_next_attempt_at_idx" ON "email_outbox" ("next_attempt_at") WHERE "status" = 'pending';
+COMMENT ON TABLE "email_outbox" IS 'Emails which are not delivered yet. Delivered emails are removed';
+COMMENT ON COLUMN "email_outbox"."status" IS 'pending (will be delivered) or dead (failed too many times, an admin can send it again)';
+COMMENT ON COLUMN "email_outbox"."attempts" IS 'Number of failed deliveries';
+COMMENT ON COLUMN "email_outbox"."next_attempt_at" IS 'A pending email is not delivered before this time. Grows exponentially after failures';
+COMMENT ON COLUMN "email_outbox"."last_error" IS 'Why the last delivery failed';
@@ -0,0 +1,26 @@
+-- Uploaded images. An image can be used only by the user who uploaded it and only once
+CREATE TABLE "uploads" (
+    "id" serial,
+    "name" varchar(100) NOT NULL,
+    "user_id" integer NOT NULL,
+    "kind" varchar(20) NOT NULL,
+    "width" integer NOT NULL,
+    "height" integer NOT NULL,
+    "size" integer NOT NULL,
+    "hash" varchar(64) NOT NULL,
+    "created_at" timestamp NOT NULL DEFAULT (now() at time zone 'utc'),
+    "consumed_at" timestamp,
+    PRIMARY KEY ("id"),
+    UNIQUE ("name"),
+    CHECK ("kind" IN ('avatar', 'purchase')),
+    FOREIGN KEY ("user_id") REFERENCES "users"("id")
+);
+CREATE INDEX "uploads_user_id_idx" ON "uploads" ("user_id");
+COMMENT ON TABLE "uploads" IS 'Images uploaded by users. Images uploaded before this table existed are not here';
+COMMENT ON COLUMN "uploads"."name" IS 'File name of the image in the storage';
+COMMENT ON COLUMN "uploads"."kind" IS 'What the image is for: avatar or purchase';
+COMMENT ON COLUMN "uploads"."width" IS 'Width of the original upload in pixels';
+COMMENT ON COLUMN "uploads"."height" IS 'Height of the original upload in pixels';
+COMMENT ON COLUMN "uploads"."size" IS 'Size of the original upload in bytes';
+COMMENT ON COLUMN "uploads"."hash" IS 'Sha256 of the original upload in hex';
+COMMENT ON COLUMN "uploads"."consumed_at" IS 'When the image became an avatar or a picture of a purchase. NULL if it is not used yet';
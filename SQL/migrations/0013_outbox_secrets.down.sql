@@ -0,0 +1,2 @@
+DROP INDEX "email_outbox_created_at_idx";
+ALTER TABLE "email_outbox" DROP COLUMN "secret_expires_at";
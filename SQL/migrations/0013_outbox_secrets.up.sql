@@ -0,0 +1,6 @@
+-- A dead email with a confirmation code or a password reset token can be sent again while the secret is
+-- valid. After that its texts are removed, so the secrets are not kept in plain text longer than needed
+ALTER TABLE "email_outbox" ADD COLUMN "secret_expires_at" timestamp without time zone;
+COMMENT ON COLUMN "email_outbox"."secret_expires_at" IS 'When a code or a token in the texts expires. NULL if the email has no secret';
+
+CREATE INDEX "email_outbox_created_at_idx" ON "email_outbox" ("created_at", "id");
@@ -0,0 +1,85 @@
+-- create a couple of users (everyone has password: password)
+INSERT INTO users (nickname, image, about, email, password, verified) VALUES('Albert Einstein', '1467954473_isForTests.jpg', 'Developed the general theory of relativity.', 'albert@gmail.com', '$scrypt$ln=15,r=8,p=1$IV9Ml/TiCFK7OVZz0XWMzgRc3oviH8PbkjtTD1+v2gUtVrgN+CGMGe0QQc5Uja6vU0uQBfE3oJ/S8u1+r/1S/A$FXO5qMz+S7USvX5+UKdpPc32FjPqeRZE3NNUU7eDesUsfHqVcSycFakL8Z0mt50jPMvkU3GjkKT0lxHpLoK+tlrP7Zj/82xkUGMZCtEJCPQq3cI/lGxXps8jkyOn0SFOMspIFl8BkFFTl36u4DkesnjzLPWLpKrDjlXJkBhwaYo', TRUE);
+INSERT INTO users (nickname, image, about, email, password, verified) VALUES('Isaac Newton', '1467954473_isForTests.jpg', 'Mechanics, laws of motion', 'isaac@gmail.com', '$scrypt$ln=15,r=8,p=1$hGwPhTT9b2eQ/cyYmBCppx3yMLuaJmd/61ZzINP/3a3DbrMzZyqdYPSBnLrhTEYSUUkR21yudhDebEY/t9NwBA$f5jZXpBk37OmxNfvhgE+6c6GawWUH0ze3UuKfhB9XzA73Zy3DHGg9W/Fo2Qk6EazEtIQJQ/qtuTkqpxvECpY2PoBBuYB8Xz1KHgWUh5LhFR7eDLiit0DiLi4o7tO9XVXy+THHlF2QvaBSWif9r8P++zENPj+b0/uqAJhs4OT1mI', TRUE);
+INSERT INTO users (nickname, image, about, email, password, verified) VALUES('Marie Curie', '1467954473_isForTests.jpg', 'Research on radioactivity', 'marie@gmail.com', '$scrypt$ln=15,r=8,p=1$1TIuTouy3wvtZLFyo/B6OJiekfbTJzmqUZQNd1h9dOfAkGV22mtF7ohtDpo5fFRLlt6A0bTewL88e55rG6Ck9g$a4uR9zO/wG83lRY1E5aoDrqwgPkfdouZZp2MqJWFYJB9svdFDRHrrD7UsLZy4JL/T3r6nZRbez17nAqtE3KOg9au6gAjEU8gWX78q7FeaELxFf8cUVYBxGr3gcRvBQD56AYHfkFd/lOiVclA1gpnGEzdPiQIk9hxceF7cMKg4aw', TRUE);
+INSERT INTO users (nickname, image, about, email, password, verified) VALUES('Galileo Galilei', '1467954473_isForTests.jpg', 'Astronomy, heliocentrism, dynamics', 'galileo@gmail.com', '$scrypt$ln=15,r=8,p=1$rDiTl5Rc/Ue93u5N19+7RgJoIIeCoN29oRyrAz2El1sIJmtsaQsS3uAH1YdEJTJHEJk+YI8A6eQfQrboV3RnOQ$WDAHwqbwnv5j/nfDqrq5Kg+tIzVaYm0CWDZqlWvR9lTDLxGaSsJ+P9za92B0kccqR9WCq8TCRvtx2T6rSHAIsi9NDsuortGsMPltMQW9Ye3qw2ls4kmPVdrHpoiY1z41H/HNQc2fcgCSuFJp4byWNk/OHeEoF8Lzj7IGU9bm3R4', TRUE);
+INSERT INTO users (nickname, image, about, email, password, verified) VALUES('Nikola Tesla', '1467954473_isForTests.jpg', 'Alternating current. Die Edison!', 'nikola@gmail.com', '$scrypt$ln=15,r=8,p=1$pwAFPNQRKaqWfh3HvlH/ch5JPBGrs8oaRURPUQZk9FY3y0JmuZXubx6ck8F98PyWDZgXBMUzZrkfE/pk0l5cCA$D1cgXAqYwweYii9I95Q9X6dNVGwIUuco9oJ1A2HnyXdxyQiqhyZbIb6bbL7w7cZfOkOClVYnPa5jxjlG76cC+a+gFYdoBlU894imbtXu7pMlm0ofK0A3fLlIIxMlTPANdh7US84YJCGU/g2+xZpbgiWVqgSFIxHenR3zQEyw/Lo', TRUE);
+INSERT INTO users (nickname, image, about, email, password, verified) VALUES('Louis Pasteur', '1467954473_isForTests.jpg', 'Cool microbiologist. Now you know why the milk is pasteurized', 'louis@gmail.com', '$scrypt$ln=15,r=8,p=1$jFYEyDsyXdRsjwkGOofEvHjGeQYseBxzTvVX8ykaUiQBnh7OhE+TyO7ikkW/ZusqTpzjSk/LfMhxiOeLwnVO2Q$Gr6AIAMl1Hsx2nNEevaII0LuyW+W+Tg4ogKT4hO2GJqq9e4y8p+8+hJDRlWCx4TQkZ8r0kk/NVK6EC2SouHZY5/Kt1F6YcTMq6/C7ShHa6WT+mOjvhdVlrM2H8UgBNsPjvKAfqd+p3aGAJhsS4RXrCNuLWOX0GUjYbCob1ba8rQ', TRUE);
+INSERT INTO users (nickname, image, about, email, password, verified) VALUES('Stephen Hawking', '1467954473_isForTests.jpg', 'Too hard for most people to understand', 'stephen@gmail.com', '$scrypt$ln=15,r=8,p=1$OObI7EvjJ0+Rt9eOEXZEVC+X45ucoXTzL3uCLVFIU1+fBe26KiwCCOcLSwp6/6RLm8ZgzLD9Fr79h5s+rC5wcQ$Sata6B4ChghncEk8VrtdYzqkfui7ovwVh7LWdpVjoVYfsNxH+IbsTn2Hv6vNbpJ4nWu7HgKJs0PFwNjw9EesRAmCdOk1aHKBgcHmpAo1zzpLSw8okGv5QnZMRaUeq7P05EjX5/+aWoxFrbtsDIOsxYVfPLOWsfgh5CVNwtO0NLs', TRUE);
+INSERT INTO users (nickname, image, about, email, password, verified) VALUES('Charles Darwin', '1467954473_isForTests.jpg', 'Evolution theory says that you are kind of a monkey', 'charles@gmail.com', '$scrypt$ln=15,r=8,p=1$L7oub3HysaSU8P6Mm5MvI+fSWJRRThCx70PAxdR0Z2FKoaj/cPKcYpT0EBG8000zHS/4jm2NiccgHI0qRplviw$9IyRVRxL0Rd8W0X/cFzsWT7SbNsfekA8FAI3ExIus2HEthSueXW95rp9nlcvr189eQk1JYb9jHMvMQkHmkkkGwNredwWAwgiqwfRC5AnUGuYRW9/IqgXcayd6raDXr8cyxjzWCLBDT62JMqVxK2k7wGbqtHZ10z26AfPkZatLW8', TRUE);
+INSERT INTO users (nickname, image, about, email, password, verified) VALUES('Michael Faraday', '1467954473_isForTests.jpg', 'Electromagnetism, electromagnetic induction and electrolysis', 'michael@gmail.com', '$scrypt$ln=15,r=8,p=1$BLTwkdIW2MhaG9wp85WVu6pEsRkgU5cKdhHquUWWnvl3FcSs0k0K+HEWse5Pd+1f+XW6yjYcZY7J/Ohcfh3iWA$AKCsNpjK7hyvkeZ5ro/JHwXAozW20hRq4lCxbfTNUTOAhx4vU0d8/cUqZX/V7BmDjOsm+aRsBmaXC9nLujm4kw6/eqOLdv/BrBjPLzKFcyS/F5hjkhyggWMRsBH8deJdTo9LT3RpfvvCwR/THT2btXV8X47hRL/zSUE0y0beE10', TRUE);
+INSERT INTO users (nickname, about, email, password, confirmation_hash, confirmation_expires_at, confirmation_sent_at) VALUES('Johannes Kepler', 'Mathematician, astronomer. When you speak about motion of planets, you think about me', 'kepler@gmail.com', '$scrypt$ln=15,r=8,p=1$QvWLw+3+TJy1+l1Rk++UVALQF+Z4iqz76fBqEPx0bbCgAbOqiOEhDDST1Hw8y/yOQ3g4maLqcJZ6B4NVjr5rrg$RpZT4rOvL6Uti+eSI0Tq0tZ/OyAKpcGeI+1KtJp0N8QAl/eFOMuhP+EXe7YRkSWoq6Qd1/gSgmFhoE/g7eDrrOldIA/Rdp/q8FMQ9j1CgmtH+6f4WdD2HbIi7Kb7GbGplzI2Fa+9SGA8cDJXVeYeyPrDxFCYOK3uBctIYLGorzM', decode('b51ebd47b1ad8b1a6409188904646fbc5229fd16c2492ac0833caa632df5e15d', 'hex'), (now() at time zone 'utc') + interval '1 day', (now() at time zone 'utc') - interval '1 hour');
+
+-- Albert manages roles, Isaac manages brands and tags
+UPDATE users SET role = 'admin' WHERE email = 'albert@gmail.com';
+UPDATE users SET role = 'moderator' WHERE email = 'isaac@gmail.com';
+
+-- create a couple of tags
+INSERT INTO tags (name, description) VALUES('dress', 'nice dresses');
+INSERT INTO tags (name, description) VALUES('drone', 'cool flying machines that do stuff');
+INSERT INTO tags (name, description) VALUES('cosmetics', 'Known as make-up, are substances or products used to enhance the appearance or scent of the body');
+INSERT INTO tags (name, description) VALUES('car', 'Vehicles that people use to move faster');
+INSERT INTO tags (name, description) VALUES('hat', 'Stuff people put on their heads');
+INSERT INTO tags (name, description) VALUES('phone', 'People use it to speak with other people');
+
+-- create a couple of brands
+INSERT INTO brands (name) VALUES('Apple');
+INSERT INTO brands (name) VALUES('BMW');
+INSERT INTO brands (name) VALUES('Playstation');
+INSERT INTO brands (name) VALUES('Ferrari');
+INSERT INTO brands (name) VALUES('Gucci');
+INSERT INTO brands (id, name) VALUES(0, 'EMPTY BRAND');
+
+-- following each other
+INSERT INTO followers ("who_id", "whom_id") VALUES(1, 2);
+UPDATE users SET following_num = following_num + 1 WHERE id= 1;
+UPDATE users SET followers_num = followers_num + 1 WHERE id= 2;
+
+INSERT INTO followers ("who_id", "whom_id") VALUES(1, 4);
+UPDATE users SET following_num = following_num + 1 WHERE id= 1;
+UPDATE users SET followers_num = followers_num + 1 WHERE id= 4;
+
+INSERT INTO followers ("who_id", "whom_id") VALUES(1, 7);
+UPDATE users SET following_num = following_num + 1 WHERE id= 1;
+UPDATE users SET followers_num = followers_num + 1 WHERE id= 7;
+
+INSERT INTO followers ("who_id", "whom_id") VALUES(6, 2);
+UPDATE users SET following_num = following_num + 1 WHERE id= 6;
+UPDATE users SET followers_num = followers_num + 1 WHERE id= 2;
+
+-- create a few purchases
+INSERT INTO purchases (image, description, user_id, tag_ids, brand_id) VALUES('1467954439_isForTests.jpg', 'Look at my new drone', 1, '{2}', 0);
+UPDATE users SET purchases_num = purchases_num + 1 WHERE id= 1;
+
+INSERT INTO purchases (image, description, user_id, tag_ids, brand_id) VALUES('1467954439_isForTests.jpg', 'How cool am I?', 4, '{3, 5}', 5);
+UPDATE users SET purchases_num = purchases_num + 1 WHERE id= 4;
+
+INSERT INTO purchases (image, description, user_id, tag_ids, brand_id) VALUES('1467954439_isForTests.jpg', 'I really like drones', 1, '{4}', 0);
+UPDATE users SET purchases_num = purchases_num + 1 WHERE id= 1;
+
+INSERT INTO purchases (image, description, user_id, tag_ids, brand_id) VALUES('1467954439_isForTests.jpg', 'Now I am fond of cars', 1, '{2}', 4);
+UPDATE users SET purchases_num = purchases_num + 1 WHERE id= 1;
+
+-- some people like them
+INSERT INTO likes (purchase_id, user_id) VALUES(3, 4);
+UPDATE purchases SET likes_num = likes_num + 1 WHERE id= 3;
+
+INSERT INTO likes (purchase_id, user_id) VALUES(3, 6);
+UPDATE purchases SET likes_num = likes_num + 1 WHERE id= 3;
+
+INSERT INTO likes (purchase_id, user_id) VALUES(3, 9);
+UPDATE purchases SET likes_num = likes_num + 1 WHERE id= 3;
+
+INSERT INTO likes (purchase_id, user_id) VALUES(4, 2);
+UPDATE purchases SET likes_num = likes_num + 1 WHERE id= 4;
+
+-- ask questions
+INSERT INTO questions (user_id, purchase_id, name) VALUES(7, 4, 'How fast can it go?');
+UPDATE users SET questions_num = questions_num + 1 WHERE id= 7;
+
+INSERT INTO questions (user_id, purchase_id, name) VALUES(7, 1, 'What is the maximum distance from the transmitter?');
+UPDATE users SET questions_num = questions_num + 1 WHERE id= 7;
+
+-- answer the question
+INSERT INTO answers (user_id, question_id, name) VALUES(1, 2, '3 km is the maximum. To be on the safe side, use 2km');
+UPDATE users SET answers_num = answers_num + 1 WHERE id= 1;
\ No newline at end of file
@@ -2,9 +2,21 @@
 # Run all Go tests from one script. Can take ~10 seconds
 go test ./auth/
 go test ./config/
+go test ./imager/
+go test ./mailer/
+go test ./migrate/
 go test ./misc/
+go test ./psql/
+go test ./routes/
+go test ./throttle/
 go test ./models/testHelpers/
 go test ./models/brand/
+go test ./models/mfa/
+go test ./models/outbox/
 go test ./models/tag/
+go test ./models/proposal/
 go test ./models/purchase/
+go test ./models/question/
+go test ./models/session/
+go test ./models/upload/
 go test ./models/user/
@@ -4,37 +4,35 @@ import (
 	"../config"
 	"../misc"
 	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
 	"errors"
-	"fmt"
 	jwt "github.com/dgrijalva/jwt-go"
-	"golang.org/x/crypto/scrypt"
 	"strings"
 	"time"
 )
 
-// Some information about parameters
-// http://stackoverflow.com/a/30308723/1090562
-const (
-	hashN      = 32768
-	hashR      = 8
-	hashP      = 1
-	hashKeyLen = 128
-)
+const tokenLen = 32 // number of random bytes in one-time tokens sent to users
 
-// CreateJWT generates a new JWT token with full TTL
-func CreateJWT(userId int, verified bool) (string, error) {
+// CreateJWT generates a new access JWT token with full TTL for a session of a user. Regular users
+// have no role claim
+func CreateJWT(userId, sessionId int, verified bool, role string) (string, error) {
 	claims := jwt.MapClaims{
 		"id":  userId,
+		"sid": sessionId,
 		"iat": time.Now().Unix(),
-		"exp": time.Now().Add(time.Hour * 24 * time.Duration(config.Cfg.ExpDays)).Unix(),
+		"exp": time.Now().Add(time.Minute * time.Duration(config.Cfg.ExpMinutes)).Unix(),
 	}
 
 	if !verified {
 		claims["unverified"] = 1
 	}
 
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(config.Cfg.Secret)
+	if role != "" && role != misc.RoleUser {
+		claims["role"] = role
+	}
+
+	return Keys.sign(claims)
 }
 
 // ValidateJWT checks that token was not tampered with and it is not expired
@@ -47,17 +45,14 @@ func ValidateJWT(jwtToken string) (misc.JwtToken, error) {
 		return misc.JwtToken{}, errors.New("JWT token is too short")
 	}
 
-	token, err := jwt.Parse(jwtToken, func(token *jwt.Token) (interface{}, error) {
-		// Don't forget to validate the alg is what you expect:
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
-		}
-		return config.Cfg.Secret, nil
-	})
+	token, err := jwt.Parse(jwtToken, Keys.verificationKey)
+	if err != nil {
+		return misc.JwtToken{}, err
+	}
 
 	var jwtJson misc.JwtToken
 	if claims, ok := token.Claims.(jwt.MapClaims); ok && token.Valid {
-		if len(claims) < 3 || len(claims) > 4 {
+		if len(claims) < 3 || len(claims) > 6 {
 			return misc.JwtToken{}, errors.New("Token with wrong number of claims")
 		}
 		_, ok1 := claims["id"]
@@ -72,7 +67,16 @@ func ValidateJWT(jwtToken string) (misc.JwtToken, error) {
 		jwtJson.Iat = int(claims["iat"].(float64))
 		jwtJson.Exp = int(claims["exp"].(float64))
 
-		// optional claims
+		// optional claims. Tokens without a session are rejected by routes
+		if sid, ok := claims["sid"].(float64); ok {
+			jwtJson.SessionId = int(sid)
+		}
+
+		jwtJson.Role = misc.RoleUser
+		if role, ok := claims["role"].(string); ok {
+			jwtJson.Role = role
+		}
+
 		if _, ok := claims["unverified"]; ok {
 			jwtJson.Verified = false
 		} else {
@@ -85,27 +89,20 @@ func ValidateJWT(jwtToken string) (misc.JwtToken, error) {
 	return misc.JwtToken{}, err
 }
 
-// ExtendJWT takes existing JWT token, and if it is valid - issues the same one but with full TTL
-func ExtendJWT(jwtToken string) (string, error) {
-	token, err := ValidateJWT(jwtToken)
-	if err != nil {
-		return "", err
+// GenerateToken creates a cryptographically random one-time token which can be sent to a user. Only
+// the hash of the token should be stored
+func GenerateToken() (string, []byte, error) {
+	b := make([]byte, tokenLen)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
 	}
 
-	return CreateJWT(token.UserId, true)
-}
-
-// GenerateSalt generates cryptographycally random salt of a specific length
-func GenerateSalt() ([]byte, error) {
-	salt := make([]byte, config.Cfg.SaltLen)
-	if _, err := rand.Read(salt); err != nil {
-		// error means that the system's system's random number generator does not have randomness
-		return nil, err
-	}
-	return salt, nil
+	token := base64.RawURLEncoding.EncodeToString(b)
+	return token, HashToken(token), nil
 }
 
-// PasswordHash creates an scrypt hash of user password
-func PasswordHash(password string, salt []byte) ([]byte, error) {
-	return scrypt.Key([]byte(password), salt, hashN, hashR, hashP, hashKeyLen)
+// HashToken returns sha256 of a token. Tokens are random and long, so no salt is needed
+func HashToken(token string) []byte {
+	hash := sha256.Sum256([]byte(token))
+	return hash[:]
 }
@@ -0,0 +1,341 @@
+package auth
+
+import (
+	"../config"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	jwt "github.com/dgrijalva/jwt-go"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"path/filepath"
+	"sort"
+)
+
+// DefaultKid is an id of the key made from PROJ_SECRET. Tokens without a kid header were signed with it
+const DefaultKid = "default"
+
+// Keys is a key ring used to sign and validate all JWT tokens
+var Keys *KeyRing
+
+// Key is one of the keys in a key ring. HS256 keys have only a secret, RS256 and EdDSA keys have a
+// public key and a private key (only a public key if the key is used just to validate old tokens)
+type Key struct {
+	Kid     string
+	Alg     string
+	Retired bool // retired key is not used even to validate tokens
+	secret  []byte
+	private interface{}
+	public  interface{}
+}
+
+// KeyRing is a set of keys. New tokens are signed with the active key, tokens signed with any other
+// not retired key are still valid. This allows to rotate keys without logging everyone out
+type KeyRing struct {
+	active string
+	keys   map[string]*Key
+}
+
+// keyFile describes a JSON file with a key ring. Paths to PEM files are relative to the JSON file
+type keyFile struct {
+	Active string `json:"active"`
+	Keys   []struct {
+		Kid        string `json:"kid"`
+		Alg        string `json:"alg"`
+		Secret     string `json:"secret"`
+		PrivateKey string `json:"private_key_file"`
+		PublicKey  string `json:"public_key_file"`
+		Retired    bool   `json:"retired"`
+	} `json:"keys"`
+}
+
+// Init creates a key ring from a file in PROJ_JWT_KEYS. Without it PROJ_SECRET is the only key, with
+// it PROJ_SECRET stays the key DefaultKid unless the file defines it.
+// Also checks that new passwords can be hashed with PROJ_PASSWORD_HASH
+func Init() {
+	if !IsPasswordHashValid(config.Cfg.PassHash) {
+		log.Fatal("Unknown password hash algorithm ", config.Cfg.PassHash)
+	}
+
+	if config.Cfg.JwtKeys == "" {
+		Keys = NewSecretKeyRing(config.Cfg.Secret)
+		return
+	}
+
+	ring, err := LoadKeyRing(config.Cfg.JwtKeys, config.Cfg.Secret)
+	if err != nil {
+		log.Fatal(err)
+	}
+	Keys = ring
+}
+
+// NewSecretKeyRing creates a key ring with only one HS256 key
+func NewSecretKeyRing(secret []byte) *KeyRing {
+	return &KeyRing{DefaultKid, map[string]*Key{
+		DefaultKid: {Kid: DefaultKid, Alg: jwt.SigningMethodHS256.Alg(), secret: secret},
+	}}
+}
+
+// LoadKeyRing reads a key ring from a JSON file like this:
+//
+//	{"active": "2", "keys": [
+//		{"kid": "default", "alg": "HS256", "secret": "...", "retired": true},
+//		{"kid": "2", "alg": "EdDSA", "private_key_file": "2.pem"},
+//		{"kid": "3", "alg": "RS256", "public_key_file": "3.pub.pem"}
+//	]}
+//
+// If the file has no key DefaultKid, the secret becomes it, so tokens issued before the rotation stay
+// valid. To make them invalid, define the key DefaultKid as retired
+func LoadKeyRing(path string, secret []byte) (*KeyRing, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file keyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+
+	ring := &KeyRing{file.Active, map[string]*Key{}}
+	for _, v := range file.Keys {
+		if v.Kid == "" {
+			return nil, errors.New("Every key should have a kid")
+		}
+
+		if _, ok := ring.keys[v.Kid]; ok {
+			return nil, fmt.Errorf("Key %s is defined twice", v.Kid)
+		}
+
+		key := &Key{Kid: v.Kid, Alg: v.Alg, Retired: v.Retired}
+		switch v.Alg {
+		case jwt.SigningMethodHS256.Alg():
+			if v.Secret == "" {
+				return nil, fmt.Errorf("Key %s has no secret", v.Kid)
+			}
+			key.secret = []byte(v.Secret)
+		case jwt.SigningMethodRS256.Alg(), SigningMethodEdDSA.Alg():
+			if v.PrivateKey != "" {
+				if key.private, err = readPem(filepath.Join(filepath.Dir(path), v.PrivateKey), true); err != nil {
+					return nil, fmt.Errorf("Key %s: %v", v.Kid, err)
+				}
+			}
+
+			if v.PublicKey != "" {
+				if key.public, err = readPem(filepath.Join(filepath.Dir(path), v.PublicKey), false); err != nil {
+					return nil, fmt.Errorf("Key %s: %v", v.Kid, err)
+				}
+			} else if key.private != nil {
+				key.public = publicKey(key.private)
+			}
+
+			if key.public == nil {
+				return nil, fmt.Errorf("Key %s has neither private nor public key", v.Kid)
+			}
+
+			if !isKeyOfAlg(key.public, v.Alg) {
+				return nil, fmt.Errorf("Key %s is not a %s key", v.Kid, v.Alg)
+			}
+		default:
+			return nil, fmt.Errorf("Key %s has unsupported algorithm %s", v.Kid, v.Alg)
+		}
+		ring.keys[v.Kid] = key
+	}
+
+	if _, ok := ring.keys[DefaultKid]; !ok && len(secret) > 0 {
+		ring.keys[DefaultKid] = &Key{Kid: DefaultKid, Alg: jwt.SigningMethodHS256.Alg(), secret: secret}
+	}
+
+	active, ok := ring.keys[ring.active]
+	if !ok {
+		return nil, fmt.Errorf("Active key %s does not exist", ring.active)
+	}
+
+	if active.Retired || (active.secret == nil && active.private == nil) {
+		return nil, fmt.Errorf("Active key %s can't be used to sign tokens", ring.active)
+	}
+
+	return ring, nil
+}
+
+// readPem reads a PKCS8 (or PKCS1 for RSA) private key or a PKIX public key
+func readPem(path string, isPrivate bool) (interface{}, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not a PEM file", path)
+	}
+
+	if !isPrivate {
+		return x509.ParsePKIXPublicKey(block.Bytes)
+	}
+
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	return x509.ParsePKCS8PrivateKey(block.Bytes)
+}
+
+func publicKey(private interface{}) interface{} {
+	switch key := private.(type) {
+	case *rsa.PrivateKey:
+		return &key.PublicKey
+	case ed25519.PrivateKey:
+		return key.Public()
+	}
+	return nil
+}
+
+func isKeyOfAlg(public interface{}, alg string) bool {
+	switch public.(type) {
+	case *rsa.PublicKey:
+		return alg == jwt.SigningMethodRS256.Alg()
+	case ed25519.PublicKey:
+		return alg == SigningMethodEdDSA.Alg()
+	}
+	return false
+}
+
+// sign signs a token with the active key
+func (ring *KeyRing) sign(claims jwt.Claims) (string, error) {
+	key := ring.keys[ring.active]
+	token := jwt.NewWithClaims(jwt.GetSigningMethod(key.Alg), claims)
+	token.Header["kid"] = key.Kid
+
+	if key.secret != nil {
+		return token.SignedString(key.secret)
+	}
+	return token.SignedString(key.private)
+}
+
+// verificationKey finds a key to validate a token. Tokens without kid were signed with DefaultKid.
+// An algorithm of a token should be the algorithm of the key, otherwise anyone could sign a token
+// with HS256 using a public RSA key as a secret
+func (ring *KeyRing) verificationKey(token *jwt.Token) (interface{}, error) {
+	kid, ok := token.Header["kid"].(string)
+	if !ok {
+		if _, exists := token.Header["kid"]; exists {
+			return nil, errors.New("Wrong kid")
+		}
+		kid = DefaultKid
+	}
+
+	key, ok := ring.keys[kid]
+	if !ok || key.Retired {
+		return nil, fmt.Errorf("Unknown key: %s", kid)
+	}
+
+	if token.Method.Alg() != key.Alg {
+		return nil, fmt.Errorf("Unexpected signing method: %v", token.Header["alg"])
+	}
+
+	if key.secret != nil {
+		return key.secret, nil
+	}
+	return key.public, nil
+}
+
+// Jwk is a public key in JSON Web Key format https://tools.ietf.org/html/rfc7517
+type Jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+}
+
+// Jwks is a set of public keys which other services can use to validate tokens
+type Jwks struct {
+	Keys []Jwk `json:"keys"`
+}
+
+// Jwks returns public keys of all not retired asymmetric keys. HS256 secrets are never published
+func (ring *KeyRing) Jwks() Jwks {
+	jwks := Jwks{[]Jwk{}}
+	for _, kid := range ring.sortedKids() {
+		key := ring.keys[kid]
+		if key.Retired {
+			continue
+		}
+
+		switch public := key.public.(type) {
+		case *rsa.PublicKey:
+			jwks.Keys = append(jwks.Keys, Jwk{
+				Kty: "RSA", Kid: kid, Alg: key.Alg, Use: "sig",
+				N: base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+				E: base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+			})
+		case ed25519.PublicKey:
+			jwks.Keys = append(jwks.Keys, Jwk{
+				Kty: "OKP", Kid: kid, Alg: key.Alg, Use: "sig",
+				Crv: "Ed25519", X: base64.RawURLEncoding.EncodeToString(public),
+			})
+		}
+	}
+	return jwks
+}
+
+// sortedKids returns kids in a stable order, so the published set does not change between calls
+func (ring *KeyRing) sortedKids() []string {
+	kids := make([]string, 0, len(ring.keys))
+	for kid := range ring.keys {
+		kids = append(kids, kid)
+	}
+	sort.Strings(kids)
+	return kids
+}
+
+// SigningMethodEdDSA signs tokens with Ed25519 https://tools.ietf.org/html/rfc8037. jwt-go does not
+// implement it
+var SigningMethodEdDSA = &signingMethodEdDSA{}
+
+type signingMethodEdDSA struct{}
+
+func init() {
+	jwt.RegisterSigningMethod(SigningMethodEdDSA.Alg(), func() jwt.SigningMethod {
+		return SigningMethodEdDSA
+	})
+}
+
+func (m *signingMethodEdDSA) Alg() string {
+	return "EdDSA"
+}
+
+func (m *signingMethodEdDSA) Verify(signingString, signature string, key interface{}) error {
+	public, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return jwt.ErrInvalidKeyType
+	}
+
+	sig, err := jwt.DecodeSegment(signature)
+	if err != nil {
+		return err
+	}
+
+	if !ed25519.Verify(public, []byte(signingString), sig) {
+		return jwt.ErrSignatureInvalid
+	}
+	return nil
+}
+
+func (m *signingMethodEdDSA) Sign(signingString string, key interface{}) (string, error) {
+	private, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return "", jwt.ErrInvalidKeyType
+	}
+
+	return jwt.EncodeSegment(ed25519.Sign(private, []byte(signingString))), nil
+}
@@ -0,0 +1,174 @@
+package auth
+
+import (
+	"../misc"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeKeys creates a directory with an RSA and an Ed25519 private key and a public RSA key
+func writeKeys(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "jwt_keys")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	edBytes, err := x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsaPublic, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]*pem.Block{
+		"rsa.pem":     {Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)},
+		"rsa.pub.pem": {Type: "PUBLIC KEY", Bytes: rsaPublic},
+		"ed.pem":      {Type: "PRIVATE KEY", Bytes: edBytes},
+	}
+	for name, block := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), pem.EncodeToMemory(block), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return dir
+}
+
+func writeRing(t *testing.T, dir, ring string) string {
+	path := filepath.Join(dir, "keys.json")
+	if err := ioutil.WriteFile(path, []byte(ring), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadKeyRingWrong(t *testing.T) {
+	dir := writeKeys(t)
+	defer os.RemoveAll(dir)
+
+	table := []string{
+		`not a json`,
+		`{"active": "1", "keys": []}`,
+		`{"active": "1", "keys": [{"kid": "", "alg": "HS256", "secret": "abc"}]}`,
+		`{"active": "1", "keys": [{"kid": "1", "alg": "HS256", "secret": ""}]}`,
+		`{"active": "1", "keys": [{"kid": "1", "alg": "HS512", "secret": "abc"}]}`,
+		`{"active": "1", "keys": [{"kid": "1", "alg": "HS256", "secret": "abc"}, {"kid": "1", "alg": "HS256", "secret": "def"}]}`,
+		`{"active": "1", "keys": [{"kid": "1", "alg": "HS256", "secret": "abc", "retired": true}]}`,
+		`{"active": "1", "keys": [{"kid": "1", "alg": "RS256", "public_key_file": "rsa.pub.pem"}]}`,
+		`{"active": "1", "keys": [{"kid": "1", "alg": "RS256", "private_key_file": "ed.pem"}]}`,
+		`{"active": "1", "keys": [{"kid": "1", "alg": "EdDSA", "private_key_file": "missing.pem"}]}`,
+		`{"active": "1", "keys": [{"kid": "1", "alg": "EdDSA", "private_key_file": "keys.json"}]}`,
+		`{"active": "1", "keys": [{"kid": "1", "alg": "EdDSA"}]}`,
+	}
+	for num, v := range table {
+		if ring, err := LoadKeyRing(writeRing(t, dir, v), []byte("secret")); err == nil {
+			t.Errorf("Case %v. Expect a wrong key ring. Got %v", num, ring)
+		}
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	dir := writeKeys(t)
+	defer os.RemoveAll(dir)
+
+	defaultKeys := Keys
+	defer func() { Keys = defaultKeys }()
+
+	// tokens signed with the old secret before the rotation should stay valid
+	oldJwt, err := CreateJWT(1, 1, true, misc.RoleUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	table := []struct {
+		active  string
+		retired bool
+	}{
+		{"rsa", false},
+		{"ed", false},
+		{"ed", true},
+	}
+	for num, v := range table {
+		retired := "false"
+		if v.retired {
+			retired = "true"
+		}
+		ring, err := LoadKeyRing(writeRing(t, dir, `{"active": "`+v.active+`", "keys": [
+			{"kid": "default", "alg": "HS256", "secret": "`+string(defaultKeys.keys[DefaultKid].secret)+`", "retired": `+retired+`},
+			{"kid": "rsa", "alg": "RS256", "private_key_file": "rsa.pem"},
+			{"kid": "ed", "alg": "EdDSA", "private_key_file": "ed.pem"}
+		]}`), nil)
+		if err != nil {
+			t.Fatalf("Case %v. Expect a key ring. Got %v", num, err)
+		}
+		Keys = ring
+
+		jwt, err := CreateJWT(2, 3, false, misc.RoleUser)
+		if err != nil {
+			t.Fatalf("Case %v. Expect correct jwt. Got %v", num, err)
+		}
+
+		token, err := ValidateJWT(jwt)
+		if err != nil || token.UserId != 2 || token.SessionId != 3 || token.Verified {
+			t.Errorf("Case %v. Expect a valid token signed with %v. Got %v, %v", num, v.active, token, err)
+		}
+
+		if token, err := ValidateJWT(oldJwt); (err == nil) == v.retired {
+			t.Errorf("Case %v. Expect an old token to be valid only before the key is retired. Got %v, %v", num, token, err)
+		}
+
+		jwks := ring.Jwks()
+		if len(jwks.Keys) != 2 || jwks.Keys[0].Kid != "ed" || jwks.Keys[0].Kty != "OKP" || jwks.Keys[1].Kid != "rsa" || jwks.Keys[1].Kty != "RSA" || jwks.Keys[1].E != "AQAB" {
+			t.Errorf("Case %v. Expect only public keys to be published. Got %v", num, jwks)
+		}
+	}
+
+	// a key which can only validate tokens. PROJ_SECRET stays the default key of a ring without it
+	file := writeRing(t, dir, `{"active": "ed", "keys": [
+		{"kid": "rsa", "alg": "RS256", "public_key_file": "rsa.pub.pem"},
+		{"kid": "ed", "alg": "EdDSA", "private_key_file": "ed.pem"}
+	]}`)
+	if Keys, err = LoadKeyRing(file, defaultKeys.keys[DefaultKid].secret); err != nil {
+		t.Fatalf("Expect a key ring. Got %v", err)
+	}
+
+	if token, err := ValidateJWT(oldJwt); err != nil || token.UserId != 1 {
+		t.Errorf("Expect a token without kid to stay valid after the rotation. Got %v, %v", token, err)
+	}
+
+	// tokens of removed keys are not valid anymore
+	if Keys, err = LoadKeyRing(file, nil); err != nil {
+		t.Fatalf("Expect a key ring. Got %v", err)
+	}
+
+	if token, err := ValidateJWT(oldJwt); err == nil {
+		t.Errorf("Expect a token of unknown key to fail. Got %v", token)
+	}
+}
+
+func TestSecretKeyRing(t *testing.T) {
+	ring := NewSecretKeyRing([]byte("secret"))
+	if jwks := ring.Jwks(); len(jwks.Keys) != 0 {
+		t.Errorf("Expect secrets never to be published. Got %v", jwks)
+	}
+}
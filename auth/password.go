@@ -0,0 +1,204 @@
+package auth
+
+import (
+	"../config"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/scrypt"
+	"strings"
+)
+
+// Password hashes are stored in the PHC string format https://github.com/P-H-C/phc-string-format
+// together with an algorithm, its parameters and a salt, so parameters can be raised without breaking
+// old hashes:
+//
+//	$scrypt$ln=15,r=8,p=1$<salt>$<hash>
+//	$argon2id$v=19$m=65536,t=3,p=2$<salt>$<hash>
+const (
+	HashScrypt   = "scrypt"
+	HashArgon2id = "argon2id"
+)
+
+// passwordHash is a parsed hash. Only parameters of its algorithm are used
+type passwordHash struct {
+	alg     string
+	logN    int    // scrypt: log2 of the cost
+	r       int    // scrypt: block size
+	p       int    // scrypt: parallelization
+	memory  uint32 // argon2id: memory in KiB
+	time    uint32 // argon2id: number of passes
+	threads uint8  // argon2id: parallelism
+	salt    []byte
+	hash    []byte
+}
+
+// Parameters of new hashes. Some information about them
+// scrypt: http://stackoverflow.com/a/30308723/1090562
+// argon2id: https://tools.ietf.org/html/rfc9106#section-4
+var (
+	scryptParams = passwordHash{alg: HashScrypt, logN: 15, r: 8, p: 1}
+	argon2Params = passwordHash{alg: HashArgon2id, memory: 64 * 1024, time: 3, threads: 2}
+)
+
+const (
+	scryptKeyLen = 128
+	argon2KeyLen = 32
+)
+
+// Limits of parameters of stored hashes. They leave room to raise the parameters of new hashes, but a
+// hash with bigger ones is broken or malicious and would exhaust memory or CPU of a login
+const (
+	maxHashMemory    = 256 << 20 // bytes which checking one password can use
+	maxScryptLogN    = 20
+	maxScryptR       = 32
+	maxScryptP       = 4
+	maxArgon2Time    = 10
+	maxArgon2Threads = 16
+	maxKeyLen        = 256
+)
+
+// IsPasswordHashValid checks that new passwords can be hashed with an algorithm
+func IsPasswordHashValid(alg string) bool {
+	return alg == HashScrypt || alg == HashArgon2id
+}
+
+// configuredParams returns parameters of new hashes for the algorithm from PROJ_PASSWORD_HASH
+func configuredParams() passwordHash {
+	if config.Cfg.PassHash == HashArgon2id {
+		return argon2Params
+	}
+	return scryptParams
+}
+
+// GenerateSalt generates cryptographycally random salt of a specific length
+func GenerateSalt() ([]byte, error) {
+	salt := make([]byte, config.Cfg.SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		// error means that the system's system's random number generator does not have randomness
+		return nil, err
+	}
+	return salt, nil
+}
+
+// HashPassword creates a hash of user password with a new salt and current parameters. The hash
+// contains everything needed to check a password later
+func HashPassword(password string) (string, error) {
+	salt, err := GenerateSalt()
+	if err != nil {
+		return "", err
+	}
+
+	h := configuredParams()
+	h.salt = salt
+	keyLen := scryptKeyLen
+	if h.alg == HashArgon2id {
+		keyLen = argon2KeyLen
+	}
+
+	if h.hash, err = h.derive(password, keyLen); err != nil {
+		return "", err
+	}
+	return h.encode(), nil
+}
+
+// VerifyPassword checks a password against a stored hash in constant time. The second value tells
+// that the password should be hashed again, because the hash is weaker than current parameters
+func VerifyPassword(password, encoded string) (bool, bool) {
+	h, err := parsePasswordHash(encoded)
+	if err != nil {
+		return false, false
+	}
+
+	hash, err := h.derive(password, len(h.hash))
+	if err != nil || subtle.ConstantTimeCompare(hash, h.hash) != 1 {
+		return false, false
+	}
+
+	return true, h.isWeak()
+}
+
+// derive calculates a hash of a password with the parameters and the salt of h
+func (h passwordHash) derive(password string, keyLen int) ([]byte, error) {
+	if h.alg == HashArgon2id {
+		return argon2.IDKey([]byte(password), h.salt, h.time, h.memory, h.threads, uint32(keyLen)), nil
+	}
+	return scrypt.Key([]byte(password), h.salt, 1<<uint(h.logN), h.r, h.p, keyLen)
+}
+
+// isWeak checks whether new hashes are created with another algorithm, stronger parameters or a
+// longer salt
+func (h passwordHash) isWeak() bool {
+	c := configuredParams()
+	if h.alg != c.alg || len(h.salt) < config.Cfg.SaltLen {
+		return true
+	}
+
+	if h.alg == HashArgon2id {
+		return h.memory < c.memory || h.time < c.time || h.threads < c.threads || len(h.hash) < argon2KeyLen
+	}
+	return h.logN < c.logN || h.r < c.r || h.p < c.p || len(h.hash) < scryptKeyLen
+}
+
+// encode returns a hash in the PHC string format
+func (h passwordHash) encode() string {
+	salt, hash := base64.RawStdEncoding.EncodeToString(h.salt), base64.RawStdEncoding.EncodeToString(h.hash)
+	if h.alg == HashArgon2id {
+		return fmt.Sprintf("$%s$v=%d$m=%d,t=%d,p=%d$%s$%s", h.alg, argon2.Version, h.memory, h.time, h.threads, salt, hash)
+	}
+	return fmt.Sprintf("$%s$ln=%d,r=%d,p=%d$%s$%s", h.alg, h.logN, h.r, h.p, salt, hash)
+}
+
+// parsePasswordHash reads a hash in the PHC string format. Parameters are checked against the limits,
+// so a broken hash can't make derive panic, run forever or take all the memory
+func parsePasswordHash(encoded string) (passwordHash, error) {
+	parts := strings.Split(encoded, "$")
+	h := passwordHash{}
+	if len(parts) < 5 || parts[0] != "" {
+		return h, errors.New("Wrong format of a password hash")
+	}
+
+	h.alg = parts[1]
+	switch {
+	case h.alg == HashScrypt && len(parts) == 5:
+		if _, err := fmt.Sscanf(parts[2], "ln=%d,r=%d,p=%d", &h.logN, &h.r, &h.p); err != nil {
+			return h, err
+		}
+
+		if h.logN < 1 || h.logN > maxScryptLogN || h.r < 1 || h.r > maxScryptR || h.p < 1 || h.p > maxScryptP ||
+			128*h.r<<uint(h.logN) > maxHashMemory {
+			return h, errors.New("Wrong scrypt parameters")
+		}
+	case h.alg == HashArgon2id && len(parts) == 6:
+		version := 0
+		if _, err := fmt.Sscanf(parts[2], "v=%d", &version); err != nil || version != argon2.Version {
+			return h, errors.New("Unsupported argon2 version")
+		}
+
+		if _, err := fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &h.memory, &h.time, &h.threads); err != nil {
+			return h, err
+		}
+
+		if h.time < 1 || h.time > maxArgon2Time || h.threads < 1 || h.threads > maxArgon2Threads ||
+			h.memory < 8*uint32(h.threads) || h.memory > maxHashMemory/1024 {
+			return h, errors.New("Wrong argon2 parameters")
+		}
+		parts = append(parts[:2], parts[3:]...)
+	default:
+		return h, errors.New("Unknown algorithm of a password hash")
+	}
+
+	var err error
+	if h.salt, err = base64.RawStdEncoding.DecodeString(parts[3]); err != nil {
+		return h, err
+	}
+
+	if h.hash, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil || len(h.hash) == 0 || len(h.hash) > maxKeyLen {
+		return h, errors.New("Wrong hash in a password hash")
+	}
+
+	return h, nil
+}
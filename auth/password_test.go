@@ -0,0 +1,115 @@
+package auth
+
+import (
+	"../config"
+	"strings"
+	"testing"
+)
+
+func TestHashPassword(t *testing.T) {
+	defer func(alg string) { config.Cfg.PassHash = alg }(config.Cfg.PassHash)
+
+	for _, alg := range []string{HashScrypt, HashArgon2id} {
+		config.Cfg.PassHash = alg
+		hash, err := HashPassword("password")
+		if err != nil || !strings.HasPrefix(hash, "$"+alg+"$") {
+			t.Fatalf("Expect a hash of %v. Got %v, %v", alg, hash, err)
+		}
+
+		if ok, weak := VerifyPassword("password", hash); !ok || weak {
+			t.Errorf("Expect a password to match a new hash of %v. Got %v, %v", alg, ok, weak)
+		}
+
+		if ok, _ := VerifyPassword("Password", hash); ok {
+			t.Errorf("Expect another password not to match a hash of %v", alg)
+		}
+
+		if other, _ := HashPassword("password"); other == hash {
+			t.Errorf("Expect a new salt for every hash of %v", alg)
+		}
+	}
+}
+
+func TestIsWeak(t *testing.T) {
+	defer func(alg string) { config.Cfg.PassHash = alg }(config.Cfg.PassHash)
+
+	salt, long := make([]byte, config.Cfg.SaltLen), make([]byte, 128)
+	table := []struct {
+		alg  string
+		hash passwordHash
+		weak bool
+	}{
+		{HashScrypt, passwordHash{HashScrypt, 15, 8, 1, 0, 0, 0, salt, long}, false},
+		{HashScrypt, passwordHash{HashScrypt, 16, 8, 1, 0, 0, 0, salt, long}, false},
+		{HashScrypt, passwordHash{HashScrypt, 14, 8, 1, 0, 0, 0, salt, long}, true},
+		{HashScrypt, passwordHash{HashScrypt, 15, 4, 1, 0, 0, 0, salt, long}, true},
+		{HashScrypt, passwordHash{HashScrypt, 15, 8, 1, 0, 0, 0, salt[1:], long}, true},
+		{HashScrypt, passwordHash{HashScrypt, 15, 8, 1, 0, 0, 0, salt, long[:64]}, true},
+		{HashScrypt, passwordHash{HashArgon2id, 0, 0, 0, 64 * 1024, 3, 2, salt, long}, true},
+		{HashArgon2id, passwordHash{HashArgon2id, 0, 0, 0, 64 * 1024, 3, 2, salt, long}, false},
+		{HashArgon2id, passwordHash{HashArgon2id, 0, 0, 0, 32 * 1024, 3, 2, salt, long}, true},
+		{HashArgon2id, passwordHash{HashArgon2id, 0, 0, 0, 64 * 1024, 1, 2, salt, long}, true},
+		{HashArgon2id, passwordHash{HashArgon2id, 0, 0, 0, 64 * 1024, 3, 1, salt, long}, true},
+		{HashArgon2id, passwordHash{HashScrypt, 15, 8, 1, 0, 0, 0, salt, long}, true},
+	}
+	for num, v := range table {
+		config.Cfg.PassHash = v.alg
+		if weak := v.hash.isWeak(); weak != v.weak {
+			t.Errorf("Case %v. Expect %v. Got %v", num, v.weak, weak)
+		}
+	}
+}
+
+func TestParsePasswordHash(t *testing.T) {
+	defer func(alg string) { config.Cfg.PassHash = alg }(config.Cfg.PassHash)
+
+	config.Cfg.PassHash = HashArgon2id
+	hash, _ := HashPassword("password")
+	config.Cfg.PassHash = HashScrypt
+
+	h, err := parsePasswordHash(hash)
+	if err != nil || h.encode() != hash || h.memory != argon2Params.memory || len(h.salt) != config.Cfg.SaltLen {
+		t.Errorf("Expect to read a hash back. Got %v, %v", h, err)
+	}
+
+	// the password matches and the hash is weak, because new hashes use scrypt
+	if ok, weak := VerifyPassword("password", hash); !ok || !weak {
+		t.Errorf("Expect an argon2id hash to be checked. Got %v, %v", ok, weak)
+	}
+
+	table := []string{
+		"",
+		"password",
+		"$scrypt$ln=15,r=8,p=1$c2FsdA",
+		"$scrypt$ln=15,r=8,p=1$c2FsdA$",
+		"$scrypt$ln=15,r=8,p=1$c2FsdA==$aGFzaA",
+		"$scrypt$ln=0,r=8,p=1$c2FsdA$aGFzaA",
+		"$scrypt$ln=64,r=8,p=1$c2FsdA$aGFzaA",
+		"$scrypt$ln=15,r=0,p=1$c2FsdA$aGFzaA",
+		"$scrypt$ln=21,r=8,p=1$c2FsdA$aGFzaA",
+		"$scrypt$ln=20,r=8,p=1$c2FsdA$aGFzaA", // 1 GiB
+		"$scrypt$ln=15,r=1000,p=1$c2FsdA$aGFzaA",
+		"$scrypt$ln=15,r=8,p=1000000$c2FsdA$aGFzaA",
+		"$scrypt$n=32768,r=8,p=1$c2FsdA$aGFzaA",
+		"$bcrypt$ln=15,r=8,p=1$c2FsdA$aGFzaA",
+		"$argon2id$m=65536,t=3,p=2$c2FsdA$aGFzaA",
+		"$argon2id$v=16$m=65536,t=3,p=2$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=65536,t=0,p=2$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=65536,t=3,p=0$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=4294967295,t=3,p=2$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=8,t=3,p=2$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=65536,t=1000000,p=2$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=65536,t=3,p=255$c2FsdA$aGFzaA",
+		"$argon2id$v=19$m=65536,t=3,p=2$c2FsdA$!!!",
+		"$argon2id$v=19$m=65536,t=3,p=2$c2FsdA$" + strings.Repeat("A", 400),
+	}
+	for num, v := range table {
+		if _, err := parsePasswordHash(v); err == nil {
+			t.Errorf("Case %v. Expect %v to be rejected", num, v)
+		}
+
+		if ok, weak := VerifyPassword("password", v); ok || weak {
+			t.Errorf("Case %v. Expect no password to match %v", num, v)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// TOTP parameters https://tools.ietf.org/html/rfc6238. Authenticator apps support only these well
+const (
+	totpIssuer     = "Unnamed"
+	totpSecretLen  = 20 // number of random bytes in a secret (the length of SHA1)
+	totpDigits     = 6
+	totpPeriod     = 30 // seconds
+	totpSkew       = 1  // number of periods before and after the current one which are accepted
+	recoveryLen    = 10 // number of random bytes in a recovery code
+	totpCodeModulo = 1000000
+)
+
+var base32NoPadding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateTotpSecret creates a random secret shared with an authenticator app. It is base32 encoded
+func GenerateTotpSecret() (string, error) {
+	b := make([]byte, totpSecretLen)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base32NoPadding.EncodeToString(b), nil
+}
+
+// TotpUri creates an otpauth URI which authenticator apps read from a QR code
+// https://github.com/google/google-authenticator/wiki/Key-Uri-Format
+func TotpUri(account, secret string) string {
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", totpIssuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(totpDigits))
+	params.Set("period", fmt.Sprint(totpPeriod))
+	return "otpauth://totp/" + url.PathEscape(totpIssuer+":"+account) + "?" + params.Encode()
+}
+
+// totpCode calculates a code of a time step (HOTP from https://tools.ietf.org/html/rfc4226)
+func totpCode(secret []byte, counter int64) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(counter))
+
+	mac := hmac.New(sha1.New, secret)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	offset := sum[len(sum)-1] & 0x0f
+	code := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", totpDigits, code%totpCodeModulo)
+}
+
+// TotpCode returns a code which an authenticator app shows at a specific time. Empty if the secret is broken
+func TotpCode(secret string, now time.Time) string {
+	key, err := base32NoPadding.DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return ""
+	}
+	return totpCode(key, now.Unix()/totpPeriod)
+}
+
+// ValidateTotp checks a code from an authenticator app. Codes of neighbour time steps are accepted,
+// because clocks of phones are not precise. A step which is not after lastCounter is rejected, so
+// nobody can use a code twice. Returns the step of the code, which should be stored as lastCounter
+func ValidateTotp(secret, code string, now time.Time, lastCounter int64) (int64, bool) {
+	key, err := base32NoPadding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return 0, false
+	}
+
+	current := now.Unix() / totpPeriod
+	for counter := current - totpSkew; counter <= current+totpSkew; counter++ {
+		if counter <= lastCounter {
+			continue
+		}
+
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, counter)), []byte(code)) == 1 {
+			return counter, true
+		}
+	}
+
+	return 0, false
+}
+
+// GenerateRecoveryCode creates a one-time code which replaces a code from an authenticator app.
+// Only the hash of the code should be stored
+func GenerateRecoveryCode() (string, []byte, error) {
+	b := make([]byte, recoveryLen)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+
+	code := strings.ToLower(base32NoPadding.EncodeToString(b))
+	return code, HashToken(code), nil
+}
@@ -0,0 +1,108 @@
+package auth
+
+import (
+	"encoding/base32"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is a secret from test vectors of https://tools.ietf.org/html/rfc6238#appendix-B
+var rfcSecret = base32NoPadding.EncodeToString([]byte("12345678901234567890"))
+
+func TestTotpCode(t *testing.T) {
+	// RFC vectors have 8 digits, 6 digit codes are their last digits
+	table := []struct {
+		unix int64
+		code string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+	for _, v := range table {
+		if code := totpCode([]byte("12345678901234567890"), v.unix/totpPeriod); code != v.code {
+			t.Errorf("Expect %v at %v. Got %v", v.code, v.unix, code)
+		}
+	}
+}
+
+func TestValidateTotp(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+	step := now.Unix() / totpPeriod
+
+	table := []struct {
+		code        string
+		lastCounter int64
+		counter     int64
+		ok          bool
+	}{
+		{"081804", 0, step, true},
+		{"081804", step - 1, step, true},
+		{"081804", step, 0, false},
+		{totpCode([]byte("12345678901234567890"), step-1), 0, step - 1, true},
+		{totpCode([]byte("12345678901234567890"), step+1), 0, step + 1, true},
+		{totpCode([]byte("12345678901234567890"), step-2), 0, 0, false},
+		{totpCode([]byte("12345678901234567890"), step+2), 0, 0, false},
+		{"081805", 0, 0, false},
+		{"81804", 0, 0, false},
+		{"", 0, 0, false},
+	}
+	for num, v := range table {
+		if counter, ok := ValidateTotp(rfcSecret, v.code, now, v.lastCounter); ok != v.ok || counter != v.counter {
+			t.Errorf("Case %v. Expect %v, %v. Got %v, %v", num, v.counter, v.ok, counter, ok)
+		}
+	}
+
+	if _, ok := ValidateTotp("not base32!", "081804", now, 0); ok {
+		t.Error("Expect a broken secret not to validate anything")
+	}
+
+	if code := TotpCode(rfcSecret, now); code != "081804" {
+		t.Errorf("Expect a code of an app. Got %v", code)
+	}
+
+	if code := TotpCode("not base32!", now); code != "" {
+		t.Errorf("Expect no code for a broken secret. Got %v", code)
+	}
+}
+
+func TestGenerateTotpSecret(t *testing.T) {
+	secret, err := GenerateTotpSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if b, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret); err != nil || len(b) != totpSecretLen {
+		t.Errorf("Expect %v base32 bytes. Got %v, %v", totpSecretLen, secret, err)
+	}
+
+	uri, err := url.Parse(TotpUri("albert@gmail.com", secret))
+	if err != nil || uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Query().Get("secret") != secret {
+		t.Errorf("Expect an otpauth uri. Got %v, %v", uri, err)
+	}
+
+	if !strings.HasSuffix(uri.Path, ":albert@gmail.com") || uri.Query().Get("issuer") != totpIssuer {
+		t.Errorf("Expect an account and an issuer in the uri. Got %v", uri)
+	}
+}
+
+func TestGenerateRecoveryCode(t *testing.T) {
+	seen := map[string]bool{}
+	for i := 0; i < 20; i++ {
+		code, hash, err := GenerateRecoveryCode()
+		if err != nil || len(code) != 16 || seen[code] {
+			t.Errorf("Expect a new code of 16 characters. Got %v, %v", code, err)
+		}
+		seen[code] = true
+
+		if !reflect.DeepEqual(hash, HashToken(code)) {
+			t.Errorf("Hash %v does not correspond to code %v", hash, code)
+		}
+	}
+}
@@ -9,20 +9,41 @@ import (
 
 // Config stores environment variables
 type Config struct {
-	DbName      string // name of the psql database
-	DbUser      string // user of the psql database
-	DbHost      string // psql host
-	DbPass      string // psql password
-	DbPort      int    // psql port
-	HttpPort    int    // http server port
-	Secret      []byte // a key with which JWT token is signed
-	ExpDays     int    // for how long is JWT token valid
-	SaltLen     int    // the length of the salt of user password (hashed with scrypt)
-	MailDomain  string // domain name of the mailgun
-	MailPrivate string // private key for the mailgun
-	MailPublic  string // public key for the mailgun
-	IsTest      bool   // whether this is a testing environment. Some functions behave differently
-	TestEmail   string // all mail to all users will be sent to this address in test environments
+	DbName        string // name of the psql database
+	DbUser        string // user of the psql database
+	DbHost        string // psql host
+	DbPass        string // psql password
+	DbPort        int    // psql port
+	HttpPort      int    // http server port
+	Secret        []byte // a key with which JWT token is signed (if JwtKeys is not specified)
+	JwtKeys       string // path to a JSON file with a key ring to sign JWT tokens. Optional
+	ExpDays       int    // for how long is a session valid (how long a user can stay logged in)
+	ExpMinutes    int    // for how long is an access JWT token valid
+	SaltLen       int    // the length of the salt of user password
+	PassHash      string // algorithm of new password hashes: scrypt or argon2id
+	LoginStore    string // where failed logins are counted: memory (one instance) or postgres (many instances)
+	MailTransport string // how emails are sent: mailgun, smtp, dir or memory
+	MailDomain    string // domain name of the mailgun
+	MailPrivate   string // private key for the mailgun
+	MailPublic    string // public key for the mailgun
+	SmtpAddr      string // host:port of an SMTP server
+	SmtpUser      string // user of the SMTP server. Without it emails are sent without authentication
+	SmtpPass      string // password of the SMTP server
+	MailDir       string // directory where the dir transport writes .eml files
+	MailTemplates string // directory with templates of emails
+	MailWorkers   int    // number of goroutines which deliver emails from the outbox
+	MailAttempts  int    // after so many failed deliveries an email is dead and waits for an admin
+	Storage       string // where images are kept: local or s3
+	StorageDir    string // root directory of the local storage
+	S3Endpoint    string // URL of S3 or of a compatible service, like http://localhost:9000
+	S3Region      string // region which requests to S3 are signed for
+	S3Bucket      string // bucket with images
+	S3AccessKey   string // access key of S3
+	S3SecretKey   string // secret key of S3
+	ImageGrace    int    // hours after which an image nobody uses and without an upload record is removed
+	ImageGcEvery  int    // hours between two removals of unused images. 0 disables them
+	IsTest        bool   // whether this is a testing environment. Some functions behave differently
+	TestEmail     string // all mail to all users will be sent to this address in test environments
 }
 
 var Cfg Config
@@ -37,13 +58,34 @@ func Init() {
 		GetEnvInt("PROJ_DB_PORT"),
 		GetEnvInt("PROJ_HTTP_PORT"),
 		[]byte(GetEnvStr("PROJ_SECRET")),
+		GetEnvStrDefault("PROJ_JWT_KEYS", ""),
 		GetEnvInt("PROJ_JWT_EXP_DAYS"),
+		GetEnvInt("PROJ_JWT_EXP_MINUTES"),
 		GetEnvInt("PROJ_SALT_LEN_BYTE"),
-		GetEnvStr("PROJ_MAILGUN_DOMAIN"),
-		GetEnvStr("PROJ_MAILGUN_PRIVATE"),
-		GetEnvStr("PROJ_MAILGUN_PUBLIC"),
+		GetEnvStrDefault("PROJ_PASSWORD_HASH", "scrypt"),
+		GetEnvStrDefault("PROJ_LOGIN_STORE", "memory"),
+		GetEnvStrDefault("PROJ_MAIL_TRANSPORT", "mailgun"),
+		GetEnvStrDefault("PROJ_MAILGUN_DOMAIN", ""),
+		GetEnvStrDefault("PROJ_MAILGUN_PRIVATE", ""),
+		GetEnvStrDefault("PROJ_MAILGUN_PUBLIC", ""),
+		GetEnvStrDefault("PROJ_SMTP_ADDR", ""),
+		GetEnvStrDefault("PROJ_SMTP_USER", ""),
+		GetEnvStrDefault("PROJ_SMTP_PASS", ""),
+		GetEnvStrDefault("PROJ_MAIL_DIR", "emails"),
+		GetEnvStrDefault("PROJ_MAIL_TEMPLATES", "mailer/templates"),
+		GetEnvIntDefault("PROJ_MAIL_WORKERS", 2),
+		GetEnvIntDefault("PROJ_MAIL_MAX_ATTEMPTS", 8),
+		GetEnvStrDefault("PROJ_STORAGE", "local"),
+		GetEnvStrDefault("PROJ_STORAGE_DIR", "images"),
+		GetEnvStrDefault("PROJ_S3_ENDPOINT", ""),
+		GetEnvStrDefault("PROJ_S3_REGION", "us-east-1"),
+		GetEnvStrDefault("PROJ_S3_BUCKET", ""),
+		GetEnvStrDefault("PROJ_S3_ACCESS_KEY", ""),
+		GetEnvStrDefault("PROJ_S3_SECRET_KEY", ""),
+		GetEnvIntDefault("PROJ_IMAGE_GRACE_HOURS", 24),
+		GetEnvIntDefault("PROJ_IMAGE_GC_HOURS", 6),
 		GetEnvBool("PROJ_IS_TEST"),
-		GetEnvStr("PROJ_TEST_EMAIL"),
+		GetEnvStrDefault("PROJ_TEST_EMAIL", ""),
 	}
 	Cfg = cfg
 }
@@ -57,6 +99,14 @@ func GetEnvStr(key string) string {
 	return val
 }
 
+// GetEnvStrDefault returns a environment variable as a string or a default value if it does not exist
+func GetEnvStrDefault(key, defaultVal string) string {
+	if val := os.Getenv(key); val != "" {
+		return val
+	}
+	return defaultVal
+}
+
 // GetEnvInt returns a environment variable as an integer. Panics if it is not an integer
 func GetEnvInt(key string) int {
 	val, err := strconv.Atoi(GetEnvStr(key))
@@ -66,6 +116,15 @@ func GetEnvInt(key string) int {
 	return val
 }
 
+// GetEnvIntDefault returns a environment variable as an integer or a default value if it does not
+// exist. Panics if it is not an integer
+func GetEnvIntDefault(key string, defaultVal int) int {
+	if os.Getenv(key) == "" {
+		return defaultVal
+	}
+	return GetEnvInt(key)
+}
+
 // GetEnvBool returns a environment variable as a boolean. Accepts only true, false. Panics otherwise
 func GetEnvBool(key string) bool {
 	val, err := strconv.ParseBool(GetEnvStr(key))
@@ -23,6 +23,18 @@ func TestEnvStrMissing(t *testing.T) {
 	GetEnvStr("PROJ_FAKE_ENV")
 }
 
+func TestEnvStrDefault(t *testing.T) {
+	os.Setenv("PROJ_FAKE_ENV", "randomness")
+	if v := GetEnvStrDefault("PROJ_FAKE_ENV", "default"); v != "randomness" {
+		t.Errorf("Expected 'randomness', got %s", v)
+	}
+
+	os.Unsetenv("PROJ_FAKE_ENV")
+	if v := GetEnvStrDefault("PROJ_FAKE_ENV", "default"); v != "default" {
+		t.Errorf("Expected 'default', got %s", v)
+	}
+}
+
 func TestRequiredIntEnvIsPresent(t *testing.T) {
 	os.Setenv("PROJ_FAKE_ENV", "123456")
 	if v := GetEnvInt("PROJ_FAKE_ENV"); v != 123456 {
@@ -50,6 +62,18 @@ func TestRequiredIntEnvIsMissing(t *testing.T) {
 	GetEnvInt("PROJ_FAKE_ENV")
 }
 
+func TestIntEnvDefault(t *testing.T) {
+	os.Setenv("PROJ_FAKE_ENV", "123456")
+	if v := GetEnvIntDefault("PROJ_FAKE_ENV", 5); v != 123456 {
+		t.Errorf("Expected integer 123456, got %d", v)
+	}
+
+	os.Unsetenv("PROJ_FAKE_ENV")
+	if v := GetEnvIntDefault("PROJ_FAKE_ENV", 5); v != 5 {
+		t.Errorf("Expected default 5, got %d", v)
+	}
+}
+
 func TestRequiredBoolEnvIsPresent(t *testing.T) {
 	os.Setenv("PROJ_FAKE_ENV", "true")
 	if v := GetEnvBool("PROJ_FAKE_ENV"); !v {
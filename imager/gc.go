@@ -0,0 +1,208 @@
+package imager
+
+import (
+	"../misc"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// tmpGrace is the age after which a temporary file is a leftover of a failed upload
+const tmpGrace = time.Hour
+
+// GcReport describes what a garbage collection has removed (or would remove in a dry run)
+type GcReport struct {
+	Images []string // keys of images in the storage
+	Tmp    []string // temporary files
+	Bytes  int64    // total size of the removed files
+}
+
+// remover removes a file of an image unless the image became used after the list of used images
+// was made. Returns whether the file is removed
+type remover func(key, name string) (bool, error)
+
+// Collect removes images which no user and no purchase refer to. An upload is kept while it can be
+// used (misc.UploadTtl days), an image without an upload record is kept for a grace period, because
+// its file is stored a moment before the record. Leftovers of failed uploads are removed from the
+// temporary directory. Nothing is removed in a dry run
+func Collect(db *sql.DB, grace time.Duration, dryRun bool) (GcReport, error) {
+	referenced, err := referencedImages(db)
+	if err != nil {
+		return GcReport{}, err
+	}
+
+	return collect(referenced, removeIfUnused(db), tmpDir, time.Now(), grace, dryRun)
+}
+
+// usedImages selects images of users, of purchases and uploads which can still be used
+const usedImages = `
+	SELECT image FROM users WHERE image <> ''
+	UNION
+	SELECT image FROM purchases WHERE image <> ''
+	UNION
+	SELECT name FROM uploads
+	WHERE consumed_at IS NULL AND created_at >= (now() at time zone 'utc') - $1 * interval '1 day'`
+
+// referencedImages returns names of all images in use
+func referencedImages(db *sql.DB) (map[string]bool, error) {
+	rows, err := db.Query(usedImages, misc.UploadTtl)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	referenced := map[string]bool{}
+	for rows.Next() {
+		image := ""
+		if err := rows.Scan(&image); err != nil {
+			return nil, err
+		}
+		referenced[image] = true
+	}
+
+	return referenced, rows.Err()
+}
+
+// removeIfUnused checks an image again right before its file is removed. An image becomes used only
+// when its upload is consumed, so the upload is locked until the file is removed
+func removeIfUnused(db *sql.DB) remover {
+	return func(key, name string) (bool, error) {
+		tx, err := db.Begin()
+		if err != nil {
+			return false, err
+		}
+		defer tx.Rollback()
+
+		if _, err := tx.Exec(`SELECT id FROM uploads WHERE name = $1 FOR UPDATE`, name); err != nil {
+			return false, err
+		}
+
+		isUsed := false
+		if err := tx.QueryRow(`
+			SELECT EXISTS (
+				SELECT 1
+				FROM (`+usedImages+`) AS used
+				WHERE image = $2
+			)`, misc.UploadTtl, name,
+		).Scan(&isUsed); err != nil {
+			return false, err
+		}
+
+		if isUsed {
+			return false, nil
+		}
+
+		if err := Store.Delete(key); err != nil {
+			return false, err
+		}
+		return true, tx.Commit()
+	}
+}
+
+// collect removes files of the storage and of a temporary directory which are not referenced. Only
+// names of uploaded images are considered, so README files and fixtures of tests stay
+func collect(referenced map[string]bool, remove remover, tmp string, now time.Time, grace time.Duration, dryRun bool) (GcReport, error) {
+	report := GcReport{[]string{}, []string{}, 0}
+	for location := range servedLocations {
+		files, err := Store.List(location)
+		if err != nil {
+			return report, err
+		}
+
+		for _, file := range files {
+			name := strings.TrimPrefix(file.Key, location)
+			if _, ok := mimeOfFile(name); !ok || strings.Contains(name, "/") || strings.HasSuffix(name, "_isForTests.jpg") {
+				continue
+			}
+
+			if referenced[name] || now.Sub(file.ModTime) < grace {
+				continue
+			}
+
+			if !dryRun {
+				if removed, err := remove(file.Key, name); err != nil {
+					return report, err
+				} else if !removed {
+					continue
+				}
+			}
+			report.Images = append(report.Images, file.Key)
+			report.Bytes += file.Size
+		}
+	}
+
+	sort.Strings(report.Images)
+
+	files, err := ioutil.ReadDir(tmp)
+	if err != nil && !os.IsNotExist(err) {
+		return report, err
+	}
+
+	for _, file := range files {
+		if file.IsDir() || file.Name() == "README.md" || now.Sub(file.ModTime()) < tmpGrace {
+			continue
+		}
+
+		path := filepath.Join(tmp, file.Name())
+		if !dryRun {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				return report, err
+			}
+		}
+		report.Tmp = append(report.Tmp, path)
+		report.Bytes += file.Size()
+	}
+
+	return report, nil
+}
+
+// StartGc runs a garbage collection every interval in the background until the process exits
+func StartGc(db *sql.DB, interval, grace time.Duration) {
+	go func() {
+		for range time.Tick(interval) {
+			if report, err := Collect(db, grace, false); err != nil {
+				log.Println("Image gc failed", err)
+			} else if len(report.Images)+len(report.Tmp) > 0 {
+				log.Printf("Image gc removed %d images, %d temporary files, %d bytes", len(report.Images), len(report.Tmp), report.Bytes)
+			}
+		}
+	}()
+}
+
+// Command executes images subcommand of the server: `gc [--dry-run]` removes images nobody uses and
+// prints them
+func Command(db *sql.DB, grace time.Duration, args []string, out io.Writer) error {
+	usage := errors.New("usage: images gc [--dry-run]")
+	if len(args) == 0 || args[0] != "gc" || len(args) > 2 || (len(args) == 2 && args[1] != "--dry-run") {
+		return usage
+	}
+
+	dryRun := len(args) == 2
+	report, err := Collect(db, grace, dryRun)
+	printReport(report, dryRun, out)
+	return err
+}
+
+// printReport lists removed files and the total
+func printReport(report GcReport, dryRun bool, out io.Writer) {
+	verb := "removed"
+	if dryRun {
+		verb = "would remove"
+	}
+
+	for _, key := range report.Images {
+		fmt.Fprintf(out, "%s %s\n", verb, key)
+	}
+	for _, path := range report.Tmp {
+		fmt.Fprintf(out, "%s %s\n", verb, path)
+	}
+	fmt.Fprintf(out, "%s %d images, %d temporary files, %d bytes\n", verb, len(report.Images), len(report.Tmp), report.Bytes)
+}
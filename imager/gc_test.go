@@ -0,0 +1,104 @@
+package imager
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCollect(t *testing.T) {
+	dir, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defaultStore := Store
+	defer func() { Store = defaultStore }()
+
+	Store = NewLocalStorage(filepath.Join(dir, "images"))
+	tmp := filepath.Join(dir, "tmp")
+	os.Mkdir(tmp, 0755)
+
+	old := time.Now().Add(-48 * time.Hour)
+	for _, key := range []string{
+		"avatars/b/1_used.jpg", "avatars/s/1_used.jpg", // a user refers to it
+		"avatars/b/2_unused.jpg", "avatars/s/2_unused.jpg",
+		"purchases/b/3_unused.png", "purchases/m/3_unused.png",
+		"purchases/m/4_used.webp",                               // a purchase refers to it
+		"purchases/m/README.md", "purchases/m/1_isForTests.jpg", // never removed
+	} {
+		Store.Put(key, []byte("image"))
+		os.Chtimes(filepath.Join(dir, "images", key), old, old)
+	}
+	Store.Put("purchases/m/5_fresh.jpg", []byte("a purchase is being created"))
+
+	for _, name := range []string{"6_leftover", "README.md"} {
+		ioutil.WriteFile(filepath.Join(tmp, name), []byte("tmp"), 0644)
+		os.Chtimes(filepath.Join(tmp, name), old, old)
+	}
+	ioutil.WriteFile(filepath.Join(tmp, "7_uploading"), []byte("tmp"), 0644)
+
+	referenced := map[string]bool{"1_used.jpg": true, "4_used.webp": true}
+	expected := "avatars/b/2_unused.jpg,avatars/s/2_unused.jpg,purchases/b/3_unused.png,purchases/m/3_unused.png"
+
+	// 3_unused.png is used by a purchase created after the list of used images was made
+	remove := func(key, name string) (bool, error) {
+		if name == "3_unused.png" {
+			return false, nil
+		}
+		return true, Store.Delete(key)
+	}
+
+	// a dry run only reports
+	report, err := collect(referenced, remove, tmp, time.Now(), 24*time.Hour, true)
+	if err != nil || strings.Join(report.Images, ",") != expected || len(report.Tmp) != 1 || report.Bytes != 23 {
+		t.Errorf("Expect a report of unused files. Got %v, %v", report, err)
+	}
+
+	if files, _ := Store.List(""); len(files) != 10 {
+		t.Errorf("Expect a dry run not to remove anything. Got %v", files)
+	}
+
+	report, err = collect(referenced, remove, tmp, time.Now(), 24*time.Hour, false)
+	if err != nil || strings.Join(report.Images, ",") != "avatars/b/2_unused.jpg,avatars/s/2_unused.jpg" ||
+		len(report.Tmp) != 1 || report.Tmp[0] != filepath.Join(tmp, "6_leftover") {
+		t.Errorf("Expect unused files to be removed. Got %v, %v", report, err)
+	}
+
+	files, _ := Store.List("")
+	keys := []string{}
+	for _, file := range files {
+		keys = append(keys, file.Key)
+	}
+	if len(keys) != 8 || strings.Contains(strings.Join(keys, ","), "2_unused") {
+		t.Errorf("Expect used, fresh and not uploaded files to stay. Got %v", keys)
+	}
+
+	if left, _ := ioutil.ReadDir(tmp); len(left) != 2 {
+		t.Errorf("Expect fresh temporary files to stay. Got %v", left)
+	}
+
+	// nothing is left to remove
+	if report, err := collect(referenced, remove, tmp, time.Now(), 24*time.Hour, false); err != nil || len(report.Images)+len(report.Tmp) != 0 {
+		t.Errorf("Expect nothing to remove. Got %v, %v", report, err)
+	}
+}
+
+func TestGcCommand(t *testing.T) {
+	for _, args := range [][]string{{}, {"collect"}, {"gc", "--force"}, {"gc", "--dry-run", "x"}} {
+		if err := Command(nil, time.Hour, args, ioutil.Discard); err == nil {
+			t.Errorf("Expect an error of %v", args)
+		}
+	}
+
+	var out bytes.Buffer
+	printReport(GcReport{[]string{"avatars/b/1_a.jpg"}, []string{}, 10}, true, &out)
+	if out.String() != "would remove avatars/b/1_a.jpg\nwould remove 1 images, 0 temporary files, 10 bytes\n" {
+		t.Errorf("Expect a report. Got %q", out.String())
+	}
+}
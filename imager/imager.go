@@ -2,13 +2,14 @@ package imager
 
 import (
 	"../misc"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	bimg "gopkg.in/h2non/bimg.v1"
 	"io"
 	"log"
 	"net/http"
 	"os"
-	"strconv"
 	"strings"
 	"time"
 )
@@ -34,9 +35,12 @@ var mimeToExtension = map[string]string{
 	"image/webp": ".webp",
 }
 
+// tmpDir is a local directory where uploads are resized before they are put in the storage
+const tmpDir = "images/tmp/"
+
 // getTmpLocation is a helper which returns a location of a temporary file
 func getTmpLocation(fileName string) string {
-	return "images/tmp/" + fileName
+	return tmpDir + fileName
 }
 
 // SaveTmpFileFromClient checks that the file is below the maximum possible size in Kb and
@@ -94,27 +98,34 @@ func SaveTmpFileFromClient(w http.ResponseWriter, r *http.Request) (bool, string
 	return true, fileName, ext
 }
 
-// checkTmpFileImgSize makes sure that the dimensions of the temporary image are above min height/width
-func checkTmpFileImgSize(fileName string, minHeight, minWidth int) (bool, *bimg.Image) {
+// checkTmpFileImgSize makes sure that the dimensions of the temporary image are above min height/width.
+// Returns the image and the description of the original upload
+func checkTmpFileImgSize(fileName string, minHeight, minWidth int) (bool, *bimg.Image, misc.Upload) {
 	buffer, err := bimg.Read(getTmpLocation(fileName))
 	if err != nil {
 		log.Println(err)
-		return false, nil
+		return false, nil, misc.Upload{}
 	}
 
 	img := bimg.NewImage(buffer)
 	sizeInfo, err := img.Size()
 	if err != nil {
 		log.Println(err)
-		return false, nil
+		return false, nil, misc.Upload{}
 	}
 
 	if sizeInfo.Width < minWidth || sizeInfo.Height < minHeight {
 		log.Println("Image size is too small", sizeInfo)
-		return false, nil
+		return false, nil, misc.Upload{}
 	}
 
-	return true, img
+	sum := sha256.Sum256(buffer)
+	return true, img, misc.Upload{
+		Width:  sizeInfo.Width,
+		Height: sizeInfo.Height,
+		Size:   len(buffer),
+		Hash:   hex.EncodeToString(sum[:]),
+	}
 }
 
 // findBestDimensions finds the most suitable dimensions for the resize of original image.
@@ -138,83 +149,97 @@ func findBestDimensions(imgHeight, imgWidth, maxHeight, maxWidth int) (bool, int
 }
 
 // TmpToAvatar converts a temporary file into a correctly resized avatar. Removes tmp file
-func TmpToAvatar(fileName, ext string) (bool, string) {
-	ok, img := checkTmpFileImgSize(fileName, avatarBig, avatarBig)
+func TmpToAvatar(fileName, ext string) (bool, misc.Upload) {
+	ok, img, upload := checkTmpFileImgSize(fileName, avatarBig, avatarBig)
 	fullFileName := fileName + ext
 	os.Remove(getTmpLocation(fileName))
 	if !ok {
-		return false, ""
+		return false, misc.Upload{}
 	}
 
-	newImage, err := img.Thumbnail(avatarBig)
-	if err != nil {
-		log.Println(err)
-		return false, ""
+	keys, images := []string{}, [][]byte{}
+	for _, v := range []struct {
+		location string
+		size     int
+	}{{"avatars/b/", avatarBig}, {"avatars/s/", avatarSmall}} {
+		newImage, err := img.Thumbnail(v.size)
+		if err != nil {
+			log.Println(err)
+			return false, misc.Upload{}
+		}
+		keys, images = append(keys, v.location+fullFileName), append(images, newImage)
 	}
-	bimg.Write("images/avatars/b/"+fullFileName, newImage)
 
-	newImage, err = img.Thumbnail(avatarSmall)
-	if err != nil {
-		log.Println(err)
-		return false, ""
+	if !storeAll(keys, images) {
+		return false, misc.Upload{}
 	}
-	bimg.Write("images/avatars/s/"+fullFileName, newImage)
 
-	return true, fullFileName
+	upload.Name, upload.Kind = fullFileName, misc.UploadAvatar
+	return true, upload
 }
 
-// TmpToAvatar converts a temporary file into a correctly resized purchase. Removes tmp file
-func TmpToPurchase(fileName, ext string) (bool, string) {
-	ok, img := checkTmpFileImgSize(fileName, minImgHeight, minImgWidth)
+// TmpToPurchase converts a temporary file into a correctly resized purchase. Removes tmp file
+func TmpToPurchase(fileName, ext string) (bool, misc.Upload) {
+	ok, img, upload := checkTmpFileImgSize(fileName, minImgHeight, minImgWidth)
 	fullFileName := fileName + ext
 	os.Remove(getTmpLocation(fileName))
 	if !ok {
-		os.Remove(getTmpLocation(fileName))
-		return false, ""
+		return false, misc.Upload{}
 	}
 
 	sizeInfo, _ := img.Size()
 	imgHeight, imgWidth := sizeInfo.Height, sizeInfo.Width
 
-	if ok, h, w := findBestDimensions(imgHeight, imgWidth, imgBigHeight, imgBigWidth); ok {
-		if newImage, err := img.Resize(w, h); err != nil {
-			log.Println(err)
-			os.Remove(getTmpLocation(fileName))
-			return false, ""
-		} else {
-			bimg.Write("images/purchases/b/"+fullFileName, newImage)
+	keys, images := []string{}, [][]byte{}
+	for _, v := range []struct {
+		location      string
+		height, width int
+	}{{"purchases/b/", imgBigHeight, imgBigWidth}, {"purchases/m/", imgNormalHeight, imgNormalWidth}} {
+		if ok, h, w := findBestDimensions(imgHeight, imgWidth, v.height, v.width); ok {
+			newImage, err := img.Resize(w, h)
+			if err != nil {
+				log.Println(err)
+				return false, misc.Upload{}
+			}
+			keys, images = append(keys, v.location+fullFileName), append(images, newImage)
 		}
 	}
 
-	if ok, h, w := findBestDimensions(imgHeight, imgWidth, imgNormalHeight, imgNormalWidth); ok {
-		if newImage, err := img.Resize(w, h); err != nil {
-			log.Println(err)
-			os.Remove(getTmpLocation(fileName))
-			return false, ""
-		} else {
-			bimg.Write("images/purchases/m/"+fullFileName, newImage)
-		}
+	if !storeAll(keys, images) {
+		return false, misc.Upload{}
 	}
 
-	return true, fullFileName
+	upload.Name, upload.Kind = fullFileName, misc.UploadPurchase
+	return true, upload
 }
 
-// verifyFile checks that the file exists at a specific location and was created in a right time
-func verifyFile(fileName, location string) bool {
-	if _, err := os.Stat(location + fileName); err == nil {
-		// file exist. Check when it was generated
-		timestamp, err := strconv.ParseInt(strings.Split(fileName, "_")[0], 10, 64)
-		if err != nil {
+// storeAll puts resized versions of an image in the storage. If one of them can't be stored, the
+// already stored ones are deleted, so a failed upload leaves nothing behind
+func storeAll(keys []string, images [][]byte) bool {
+	for i, key := range keys {
+		if err := Store.Put(key, images[i]); err != nil {
+			log.Println(err)
+			for _, stored := range keys[:i] {
+				if err := Store.Delete(stored); err != nil {
+					log.Println(err)
+				}
+			}
 			return false
 		}
+	}
+	return true
+}
 
-		now := time.Now()
-		// make sure that the image was created somewhere in between Now and one day before Now
-		return now.AddDate(0, -1, 0).Unix() <= timestamp && timestamp <= now.Unix()
+// verifyFile checks that the file exists at a specific location of the storage. Who uploaded it and
+// when is checked by the uploads model
+func verifyFile(fileName, location string) bool {
+	if strings.ContainsAny(fileName, "/\\") {
+		return false
 	}
 
-	// file does not exist
-	return false
+	// the file does not exist or the storage is not available
+	_, err := Store.Stat(location + fileName)
+	return err == nil
 }
 
 // IsAvatarValid makes sure that path to avatar is valid. Empty avatar is also valid
@@ -223,10 +248,33 @@ func IsAvatarValid(fileName string) bool {
 		return true
 	}
 
-	return verifyFile(fileName, "images/avatars/b/")
+	return verifyFile(fileName, "avatars/b/")
 }
 
 // IsPurchaseValid makes sure that path to avatar is valid
 func IsPurchaseValid(fileName string) bool {
-	return verifyFile(fileName, "images/purchases/m/")
+	return verifyFile(fileName, "purchases/m/")
+}
+
+// RemoveAvatar removes all resized versions of an avatar
+func RemoveAvatar(fileName string) {
+	remove(fileName, "avatars/b/", "avatars/s/")
+}
+
+// RemovePurchase removes all resized versions of a picture of a purchase
+func RemovePurchase(fileName string) {
+	remove(fileName, "purchases/b/", "purchases/m/")
+}
+
+func remove(fileName string, locations ...string) {
+	if fileName == "" || strings.ContainsAny(fileName, "/\\") {
+		log.Println("Wrong file name", fileName)
+		return
+	}
+
+	for _, location := range locations {
+		if err := Store.Delete(location + fileName); err != nil {
+			log.Println(err)
+		}
+	}
 }
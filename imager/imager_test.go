@@ -0,0 +1,62 @@
+package imager
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// failingStorage fails to put files after a number of successful puts, like S3 which went down
+type failingStorage struct {
+	Storage
+	puts int
+}
+
+func (s *failingStorage) Put(key string, data []byte) error {
+	if s.puts == 0 {
+		return errors.New("storage is down")
+	}
+	s.puts--
+	return s.Storage.Put(key, data)
+}
+
+func TestStoreAll(t *testing.T) {
+	dir, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defaultStore := Store
+	defer func() { Store = defaultStore }()
+
+	keys := []string{"purchases/b/1_a.jpg", "purchases/m/1_a.jpg"}
+	images := [][]byte{[]byte("big"), []byte("normal")}
+
+	table := []struct {
+		puts     int
+		ok       bool
+		expected string
+	}{
+		{0, false, ""},
+		{1, false, ""}, // the second put fails, so the first file is deleted
+		{2, true, "purchases/b/1_a.jpg,purchases/m/1_a.jpg"},
+	}
+	for num, v := range table {
+		os.RemoveAll(dir)
+		Store = &failingStorage{NewLocalStorage(dir), v.puts}
+
+		ok := storeAll(keys, images)
+		files, _ := Store.List("")
+		stored := []string{}
+		for _, file := range files {
+			stored = append(stored, file.Key)
+		}
+
+		if ok != v.ok || strings.Join(stored, ",") != v.expected {
+			t.Errorf("Case %v. Expect %v, %v. Got %v, %v", num, v.ok, v.expected, ok, stored)
+		}
+	}
+}
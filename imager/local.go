@@ -0,0 +1,139 @@
+package imager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// LocalStorage keeps files in a directory of a disk. Keys are paths relative to the directory
+type LocalStorage struct {
+	root string
+}
+
+// NewLocalStorage creates a storage in a directory
+func NewLocalStorage(root string) *LocalStorage {
+	return &LocalStorage{root}
+}
+
+// path returns where a file of a key is on the disk
+func (s *LocalStorage) path(key string) (string, error) {
+	if !isKeyValid(key) {
+		return "", ErrNotExist
+	}
+	return filepath.Join(s.root, filepath.FromSlash(key)), nil
+}
+
+// Put writes a file to a temporary file first, so nobody reads a half written image
+func (s *LocalStorage) Put(key string, data []byte) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(path), ".tmp_")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Get reads a file
+func (s *LocalStorage) Get(key string) ([]byte, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotExist
+	}
+	return data, err
+}
+
+// Stat returns the size and the modification time of a file
+func (s *LocalStorage) Stat(key string) (FileInfo, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return FileInfo{}, err
+	}
+
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) || (err == nil && info.IsDir()) {
+		return FileInfo{}, ErrNotExist
+	} else if err != nil {
+		return FileInfo{}, err
+	}
+
+	return FileInfo{key, info.Size(), info.ModTime()}, nil
+}
+
+// Delete removes a file
+func (s *LocalStorage) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// List walks the directory of a prefix. Temporary files of unfinished writes are skipped
+func (s *LocalStorage) List(prefix string) ([]FileInfo, error) {
+	dir := s.root
+	if i := strings.LastIndex(prefix, "/"); i >= 0 {
+		if !isKeyValid(prefix[:i]) {
+			return nil, ErrNotExist
+		}
+		dir = filepath.Join(s.root, filepath.FromSlash(prefix[:i]))
+	}
+
+	files := []FileInfo{}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) && path == dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".tmp_") {
+			return nil
+		}
+
+		rel, err := filepath.Rel(s.root, path)
+		if err != nil {
+			return err
+		}
+
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			files = append(files, FileInfo{key, info.Size(), info.ModTime()})
+		}
+		return nil
+	})
+
+	return files, err
+}
@@ -0,0 +1,284 @@
+package imager
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// S3Storage keeps files in a bucket of S3 or of a compatible service. Requests use path-style URLs
+// (endpoint/bucket/key), which all compatible services understand, and are signed with AWS Signature
+// Version 4 https://docs.aws.amazon.com/general/latest/gr/sigv4_signing.html
+type S3Storage struct {
+	endpoint string
+	bucket   string
+	signer   signer
+	client   *http.Client
+	now      func() time.Time
+}
+
+// NewS3Storage creates a storage of a bucket. An endpoint is like https://s3.amazonaws.com or http://localhost:9000
+func NewS3Storage(endpoint, region, bucket, accessKey, secretKey string) *S3Storage {
+	return &S3Storage{
+		strings.TrimSuffix(endpoint, "/"),
+		bucket,
+		signer{accessKey, secretKey, region, "s3"},
+		&http.Client{Timeout: 30 * time.Second},
+		time.Now,
+	}
+}
+
+// object sends a signed request about an object of a key
+func (s *S3Storage) object(method, key string, body []byte) (*http.Response, error) {
+	if !isKeyValid(key) {
+		return nil, ErrNotExist
+	}
+	return s.do(method, "/"+s.bucket+"/"+key, nil, body)
+}
+
+// do sends a signed request
+func (s *S3Storage) do(method, path string, query url.Values, body []byte) (*http.Response, error) {
+	u := s.endpoint + uriEncode(path, false)
+	if len(query) > 0 {
+		u += "?" + canonicalQuery(query)
+	}
+
+	r, err := http.NewRequest(method, u, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+
+	payloadHash := sha256Hex(body)
+	r.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	s.signer.sign(r, payloadHash, s.now())
+	return s.client.Do(r)
+}
+
+// check turns a response which is not successful into an error. 404 becomes ErrNotExist
+func check(resp *http.Response) error {
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return nil
+	}
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotExist
+	}
+
+	body, _ := ioutil.ReadAll(resp.Body)
+	return fmt.Errorf("S3 %s %s: %s %s", resp.Request.Method, resp.Request.URL.Path, resp.Status, body)
+}
+
+// Put uploads a file
+func (s *S3Storage) Put(key string, data []byte) error {
+	resp, err := s.object("PUT", key, data)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	return check(resp)
+}
+
+// Get downloads a file
+func (s *S3Storage) Get(key string) ([]byte, error) {
+	resp, err := s.object("GET", key, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := check(resp); err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// Stat asks for headers of a file
+func (s *S3Storage) Stat(key string) (FileInfo, error) {
+	resp, err := s.object("HEAD", key, nil)
+	if err != nil {
+		return FileInfo{}, err
+	}
+	defer resp.Body.Close()
+
+	if err := check(resp); err != nil {
+		return FileInfo{}, err
+	}
+
+	size, _ := strconv.ParseInt(resp.Header.Get("Content-Length"), 10, 64)
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return FileInfo{key, size, modTime}, nil
+}
+
+// Delete removes a file. S3 does not report files which do not exist
+func (s *S3Storage) Delete(key string) error {
+	resp, err := s.object("DELETE", key, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if err := check(resp); err != nil && err != ErrNotExist {
+		return err
+	}
+	return nil
+}
+
+// listResult is a page of ListObjectsV2 https://docs.aws.amazon.com/AmazonS3/latest/API/API_ListObjectsV2.html
+type listResult struct {
+	IsTruncated           bool
+	NextContinuationToken string
+	Contents              []struct {
+		Key          string
+		Size         int64
+		LastModified time.Time
+	}
+}
+
+// List reads all pages of files with a prefix
+func (s *S3Storage) List(prefix string) ([]FileInfo, error) {
+	files := []FileInfo{}
+	query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+	for {
+		resp, err := s.do("GET", "/"+s.bucket+"/", query, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		page := listResult{}
+		err = check(resp)
+		if err == nil {
+			err = xml.NewDecoder(resp.Body).Decode(&page)
+		}
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		for _, v := range page.Contents {
+			files = append(files, FileInfo{v.Key, v.Size, v.LastModified})
+		}
+
+		if !page.IsTruncated {
+			return files, nil
+		}
+		query.Set("continuation-token", page.NextContinuationToken)
+	}
+}
+
+// signer signs requests to AWS compatible services with Signature Version 4
+type signer struct {
+	accessKey string
+	secretKey string
+	region    string
+	service   string
+}
+
+// sign adds X-Amz-Date and Authorization headers. The host and all X-Amz-* headers are signed
+func (s signer) sign(r *http.Request, payloadHash string, now time.Time) {
+	r.Header.Set("X-Amz-Date", now.UTC().Format("20060102T150405Z"))
+	r.Header.Set("Authorization", s.authorization(r, payloadHash))
+}
+
+// authorization returns the Authorization header of a request which already has X-Amz-Date
+func (s signer) authorization(r *http.Request, payloadHash string) string {
+	amzDate := r.Header.Get("X-Amz-Date")
+	scope := strings.Join([]string{amzDate[:8], s.region, s.service, "aws4_request"}, "/")
+
+	host := r.Host
+	if host == "" {
+		host = r.URL.Host
+	}
+
+	headers := map[string]string{"host": host}
+	for name, values := range r.Header {
+		if name = strings.ToLower(name); strings.HasPrefix(name, "x-amz-") {
+			headers[name] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders bytes.Buffer
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		r.Method,
+		uriEncode(r.URL.Path, false),
+		canonicalQuery(r.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	stringToSign := strings.Join([]string{"AWS4-HMAC-SHA256", amzDate, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := []byte("AWS4" + s.secretKey)
+	for _, part := range []string{amzDate[:8], s.region, s.service, "aws4_request"} {
+		key = hmacSha256(key, part)
+	}
+
+	return fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		s.accessKey, scope, signedHeaders, hex.EncodeToString(hmacSha256(key, stringToSign)))
+}
+
+// canonicalQuery encodes a query with sorted keys as Signature Version 4 expects
+func canonicalQuery(query url.Values) string {
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	pairs := []string{}
+	for _, key := range keys {
+		values := append([]string{}, query[key]...)
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(key, true)+"="+uriEncode(value, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode escapes everything except unreserved characters (RFC 3986). Slashes are kept in paths
+func uriEncode(s string, encodeSlash bool) string {
+	var b bytes.Buffer
+	for _, c := range []byte(s) {
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '_' || c == '.' || c == '~' || (c == '/' && !encodeSlash) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSha256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
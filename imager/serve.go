@@ -0,0 +1,90 @@
+package imager
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// servedLocations are locations of the storage which clients can read. Temporary files are not among them
+var servedLocations = map[string]bool{
+	"avatars/b/":   true,
+	"avatars/s/":   true,
+	"purchases/b/": true,
+	"purchases/m/": true,
+}
+
+// mimeOfFile returns the MIME type of an image by its extension. Only extensions of mimeToExtension are known
+func mimeOfFile(fileName string) (string, bool) {
+	ext := path.Ext(fileName)
+	for mime, v := range mimeToExtension {
+		if v == ext {
+			return mime, true
+		}
+	}
+	return "", false
+}
+
+// etagOf returns an ETag of an image. A name of an image never changes its content, so the ETag is made
+// from the key and the file is not read
+func etagOf(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// isEtagMatched checks whether an If-None-Match header lists an ETag
+func isEtagMatched(header, etag string) bool {
+	for _, v := range strings.Split(header, ",") {
+		if v = strings.TrimPrefix(strings.TrimSpace(v), "W/"); v == etag || v == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// Serve sends an image from a location of the storage. A name of an image never changes its content
+// (it is a time and a random string), so clients and proxies cache it forever. Revalidation
+// (If-None-Match) needs only to know that the file exists, ranges are answered by http.ServeContent
+func Serve(w http.ResponseWriter, r *http.Request, location, fileName string) {
+	mime, ok := mimeOfFile(fileName)
+	if !ok || !servedLocations[location] || fileName == "" || fileName[0] == '.' || strings.ContainsAny(fileName, "/\\") {
+		http.NotFound(w, r)
+		return
+	}
+
+	key, etag := location+fileName, etagOf(location+fileName)
+	isRevalidation := isEtagMatched(r.Header.Get("If-None-Match"), etag)
+
+	var data []byte
+	var err error
+	if isRevalidation {
+		_, err = Store.Stat(key)
+	} else {
+		data, err = Store.Get(key)
+	}
+
+	if err == ErrNotExist {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		log.Println(err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	if isRevalidation {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.Header().Set("Content-Type", mime)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	http.ServeContent(w, r, fileName, time.Time{}, bytes.NewReader(data))
+}
@@ -0,0 +1,100 @@
+package imager
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+// countingStorage counts how many times files are read
+type countingStorage struct {
+	Storage
+	gets int
+}
+
+func (s *countingStorage) Get(key string) ([]byte, error) {
+	s.gets++
+	return s.Storage.Get(key)
+}
+
+func TestServe(t *testing.T) {
+	dir, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	defaultStore := Store
+	defer func() { Store = defaultStore }()
+
+	storage := &countingStorage{Storage: NewLocalStorage(dir)}
+	Store = storage
+	Store.Put("avatars/b/1_a.jpg", []byte("jpeg of an avatar"))
+	Store.Put("purchases/m/2_b.png", []byte("png of a purchase"))
+	Store.Put("avatars/b/3_c.txt", []byte("not an image"))
+	Store.Put("secret.jpg", []byte("not in a served location"))
+
+	get := func(location, fileName string, headers map[string]string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("GET", "/images/"+location+fileName, nil)
+		for k, v := range headers {
+			r.Header.Set(k, v)
+		}
+
+		w := httptest.NewRecorder()
+		Serve(w, r, location, fileName)
+		return w
+	}
+
+	w := get("avatars/b/", "1_a.jpg", nil)
+	etag := w.Header().Get("ETag")
+	if w.Code != http.StatusOK || w.Body.String() != "jpeg of an avatar" || w.Header().Get("Content-Type") != "image/jpeg" ||
+		w.Header().Get("Cache-Control") != "public, max-age=31536000, immutable" || len(etag) != 34 {
+		t.Errorf("Expect an image. Got %v, %v, %q", w.Code, w.Header(), w.Body.String())
+	}
+
+	if w := get("purchases/m/", "2_b.png", nil); w.Header().Get("Content-Type") != "image/png" || w.Header().Get("ETag") == etag {
+		t.Errorf("Expect a png with another ETag. Got %v", w.Header())
+	}
+
+	table := []struct {
+		location string
+		fileName string
+		headers  map[string]string
+		code     int
+		body     string
+	}{
+		{"avatars/b/", "1_a.jpg", map[string]string{"If-None-Match": etag}, http.StatusNotModified, ""},
+		{"avatars/b/", "1_a.jpg", map[string]string{"If-None-Match": `"other", W/` + etag}, http.StatusNotModified, ""},
+		{"avatars/s/", "1_a.jpg", map[string]string{"If-None-Match": etag}, http.StatusNotFound, ""},
+		{"avatars/b/", "1_a.jpg", map[string]string{"If-None-Match": `"other"`}, http.StatusOK, "jpeg of an avatar"},
+		{"avatars/b/", "1_a.jpg", map[string]string{"Range": "bytes=0-3"}, http.StatusPartialContent, "jpeg"},
+		{"avatars/b/", "1_a.jpg", map[string]string{"Range": "bytes=5-", "If-Range": etag}, http.StatusPartialContent, "of an avatar"},
+		{"avatars/b/", "1_a.jpg", map[string]string{"Range": "bytes=100-"}, http.StatusRequestedRangeNotSatisfiable, ""},
+		{"avatars/s/", "1_a.jpg", nil, http.StatusNotFound, ""},
+		{"avatars/b/", "3_c.txt", nil, http.StatusNotFound, ""},
+		{"avatars/b/", ".jpg", nil, http.StatusNotFound, ""},
+		{"avatars/b/", "..%2Fsecret.jpg", nil, http.StatusNotFound, ""},
+		{"avatars/", "b/1_a.jpg", nil, http.StatusNotFound, ""},
+		{"", "secret.jpg", nil, http.StatusNotFound, ""},
+		{"tmp/", "1_a.jpg", nil, http.StatusNotFound, ""},
+	}
+	for num, v := range table {
+		w := get(v.location, v.fileName, v.headers)
+		if w.Code != v.code || (v.body != "" && w.Body.String() != v.body) {
+			t.Errorf("Case %v. Expect %v, %q. Got %v, %q", num, v.code, v.body, w.Code, w.Body.String())
+		}
+	}
+
+	// revalidation does not read a file
+	storage.gets = 0
+	if w := get("avatars/b/", "1_a.jpg", map[string]string{"If-None-Match": etag}); w.Code != http.StatusNotModified || storage.gets != 0 {
+		t.Errorf("Expect a revalidation without reading the file. Got %v, %v reads", w.Code, storage.gets)
+	}
+
+	// a path with a slash never reaches the storage
+	if w := get("avatars/b/", "x/../1_a.jpg", nil); w.Code != http.StatusNotFound {
+		t.Errorf("Expect not to leave a location. Got %v", w.Code)
+	}
+}
@@ -0,0 +1,73 @@
+package imager
+
+import (
+	"../config"
+	"errors"
+	"log"
+	"strings"
+	"time"
+)
+
+// Names of storages in PROJ_STORAGE
+const (
+	StorageLocal = "local" // a directory on a disk, for one instance of the service
+	StorageS3    = "s3"    // a bucket of S3 or any compatible service (MinIO, Ceph), shared by all instances
+)
+
+// ErrNotExist is returned by storages when a file does not exist
+var ErrNotExist = errors.New("file does not exist")
+
+// FileInfo describes a stored file
+type FileInfo struct {
+	Key     string
+	Size    int64
+	ModTime time.Time
+}
+
+// Storage keeps uploaded images. Keys are slash separated paths like avatars/b/1467954439_abc.jpg.
+// Implementations are safe for concurrent use
+type Storage interface {
+	// Put creates or replaces a file
+	Put(key string, data []byte) error
+	// Get returns the content of a file or ErrNotExist
+	Get(key string) ([]byte, error)
+	// Stat returns information about a file or ErrNotExist
+	Stat(key string) (FileInfo, error)
+	// Delete removes a file. Removing a file which does not exist is not an error
+	Delete(key string) error
+	// List returns all files whose keys start with a prefix
+	List(prefix string) ([]FileInfo, error)
+}
+
+// Store keeps all images. It is chosen by Init
+var Store Storage
+
+// Init chooses where images are kept with PROJ_STORAGE
+func Init() {
+	cfg := config.Cfg
+	switch cfg.Storage {
+	case StorageLocal:
+		Store = NewLocalStorage(cfg.StorageDir)
+	case StorageS3:
+		if cfg.S3Endpoint == "" || cfg.S3Bucket == "" || cfg.S3AccessKey == "" || cfg.S3SecretKey == "" {
+			log.Fatal("S3 needs PROJ_S3_ENDPOINT, PROJ_S3_BUCKET, PROJ_S3_ACCESS_KEY and PROJ_S3_SECRET_KEY")
+		}
+		Store = NewS3Storage(cfg.S3Endpoint, cfg.S3Region, cfg.S3Bucket, cfg.S3AccessKey, cfg.S3SecretKey)
+	default:
+		log.Fatal("Unknown PROJ_STORAGE: ", cfg.Storage)
+	}
+}
+
+// isKeyValid checks that a key can't point outside of a storage
+func isKeyValid(key string) bool {
+	if key == "" || strings.HasPrefix(key, "/") || strings.Contains(key, "\\") {
+		return false
+	}
+
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return false
+		}
+	}
+	return true
+}
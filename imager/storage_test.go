@@ -0,0 +1,249 @@
+package imager
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestStorage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "images")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	server := httptest.NewServer(newFakeS3("images", signer{"access", "secret", "us-east-1", "s3"}))
+	defer server.Close()
+
+	storages := map[string]Storage{
+		"local": NewLocalStorage(dir),
+		"s3":    NewS3Storage(server.URL+"/", "us-east-1", "images", "access", "secret"),
+	}
+	for name, s := range storages {
+		if _, err := s.Get("avatars/b/1_a.jpg"); err != ErrNotExist {
+			t.Errorf("Storage %v. Expect no file. Got %v", name, err)
+		}
+
+		if _, err := s.Stat("avatars/b/1_a.jpg"); err != ErrNotExist {
+			t.Errorf("Storage %v. Expect no file. Got %v", name, err)
+		}
+
+		if err := s.Delete("avatars/b/1_a.jpg"); err != nil {
+			t.Errorf("Storage %v. Expect to delete a missing file. Got %v", name, err)
+		}
+
+		s.Put("avatars/b/1_a.jpg", []byte("old"))
+		s.Put("avatars/s/1_a.jpg", []byte("small"))
+		s.Put("purchases/m/2_b.jpg", []byte("purchase"))
+		if err := s.Put("avatars/b/1_a.jpg", []byte("big avatar")); err != nil {
+			t.Fatal(err)
+		}
+
+		if data, err := s.Get("avatars/b/1_a.jpg"); err != nil || string(data) != "big avatar" {
+			t.Errorf("Storage %v. Expect a replaced file. Got %q, %v", name, data, err)
+		}
+
+		info, err := s.Stat("avatars/b/1_a.jpg")
+		if err != nil || info.Key != "avatars/b/1_a.jpg" || info.Size != 10 || time.Since(info.ModTime) > time.Minute {
+			t.Errorf("Storage %v. Expect information about a file. Got %v, %v", name, info, err)
+		}
+
+		table := []struct {
+			prefix string
+			keys   string
+		}{
+			{"avatars/", "avatars/b/1_a.jpg,avatars/s/1_a.jpg"},
+			{"avatars/s/", "avatars/s/1_a.jpg"},
+			{"purchases/m/2", "purchases/m/2_b.jpg"},
+			{"purchases/b/", ""},
+			{"", "avatars/b/1_a.jpg,avatars/s/1_a.jpg,purchases/m/2_b.jpg"},
+		}
+		for num, v := range table {
+			files, err := s.List(v.prefix)
+			keys := []string{}
+			for _, file := range files {
+				keys = append(keys, file.Key)
+			}
+			sort.Strings(keys)
+
+			if err != nil || strings.Join(keys, ",") != v.keys {
+				t.Errorf("Storage %v. Case %v. Expect %v. Got %v, %v", name, num, v.keys, keys, err)
+			}
+		}
+
+		if err := s.Delete("avatars/b/1_a.jpg"); err != nil {
+			t.Error(err)
+		}
+
+		if _, err := s.Get("avatars/b/1_a.jpg"); err != ErrNotExist {
+			t.Errorf("Storage %v. Expect a file to be deleted. Got %v", name, err)
+		}
+
+		// keys can't escape the storage
+		for _, key := range []string{"", "/etc/passwd", "avatars/b/..", "avatars/../../x.jpg", "avatars//x.jpg", "avatars\\x.jpg"} {
+			if err := s.Put(key, []byte("x")); err == nil {
+				t.Errorf("Storage %v. Expect not to put %q", name, key)
+			}
+
+			if _, err := s.Get(key); err != ErrNotExist {
+				t.Errorf("Storage %v. Expect not to get %q. Got %v", name, key, err)
+			}
+		}
+	}
+}
+
+// fakeS3 is a stand-in of S3 which understands path-style requests of one bucket and checks signatures
+type fakeS3 struct {
+	mu       sync.Mutex
+	bucket   string
+	signer   signer
+	pageSize int
+	files    map[string][]byte
+	modTimes map[string]time.Time
+}
+
+func newFakeS3(bucket string, s signer) *fakeS3 {
+	return &fakeS3{bucket: bucket, signer: s, pageSize: 2, files: map[string][]byte{}, modTimes: map[string]time.Time{}}
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	body, _ := ioutil.ReadAll(r.Body)
+	payloadHash := r.Header.Get("X-Amz-Content-Sha256")
+	if sha256Hex(body) != payloadHash || r.Header.Get("Authorization") != f.signer.authorization(r, payloadHash) {
+		http.Error(w, "SignatureDoesNotMatch", http.StatusForbidden)
+		return
+	}
+
+	if !strings.HasPrefix(r.URL.Path, "/"+f.bucket+"/") {
+		http.Error(w, "NoSuchBucket", http.StatusNotFound)
+		return
+	}
+
+	key := strings.TrimPrefix(r.URL.Path, "/"+f.bucket+"/")
+	if key == "" && r.Method == "GET" {
+		f.list(w, r)
+		return
+	}
+
+	data, ok := f.files[key]
+	switch r.Method {
+	case "PUT":
+		f.files[key], f.modTimes[key] = body, time.Now()
+	case "GET", "HEAD":
+		if !ok {
+			http.Error(w, "NoSuchKey", http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+		w.Header().Set("Last-Modified", f.modTimes[key].UTC().Format(http.TimeFormat))
+		w.Write(data)
+	case "DELETE":
+		delete(f.files, key)
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}
+}
+
+// list returns pages of f.pageSize files. A continuation token is the last key of the previous page
+func (f *fakeS3) list(w http.ResponseWriter, r *http.Request) {
+	keys := []string{}
+	for key := range f.files {
+		if strings.HasPrefix(key, r.URL.Query().Get("prefix")) && key > r.URL.Query().Get("continuation-token") {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	type content struct {
+		Key          string
+		Size         int
+		LastModified string
+	}
+	page := struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		IsTruncated           bool
+		NextContinuationToken string `xml:",omitempty"`
+		Contents              []content
+	}{}
+
+	if len(keys) > f.pageSize {
+		keys = keys[:f.pageSize]
+		page.IsTruncated, page.NextContinuationToken = true, keys[len(keys)-1]
+	}
+
+	for _, key := range keys {
+		page.Contents = append(page.Contents, content{key, len(f.files[key]), f.modTimes[key].UTC().Format("2006-01-02T15:04:05.000Z")})
+	}
+
+	data, _ := xml.Marshal(page)
+	w.Write(data)
+}
+
+func TestS3Storage(t *testing.T) {
+	server := httptest.NewServer(newFakeS3("images", signer{"access", "secret", "us-east-1", "s3"}))
+	defer server.Close()
+
+	// the fake returns pages of 2 files, so all pages are read
+	s := NewS3Storage(server.URL, "us-east-1", "images", "access", "secret")
+	for _, key := range []string{"tmp/1", "tmp/2", "tmp/3", "tmp/4", "tmp/5"} {
+		s.Put(key, []byte(key))
+	}
+
+	if files, err := s.List("tmp/"); err != nil || len(files) != 5 || files[4].Key != "tmp/5" || files[4].Size != 5 {
+		t.Errorf("Expect all pages of files. Got %v, %v", files, err)
+	}
+
+	wrong := NewS3Storage(server.URL, "us-east-1", "images", "access", "wrong secret")
+	if err := wrong.Put("tmp/6", []byte("x")); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("Expect a wrong signature to be rejected. Got %v", err)
+	}
+
+	other := NewS3Storage(server.URL, "us-east-1", "other", "access", "secret")
+	if _, err := other.List(""); err == nil {
+		t.Error("Expect an error of a missing bucket")
+	}
+}
+
+// get-vanilla of the AWS Signature Version 4 test suite
+func TestSigner(t *testing.T) {
+	r, _ := http.NewRequest("GET", "https://example.amazonaws.com/", bytes.NewReader(nil))
+	s := signer{"AKIDEXAMPLE", "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY", "us-east-1", "service"}
+	s.sign(r, sha256Hex(nil), time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/service/aws4_request, " +
+		"SignedHeaders=host;x-amz-date, Signature=5fa00fa31553b73ebf1942676e86291e8372ff2a2260956d9b8aae1d763fbf31"
+	if auth := r.Header.Get("Authorization"); auth != expected {
+		t.Errorf("Expect %v. Got %v", expected, auth)
+	}
+}
+
+func TestUriEncode(t *testing.T) {
+	table := []struct {
+		s           string
+		encodeSlash bool
+		expected    string
+	}{
+		{"/images/avatars/b/1_a.jpg", false, "/images/avatars/b/1_a.jpg"},
+		{"a b+c/d~", true, "a%20b%2Bc%2Fd~"},
+		{"фото", false, "%D1%84%D0%BE%D1%82%D0%BE"},
+	}
+	for num, v := range table {
+		if s := uriEncode(v.s, v.encodeSlash); s != v.expected {
+			t.Errorf("Case %v. Expect %v. Got %v", num, v.expected, s)
+		}
+	}
+}
@@ -1,84 +1,179 @@
 package main
 
 import (
+	"./auth"
 	"./config"
+	"./imager"
 	"./mailer"
+	"./migrate"
 	"./psql"
 	"./routes"
+	"./throttle"
 	"fmt"
 	"github.com/dimfeld/httptreemux"
 	"log"
 	"math/rand"
 	"net/http"
+	"os"
 	"time"
 )
 
 // Init prepares the service for a work:
 // - initializes randomness
 // - creates a config
+// - loads JWT signing keys
 // - creates a mailer object
+// - chooses a storage of images
 // - creates a database connection
+// - creates a guard of logins
 func Init() {
 	rand.Seed(time.Now().UnixNano())
 	config.Init()
+	auth.Init()
 	mailer.Init()
+	imager.Init()
 	psql.Init()
+	throttle.Init(psql.Db)
 }
 
 func main() {
+	// `migrate up/down/status` changes the schema instead of starting a server. It needs only the
+	// database, so it can run before a deploy without keys, templates and mail services
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		config.Init()
+		psql.Init()
+		if err := migrate.Command(psql.Db, "SQL/migrations", os.Args[2:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	Init()
 
-	// Creates a router
+	// `mailer preview <template> [language]` prints an email rendered with sample data
+	if len(os.Args) > 1 && os.Args[1] == "mailer" {
+		if err := mailer.Command(os.Args[2:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// `images gc [--dry-run]` removes uploaded images which nobody uses
+	if len(os.Args) > 1 && os.Args[1] == "images" {
+		grace := time.Duration(config.Cfg.ImageGrace) * time.Hour
+		if err := imager.Command(psql.Db, grace, os.Args[2:], os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	// Creates a router. Every route declares who can call it (see routes.Policy)
 	router := httptreemux.New()
 	api := router.NewGroup("/api/v1")
 
+	// Public keys to validate jwt tokens
+	router.GET("/.well-known/jwks.json", routes.Auth(routes.Anonymous, routes.GetJwks))
+
+	// Uploaded images. Sizes which do not exist are not found
+	router.GET("/images/avatars/:size/:name", routes.Auth(routes.Anonymous, routes.GetAvatarImage))
+	router.GET("/images/purchases/:size/:name", routes.Auth(routes.Anonymous, routes.GetPurchaseImage))
+
 	// Image
-	api.POST("/image/avatar", routes.UploadImageAvatar)
-	api.POST("/image/purchase", routes.UploadImagePurchase)
+	api.POST("/image/avatar", routes.Auth(routes.Verified, routes.UploadImageAvatar))
+	api.POST("/image/purchase", routes.Auth(routes.Verified, routes.UploadImagePurchase))
 
 	// Brands
-	api.GET("/brands", routes.GetAllBrands)
-	api.GET("/brands/:id", routes.GetBrand)
-	api.POST("/brands", routes.CreateBrand)
-	api.PUT("/brands/:id", routes.UpdateBrand)
+	api.GET("/brands", routes.Auth(routes.Anonymous, routes.GetAllBrands))
+	api.GET("/brands/:id", routes.Auth(routes.Anonymous, routes.GetBrand))
+	api.POST("/brands", routes.Auth(routes.Moderator, routes.CreateBrand))
+	api.PUT("/brands/:id", routes.Auth(routes.Moderator, routes.UpdateBrand))
 
 	// Tags
-	api.GET("/tags", routes.GetAllTags)
-	api.GET("/tags/:id", routes.GetTag)
-	api.POST("/tags", routes.CreateTag)
-	api.PUT("/tags/:id", routes.UpdateTag)
+	api.GET("/tags", routes.Auth(routes.Anonymous, routes.GetAllTags))
+	api.GET("/tags/:id", routes.Auth(routes.Anonymous, routes.GetTag))
+	api.POST("/tags", routes.Auth(routes.Moderator, routes.CreateTag))
+	api.PUT("/tags/:id", routes.Auth(routes.Moderator, routes.UpdateTag))
+
+	// Proposals of brands and tags
+	api.POST("/proposals", routes.Auth(routes.Verified, routes.CreateProposal))
+	api.GET("/proposals", routes.Auth(routes.Moderator, routes.GetPendingProposals))
+	api.POST("/proposals/:id/approve", routes.Auth(routes.Moderator, routes.ApproveProposal))
+	api.POST("/proposals/:id/reject", routes.Auth(routes.Moderator, routes.RejectProposal))
 
 	// Users
-	api.POST("/users/login", routes.Login)
-	api.GET("/users/login/extend", routes.ExtendJwt)
-	api.POST("/users", routes.CreateUser)
-	api.GET("/users/:id", routes.GetUser)
-	api.PUT("/users/me/info", routes.UpdateUser)
-	api.POST("/users/me/follow/:id", routes.Follow)
-	api.DELETE("/users/me/follow/:id", routes.Unfollow)
-	api.GET("/users/:id/followers", routes.GetFollowers)
-	api.GET("/users/:id/following", routes.GetFollowing)
-	api.GET("/users/:id/purchases", routes.GetUserPurchases)
-	api.GET("/users/verify/:id/:code", routes.VerifyEmail)
+	api.POST("/users/login", routes.Auth(routes.Anonymous, routes.Login))
+	api.POST("/users/login/refresh", routes.Auth(routes.Anonymous, routes.RefreshToken))
+	api.POST("/users/login/mfa", routes.Auth(routes.Anonymous, routes.LoginMfa))
+	api.POST("/users/logout", routes.Auth(routes.LoggedIn, routes.Logout))
+	api.POST("/users/password/forgot", routes.Auth(routes.Anonymous, routes.ForgotPassword))
+	api.POST("/users/password/reset", routes.Auth(routes.Anonymous, routes.ResetPassword))
+	api.POST("/users", routes.Auth(routes.Anonymous, routes.CreateUser))
+	api.GET("/users/:id", routes.Auth(routes.Anonymous, routes.GetUser))
+	api.PUT("/users/me/info", routes.Auth(routes.Verified, routes.UpdateUser))
+	api.PUT("/users/me/password", routes.Auth(routes.Verified, routes.ChangePassword))
+	api.PUT("/users/me/email", routes.Auth(routes.Verified, routes.ChangeEmail))
+	api.PUT("/users/me/language", routes.Auth(routes.LoggedIn, routes.SetLanguage))
+	api.GET("/users/me/sessions", routes.Auth(routes.LoggedIn, routes.GetSessions))
+	api.DELETE("/users/me/sessions/:id", routes.Auth(routes.LoggedIn, routes.DeleteSession))
+	api.POST("/users/me/2fa/setup", routes.Auth(routes.Verified, routes.SetupMfa))
+	api.POST("/users/me/2fa/confirm", routes.Auth(routes.Verified, routes.ConfirmMfa))
+	api.DELETE("/users/me/2fa", routes.Auth(routes.Verified, routes.DisableMfa))
+	api.POST("/users/me/follow/:id", routes.Auth(routes.Verified, routes.Follow))
+	api.DELETE("/users/me/follow/:id", routes.Auth(routes.Verified, routes.Unfollow))
+	api.GET("/users/me/preferences", routes.Auth(routes.Verified, routes.GetPreferences))
+	api.PUT("/users/me/tags/like/:id", routes.Auth(routes.Verified, routes.LikeTag))
+	api.DELETE("/users/me/tags/like/:id", routes.Auth(routes.Verified, routes.UnlikeTag))
+	api.PUT("/users/me/tags/ignore/:id", routes.Auth(routes.Verified, routes.IgnoreTag))
+	api.DELETE("/users/me/tags/ignore/:id", routes.Auth(routes.Verified, routes.UnignoreTag))
+	api.PUT("/users/me/brands/like/:id", routes.Auth(routes.Verified, routes.LikeBrand))
+	api.DELETE("/users/me/brands/like/:id", routes.Auth(routes.Verified, routes.UnlikeBrand))
+	api.PUT("/users/me/brands/ignore/:id", routes.Auth(routes.Verified, routes.IgnoreBrand))
+	api.DELETE("/users/me/brands/ignore/:id", routes.Auth(routes.Verified, routes.UnignoreBrand))
+	api.GET("/users/me/proposals", routes.Auth(routes.Verified, routes.GetMyProposals))
+	api.PUT("/users/:id/role", routes.Auth(routes.Admin, routes.SetUserRole))
+	api.GET("/users/:id/followers", routes.Auth(routes.Anonymous, routes.GetFollowers))
+	api.GET("/users/:id/following", routes.Auth(routes.Anonymous, routes.GetFollowing))
+	api.GET("/users/:id/purchases", routes.Auth(routes.Anonymous, routes.GetUserPurchases))
+	api.GET("/users/:id/questions", routes.Auth(routes.Anonymous, routes.GetUserQuestions))
+	api.GET("/users/:id/answers", routes.Auth(routes.Anonymous, routes.GetUserAnswers))
+	api.GET("/users/verify/:id/:code", routes.Auth(routes.Anonymous, routes.VerifyEmail))
+	api.POST("/users/verify/resend", routes.Auth(routes.LoggedIn, routes.ResendConfirmation))
 
 	// Purchases
-	api.GET("/purchases", routes.GetAllPurchases)
-	api.POST("/purchases", routes.CreatePurchase)
-	api.GET("/purchases/brand/:id", routes.GetAllPurchasesWithBrand)
-	api.GET("/purchases/tag/:id", routes.GetAllPurchasesWithTag)
-	api.GET("/purchases/:id", routes.GetPurchase)
-	api.POST("/purchases/:id/like", routes.LikePurchase)
-	api.DELETE("/purchases/:id/like", routes.UnlikePurchase)
-	api.POST("/purchases/:id/ask", routes.AskQuestion)
+	api.GET("/purchases", routes.Auth(routes.Anonymous, routes.GetAllPurchases))
+	api.POST("/purchases", routes.Auth(routes.Verified, routes.CreatePurchase))
+	api.GET("/purchases/brand/:id", routes.Auth(routes.Anonymous, routes.GetAllPurchasesWithBrand))
+	api.GET("/purchases/tag/:id", routes.Auth(routes.Anonymous, routes.GetAllPurchasesWithTag))
+	api.GET("/purchases/:id", routes.Auth(routes.Anonymous, routes.GetPurchase))
+	api.PUT("/purchases/:id", routes.Auth(routes.Verified, routes.UpdatePurchase))
+	api.DELETE("/purchases/:id", routes.Auth(routes.Verified, routes.DeletePurchase))
+	api.POST("/purchases/:id/like", routes.Auth(routes.Verified, routes.LikePurchase))
+	api.DELETE("/purchases/:id/like", routes.Auth(routes.Verified, routes.UnlikePurchase))
+	api.POST("/purchases/:id/ask", routes.Auth(routes.Verified, routes.AskQuestion))
+	api.GET("/purchases/:id/questions", routes.Auth(routes.Anonymous, routes.GetPurchaseQuestions))
+	api.GET("/feed", routes.Auth(routes.Verified, routes.GetFeed))
 
 	// Questions
-	//api.POST("/questions/:id/vote", routes.UpvoteQuestion)
-	//api.DELETE("/questions/:id/vote", routes.DownvoteQuestion)
-	api.POST("/questions/:id/answer", routes.AnswerQuestion)
+	api.GET("/questions/:id", routes.Auth(routes.Anonymous, routes.GetQuestion))
+	api.POST("/questions/:id/vote", routes.Auth(routes.Verified, routes.UpvoteQuestion))
+	api.DELETE("/questions/:id/vote", routes.Auth(routes.Verified, routes.DownvoteQuestion))
+	api.POST("/questions/:id/answer", routes.Auth(routes.Verified, routes.AnswerQuestion))
 
 	// Answers
-	//api.POST("/answer/:id/vote", routes.UpvoteAnswer)
-	//api.DELETE("/answer/:id/vote", routes.DownvoteAnswer)
+	api.POST("/answer/:id/vote", routes.Auth(routes.Verified, routes.UpvoteAnswer))
+	api.DELETE("/answer/:id/vote", routes.Auth(routes.Verified, routes.DownvoteAnswer))
+
+	// Outbox of emails
+	api.GET("/emails/failed", routes.Auth(routes.Admin, routes.GetFailedEmails))
+	api.POST("/emails/:id/retry", routes.Auth(routes.Admin, routes.RetryEmail))
+
+	// emails are delivered in the background, so requests do not wait for a mail service
+	mailer.StartWorkers(psql.Db, config.Cfg.MailWorkers)
+
+	// every instance removes unused images. Removals are idempotent, so instances do not coordinate
+	if config.Cfg.ImageGcEvery > 0 {
+		imager.StartGc(psql.Db, time.Duration(config.Cfg.ImageGcEvery)*time.Hour, time.Duration(config.Cfg.ImageGrace)*time.Hour)
+	}
 
 	log.Fatal(http.ListenAndServe(fmt.Sprintf(":%d", config.Cfg.HttpPort), router))
 }
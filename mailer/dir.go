@@ -0,0 +1,41 @@
+package mailer
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"time"
+)
+
+// unsafeFileChars are replaced in addresses which become parts of file names
+var unsafeFileChars = regexp.MustCompile(`[^a-zA-Z0-9@._-]`)
+
+// DirTransport writes every email to a .eml file, which any mail client opens. Useful for local
+// development when no email should leave the machine
+type DirTransport struct {
+	dir string
+}
+
+// NewDirTransport creates a transport which writes to a directory. The directory is created if needed
+func NewDirTransport(dir string) *DirTransport {
+	return &DirTransport{dir}
+}
+
+// Send writes an email to a file named by the time and the recipient
+func (t *DirTransport) Send(m Message) error {
+	if err := os.MkdirAll(t.dir, 0755); err != nil {
+		return err
+	}
+
+	name := fmt.Sprintf("%d_%s.eml", time.Now().UnixNano(), unsafeFileChars.ReplaceAllString(m.To, "_"))
+	path := filepath.Join(t.dir, name)
+	if err := ioutil.WriteFile(path, m.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	log.Println("Email written", path)
+	return nil
+}
@@ -2,47 +2,100 @@ package mailer
 
 import (
 	"../config"
-	"fmt"
-	mailgun "github.com/mailgun/mailgun-go"
+	"../misc"
 	"log"
 )
 
-var mailer mailgun.Mailgun // https://documentation.mailgun.com/api-sending.html#examples
+// Sender delivers all emails and Emails are their templates. Both are set by Init
+var (
+	Sender Transport
+	Emails *Templates
+)
 
 const (
 	emailFrom = "registration@unnamed.com"
 )
 
-// Init initializes the mailer object
+// Init reads templates from PROJ_MAIL_TEMPLATES and chooses how emails are sent with PROJ_MAIL_TRANSPORT
 func Init() {
-	mailer = mailgun.NewMailgun(config.Cfg.MailDomain, config.Cfg.MailPrivate, config.Cfg.MailPublic)
-}
+	cfg := config.Cfg
+	templates, err := LoadTemplates(cfg.MailTemplates)
+	if err != nil {
+		log.Fatal(err)
+	}
+	Emails = templates
 
-// sendMsg is a helper function which allows to send email with Plain Text and HTML
-func sendMsg(from, subject, text, textHtml, to string) {
-	m := mailgun.NewMessage(from, subject, text, to)
-	m.SetHtml(textHtml)
+	switch cfg.MailTransport {
+	case TransportMailgun:
+		if cfg.MailDomain == "" || cfg.MailPrivate == "" || cfg.MailPublic == "" {
+			log.Fatal("Mailgun needs PROJ_MAILGUN_DOMAIN, PROJ_MAILGUN_PRIVATE and PROJ_MAILGUN_PUBLIC")
+		}
+		Sender = NewMailgunTransport(cfg.MailDomain, cfg.MailPrivate, cfg.MailPublic)
+	case TransportSmtp:
+		if cfg.SmtpAddr == "" {
+			log.Fatal("SMTP needs PROJ_SMTP_ADDR")
+		}
+		Sender = NewSmtpTransport(cfg.SmtpAddr, cfg.SmtpUser, cfg.SmtpPass)
+	case TransportDir:
+		Sender = NewDirTransport(cfg.MailDir)
+	case TransportMemory:
+		Sender = NewMemoryTransport()
+	default:
+		log.Fatal("Unknown mail transport ", cfg.MailTransport)
+	}
 
-	if response, id, err := mailer.Send(m); err != nil {
-		log.Println(err)
-	} else {
-		log.Println("Email sent", id, response)
+	if cfg.IsTest && isReal(cfg.MailTransport) && cfg.TestEmail == "" {
+		log.Fatal("PROJ_TEST_EMAIL is needed to send emails in a test environment")
 	}
 }
 
-// getEmail returns either an email address provided to it, or an PROJ_TEST_EMAIL if PROJ_IS_TEST
+// isReal checks whether emails of a transport leave the machine
+func isReal(transport string) bool {
+	return transport == TransportMailgun || transport == TransportSmtp
+}
+
+// getEmail returns either an email address provided to it, or an PROJ_TEST_EMAIL if PROJ_IS_TEST and
+// emails really leave the machine
 func getEmail(email string) string {
-	if config.Cfg.IsTest {
+	if config.Cfg.IsTest && isReal(config.Cfg.MailTransport) {
 		return config.Cfg.TestEmail
 	}
 
 	return email
 }
 
+// IsLanguageSupported checks whether emails can be sent in a language
+func IsLanguageSupported(language string) bool {
+	return Emails.HasLanguage(language)
+}
+
+// send renders a message in a language of a user and puts it in the outbox. A code or a token in the
+// message is valid for secretTtl minutes (0 if there is no secret)
+func send(db Execer, name, email, language string, data Data, secretTtl int) error {
+	subject, text, textHtml, err := Emails.Render(name, language, data)
+	if err != nil {
+		return err
+	}
+
+	return enqueue(db, Message{emailFrom, getEmail(email), subject, text, textHtml}, secretTtl)
+}
+
 // EmailConfirmation sends a confirmation code to a newly registered user
-func EmailConfirmation(email, code string) {
-	email = getEmail(email)
-	text := fmt.Sprintf("Your confirmation code is: %s", code)
-	textHtml := fmt.Sprintf("Your confirmation code is: <b>%s</b>", code)
-	sendMsg(emailFrom, "Please confirm your registration", text, textHtml, email)
+func EmailConfirmation(db Execer, email, language, code string) error {
+	return send(db, "confirmation", email, language, Data{"Code": code, "Hours": misc.ConfCodeTtl / 60}, misc.ConfCodeTtl)
+}
+
+// EmailChangeConfirmation sends a confirmation code to a new email of an existing user
+func EmailChangeConfirmation(db Execer, email, language, code string) error {
+	return send(db, "email_change", email, language, Data{"Code": code, "Hours": misc.ConfCodeTtl / 60}, misc.ConfCodeTtl)
+}
+
+// PasswordReset sends a token which allows a user to set a new password without knowing the old one
+func PasswordReset(db Execer, email, language, token string) error {
+	return send(db, "password_reset", email, language, Data{"Token": token, "Minutes": misc.ResetTokenTtl}, misc.ResetTokenTtl)
+}
+
+// AccountLocked warns a user that somebody tried to guess the password and logins are blocked for a while
+func AccountLocked(db Execer, email, language string, minutes int) error {
+	return send(db, "account_locked", email, language, Data{"Minutes": minutes}, 0)
 }
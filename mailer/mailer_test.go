@@ -0,0 +1,155 @@
+package mailer
+
+import (
+	"../config"
+	"../misc"
+	"database/sql"
+	"io/ioutil"
+	"log"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	log.SetOutput(ioutil.Discard)
+	os.Exit(m.Run())
+}
+
+func TestBytes(t *testing.T) {
+	m := Message{"from@unnamed.com", "to@gmail.com", "Привет", "Line 1\nLine 2", "<b>Line</b>"}
+
+	msg, err := mail.ReadMessage(strings.NewReader(string(m.Bytes())))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subject, _ := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if msg.Header.Get("From") != m.From || msg.Header.Get("To") != m.To || subject != m.Subject {
+		t.Errorf("Expect headers of a message. Got %v, %v", msg.Header, subject)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Expect alternatives. Got %v, %v", mediaType, err)
+	}
+
+	// multipart.Reader decodes quoted-printable parts
+	expected := []string{"Line 1\r\nLine 2", m.Html}
+	r := multipart.NewReader(msg.Body, params["boundary"])
+	for i := 0; ; i++ {
+		part, err := r.NextPart()
+		if err != nil {
+			if i != len(expected) {
+				t.Errorf("Expect %v parts. Got %v, %v", len(expected), i, err)
+			}
+			break
+		}
+
+		if body, _ := ioutil.ReadAll(part); i >= len(expected) || string(body) != expected[i] {
+			t.Errorf("Part %v. Got %q", i, body)
+		}
+	}
+}
+
+func TestMemoryTransport(t *testing.T) {
+	memory := NewMemoryTransport()
+	memory.Send(Message{To: "a@gmail.com", Subject: "1"})
+	memory.Send(Message{To: "b@gmail.com", Subject: "2"})
+	memory.Send(Message{To: "a@gmail.com", Subject: "3"})
+
+	if m, ok := memory.Last("a@gmail.com"); !ok || m.Subject != "3" {
+		t.Errorf("Expect the last email of an address. Got %v, %v", m, ok)
+	}
+
+	if _, ok := memory.Last("c@gmail.com"); ok {
+		t.Error("Expect no emails to other addresses")
+	}
+
+	if len(memory.Messages()) != 3 {
+		t.Errorf("Expect all emails. Got %v", memory.Messages())
+	}
+
+	memory.Reset()
+	if len(memory.Messages()) != 0 {
+		t.Errorf("Expect no emails after a reset. Got %v", memory.Messages())
+	}
+}
+
+func TestDirTransport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "emails")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	transport := NewDirTransport(filepath.Join(dir, "new"))
+	if err := transport.Send(Message{From: "from@unnamed.com", To: "to/../me@gmail.com", Subject: "Hi", Text: "Hi"}); err != nil {
+		t.Fatal(err)
+	}
+
+	files, _ := filepath.Glob(filepath.Join(dir, "new", "*_to_.._me@gmail.com.eml"))
+	if len(files) != 1 {
+		t.Fatalf("Expect one file in the directory. Got %v", files)
+	}
+
+	data, _ := ioutil.ReadFile(files[0])
+	if msg, err := mail.ReadMessage(strings.NewReader(string(data))); err != nil || msg.Header.Get("Subject") != "Hi" {
+		t.Errorf("Expect an email in the file. Got %v", err)
+	}
+}
+
+// fakeDb remembers the arguments of the last query instead of running it
+type fakeDb struct {
+	args []interface{}
+}
+
+func (db *fakeDb) Exec(query string, args ...interface{}) (sql.Result, error) {
+	db.args = args
+	return nil, nil
+}
+
+func TestEmailConfirmation(t *testing.T) {
+	config.Cfg.MailTransport, config.Cfg.MailTemplates, config.Cfg.IsTest = TransportMemory, "templates", true
+	Init()
+
+	// emails of the memory transport are never redirected to PROJ_TEST_EMAIL
+	db := &fakeDb{}
+	if err := EmailConfirmation(db, "albert@gmail.com", DefaultLanguage, "secret_code"); err != nil {
+		t.Fatal(err)
+	}
+
+	// sender, recipient, subject, text, html, for how many minutes the secret is valid
+	if len(db.args) != 6 || db.args[0] != emailFrom || db.args[1] != "albert@gmail.com" || db.args[5] != misc.ConfCodeTtl ||
+		!strings.Contains(db.args[3].(string), "secret_code") || !strings.Contains(db.args[4].(string), "<b>secret_code</b>") {
+		t.Errorf("Expect an email with a code in the outbox. Got %v", db.args)
+	}
+
+	if err := AccountLocked(db, "albert@gmail.com", DefaultLanguage, 15); err != nil || db.args[5] != 0 {
+		t.Errorf("Expect an email without a secret. Got %v, %v", db.args, err)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	table := []struct {
+		attempts int
+		delay    time.Duration
+	}{
+		{1, 30 * time.Second},
+		{2, time.Minute},
+		{5, 8 * time.Minute},
+		{10, 256 * time.Minute},
+		{11, 6 * time.Hour},
+		{100, 6 * time.Hour},
+	}
+	for num, v := range table {
+		if delay := backoff(v.attempts); delay != v.delay {
+			t.Errorf("Case %v. Expect %v. Got %v", num, v.delay, delay)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+package mailer
+
+import (
+	mailgun "github.com/mailgun/mailgun-go"
+	"log"
+)
+
+// MailgunTransport sends emails with the Mailgun API https://documentation.mailgun.com/api-sending.html#examples
+type MailgunTransport struct {
+	mg mailgun.Mailgun
+}
+
+// NewMailgunTransport creates a transport of a Mailgun domain
+func NewMailgunTransport(domain, privateKey, publicKey string) *MailgunTransport {
+	return &MailgunTransport{mailgun.NewMailgun(domain, privateKey, publicKey)}
+}
+
+// Send sends an email through Mailgun
+func (t *MailgunTransport) Send(m Message) error {
+	msg := mailgun.NewMessage(m.From, m.Subject, m.Text, m.To)
+	msg.SetHtml(m.Html)
+
+	response, id, err := t.mg.Send(msg)
+	if err != nil {
+		return err
+	}
+
+	log.Println("Email sent", id, response)
+	return nil
+}
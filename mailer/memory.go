@@ -0,0 +1,52 @@
+package mailer
+
+import "sync"
+
+// MemoryTransport keeps emails in memory instead of sending them. Tests read them back
+type MemoryTransport struct {
+	mu       sync.Mutex
+	messages []Message
+}
+
+// NewMemoryTransport creates an empty transport
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{}
+}
+
+// Send remembers an email
+func (t *MemoryTransport) Send(m Message) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.messages = append(t.messages, m)
+	return nil
+}
+
+// Messages returns all emails in the order they were sent
+func (t *MemoryTransport) Messages() []Message {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]Message{}, t.messages...)
+}
+
+// Last returns the last email sent to an address
+func (t *MemoryTransport) Last(to string) (Message, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for i := len(t.messages) - 1; i >= 0; i-- {
+		if t.messages[i].To == to {
+			return t.messages[i], true
+		}
+	}
+	return Message{}, false
+}
+
+// Reset forgets all emails
+func (t *MemoryTransport) Reset() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.messages = nil
+}
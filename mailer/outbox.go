@@ -0,0 +1,147 @@
+package mailer
+
+import (
+	"../config"
+	"../misc"
+	"database/sql"
+	"log"
+	"time"
+)
+
+// Delivery of emails from the outbox
+const (
+	firstRetry   = 30 * time.Second // delay after the first failure. Doubles after every next one
+	maxRetry     = 6 * time.Hour    // the longest delay between two attempts
+	claimFor     = 5 * time.Minute  // a claimed email is not given to other workers for this time
+	pollInterval = 5 * time.Second  // how often an idle worker checks the outbox
+)
+
+// Execer is either a database or a transaction. Emails are queued in the transaction of a change
+// which causes them, so they are not lost and are not sent if the change is rolled back
+type Execer interface {
+	Exec(query string, args ...interface{}) (sql.Result, error)
+}
+
+// enqueue puts an email in the outbox. Workers started with StartWorkers deliver it. An email with a
+// secret (a code or a token) is valid for secretTtl minutes, 0 means that it has no secret
+func enqueue(db Execer, m Message, secretTtl int) error {
+	_, err := db.Exec(`
+		INSERT INTO email_outbox (sender, recipient, subject, text_body, html_body, secret_expires_at)
+		VALUES ($1, $2, $3, $4, $5, CASE WHEN $6 > 0 THEN (now() at time zone 'utc') + $6 * interval '1 minute' END)`,
+		m.From, m.To, m.Subject, m.Text, m.Html, secretTtl)
+	return err
+}
+
+// backoff returns for how long an email waits after a number of failed deliveries
+func backoff(attempts int) time.Duration {
+	delay := firstRetry
+	for i := 1; i < attempts && delay < maxRetry; i++ {
+		delay *= 2
+	}
+
+	if delay > maxRetry {
+		return maxRetry
+	}
+	return delay
+}
+
+// StartWorkers starts goroutines which deliver emails from the outbox until the process exits.
+// Workers of all instances of the service share the outbox, an email is given only to one of them
+func StartWorkers(db *sql.DB, workers int) {
+	for i := 0; i < workers; i++ {
+		go func() {
+			for {
+				if ok, err := deliverNext(db); err != nil || !ok {
+					if err != nil {
+						log.Println(err)
+					}
+
+					if err := expireSecrets(db); err != nil {
+						log.Println(err)
+					}
+					time.Sleep(pollInterval)
+				}
+			}
+		}()
+	}
+}
+
+// Deliver sends all emails which are due now and returns their number. Useful when emails should
+// be delivered without waiting for workers, like in tests
+func Deliver(db *sql.DB) int {
+	if err := expireSecrets(db); err != nil {
+		log.Println(err)
+	}
+
+	delivered := 0
+	for {
+		ok, err := deliverNext(db)
+		if err != nil {
+			log.Println(err)
+		}
+
+		if !ok {
+			return delivered
+		}
+		delivered++
+	}
+}
+
+// deliverNext claims one email which is due and tries to send it. A sent email is removed from the
+// outbox, a failed one is retried later or becomes dead after PROJ_MAIL_MAX_ATTEMPTS failures.
+// Returns false if nothing is due
+func deliverNext(db *sql.DB) (bool, error) {
+	id, attempts, m := 0, 0, Message{}
+	if err := db.QueryRow(`
+		UPDATE email_outbox
+		SET next_attempt_at = (now() at time zone 'utc') + $1 * interval '1 second'
+		WHERE id = (
+			SELECT id
+			FROM email_outbox
+			WHERE status = $2 AND next_attempt_at <= (now() at time zone 'utc')
+			ORDER BY next_attempt_at
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING id, attempts, sender, recipient, subject, text_body, html_body`,
+		claimFor.Seconds(), misc.EmailPending,
+	).Scan(&id, &attempts, &m.From, &m.To, &m.Subject, &m.Text, &m.Html); err != nil {
+		if err == sql.ErrNoRows {
+			return false, nil
+		}
+		return false, err
+	}
+
+	sendErr := Sender.Send(m)
+	if sendErr == nil {
+		_, err := db.Exec(`
+			DELETE FROM email_outbox
+			WHERE id = $1`, id)
+		return true, err
+	}
+
+	attempts++
+	status := misc.EmailPending
+	if attempts >= config.Cfg.MailAttempts {
+		status = misc.EmailDead
+		log.Println("Email is dead", id, m.To, sendErr)
+	}
+
+	_, err := db.Exec(`
+		UPDATE email_outbox
+		SET status = $1, attempts = $2, last_error = $3,
+			next_attempt_at = (now() at time zone 'utc') + $4 * interval '1 second'
+		WHERE id = $5`, status, attempts, sendErr.Error(), backoff(attempts).Seconds(), id)
+	return true, err
+}
+
+// expireSecrets removes texts of emails whose code or token has expired, so the secrets are not kept
+// in plain text. Nobody can use them anymore, so such emails are dead and are not sent
+func expireSecrets(db *sql.DB) error {
+	_, err := db.Exec(`
+		UPDATE email_outbox
+		SET status = $1, text_body = '', html_body = ''
+		WHERE secret_expires_at <= (now() at time zone 'utc')
+			AND (status <> $1 OR text_body <> '' OR html_body <> '')`, misc.EmailDead)
+	return err
+}
@@ -0,0 +1,43 @@
+package mailer
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// samples are data of every message for previews
+var samples = map[string]Data{
+	"confirmation":   {"Code": "6kR2pQ9wZ1xN0bVt4yHs8aLm3cJe7uDf5gKo2iWq1Ep", "Hours": 24},
+	"email_change":   {"Code": "6kR2pQ9wZ1xN0bVt4yHs8aLm3cJe7uDf5gKo2iWq1Ep", "Hours": 24},
+	"password_reset": {"Token": "Zt3mQ8vX1bN6cR0pL4sW9yK2hJ7fD5gA3eU1oI8qTr", "Minutes": 60},
+	"account_locked": {"Minutes": 15},
+}
+
+// Command executes mailer subcommand of the server: `preview <template> [language]` prints a message
+// rendered with sample data, so the copy can be checked without sending emails
+func Command(args []string, out io.Writer) error {
+	usage := "usage: mailer preview <template> [language]. Templates: " + strings.Join(Emails.Names(), ", ")
+	if len(args) < 2 || args[0] != "preview" {
+		return errors.New(usage)
+	}
+
+	data, ok := samples[args[1]]
+	if !ok {
+		return fmt.Errorf("no sample data of %s. %s", args[1], usage)
+	}
+
+	language := DefaultLanguage
+	if len(args) > 2 {
+		language = args[2]
+	}
+
+	subject, text, textHtml, err := Emails.Render(args[1], language, data)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Subject: %s\n\n%s\n\n%s\n", subject, text, textHtml)
+	return nil
+}
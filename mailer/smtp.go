@@ -0,0 +1,28 @@
+package mailer
+
+import (
+	"net"
+	"net/smtp"
+)
+
+// SmtpTransport sends emails through an SMTP server. The connection is upgraded with STARTTLS if the
+// server supports it. Credentials are sent only over TLS (or to localhost)
+type SmtpTransport struct {
+	addr string
+	auth smtp.Auth
+}
+
+// NewSmtpTransport creates a transport of a server at host:port. Without a user no authentication is done
+func NewSmtpTransport(addr, user, password string) *SmtpTransport {
+	t := &SmtpTransport{addr: addr}
+	if user != "" {
+		host, _, _ := net.SplitHostPort(addr)
+		t.auth = smtp.PlainAuth("", user, password, host)
+	}
+	return t
+}
+
+// Send sends an email through the server
+func (t *SmtpTransport) Send(m Message) error {
+	return smtp.SendMail(t.addr, t.auth, m.From, []string{m.To}, m.Bytes())
+}
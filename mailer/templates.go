@@ -0,0 +1,128 @@
+package mailer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	htmlTemplate "html/template"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+	textTemplate "text/template"
+)
+
+// DefaultLanguage is used for users without a preference and for messages which are not translated
+const DefaultLanguage = "en"
+
+// Data is passed to a template. Keys are fields which a template uses, like {{.Code}}
+type Data map[string]interface{}
+
+// Templates are all emails in all languages. They are read from a directory like this:
+//
+//	en/layout.txt        layouts wrap the "body" of every message of a language
+//	en/layout.html
+//	en/confirmation.txt  a message defines "subject" and "body" blocks
+//	en/confirmation.html a message defines only "body" block
+//	ru/...
+type Templates struct {
+	text map[string]*textTemplate.Template // key is language/name
+	html map[string]*htmlTemplate.Template
+}
+
+// LoadTemplates reads and parses all templates of a directory. A message needs both a text and an
+// HTML version. Missing fields of Data are errors, so a typo in a template is noticed in a preview
+func LoadTemplates(dir string) (*Templates, error) {
+	t := &Templates{map[string]*textTemplate.Template{}, map[string]*htmlTemplate.Template{}}
+
+	languages, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, language := range languages {
+		if !language.IsDir() {
+			continue
+		}
+
+		langDir := filepath.Join(dir, language.Name())
+		files, err := filepath.Glob(filepath.Join(langDir, "*.txt"))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, file := range files {
+			name := strings.TrimSuffix(filepath.Base(file), ".txt")
+			if name == "layout" {
+				continue
+			}
+
+			key := language.Name() + "/" + name
+			if t.text[key], err = textTemplate.New("layout.txt").Option("missingkey=error").ParseFiles(
+				filepath.Join(langDir, "layout.txt"), file); err != nil {
+				return nil, err
+			}
+
+			if t.html[key], err = htmlTemplate.New("layout.html").Option("missingkey=error").ParseFiles(
+				filepath.Join(langDir, "layout.html"), filepath.Join(langDir, name+".html")); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if len(t.Names()) == 0 {
+		return nil, fmt.Errorf("No templates of language %s in %s", DefaultLanguage, dir)
+	}
+	return t, nil
+}
+
+// Render returns a subject, a plain text and HTML of a message in a language. Messages which are
+// not translated to the language are rendered in DefaultLanguage
+func (t *Templates) Render(name, language string, data Data) (string, string, string, error) {
+	key := language + "/" + name
+	if _, ok := t.text[key]; !ok {
+		key = DefaultLanguage + "/" + name
+	}
+
+	text, ok := t.text[key]
+	if !ok {
+		return "", "", "", errors.New("Unknown email template " + name)
+	}
+
+	var subject, body, html bytes.Buffer
+	if err := text.ExecuteTemplate(&subject, "subject", data); err != nil {
+		return "", "", "", err
+	}
+
+	if err := text.Execute(&body, data); err != nil {
+		return "", "", "", err
+	}
+
+	if err := t.html[key].Execute(&html, data); err != nil {
+		return "", "", "", err
+	}
+
+	return strings.TrimSpace(subject.String()), strings.TrimSpace(body.String()), html.String(), nil
+}
+
+// Names returns names of all messages of DefaultLanguage in alphabetical order
+func (t *Templates) Names() []string {
+	names := []string{}
+	for key := range t.text {
+		if strings.HasPrefix(key, DefaultLanguage+"/") {
+			names = append(names, strings.TrimPrefix(key, DefaultLanguage+"/"))
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// HasLanguage checks whether at least one message is translated to a language
+func (t *Templates) HasLanguage(language string) bool {
+	for key := range t.text {
+		if strings.HasPrefix(key, language+"/") {
+			return true
+		}
+	}
+	return false
+}
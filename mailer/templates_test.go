@@ -0,0 +1,110 @@
+package mailer
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRender(t *testing.T) {
+	templates, err := LoadTemplates("templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		language string
+		data     Data
+		subject  string
+		text     string
+		html     string
+		ok       bool
+	}{
+		{"confirmation", "en", Data{"Code": "abc", "Hours": 24}, "Please confirm your registration", "abc", "<b>abc</b>", true},
+		{"confirmation", "ru", Data{"Code": "abc", "Hours": 24}, "Подтвердите регистрацию", "abc", "<b>abc</b>", true},
+		{"confirmation", "de", Data{"Code": "abc", "Hours": 24}, "Please confirm your registration", "abc", "<b>abc</b>", true},
+		{"account_locked", "ru", Data{"Minutes": 15}, "", "15", "15", true},
+		{"confirmation", "en", Data{"Code": "<i>abc</i>"}, "", "", "", false},
+		{"confirmation", "en", Data{"Code": "<i>abc</i>", "Hours": 24}, "", "<i>abc</i>", "&lt;i&gt;abc&lt;/i&gt;", true},
+		{"unknown", "en", Data{}, "", "", "", false},
+	}
+
+	for num, v := range tests {
+		subject, text, html, err := templates.Render(v.name, v.language, v.data)
+		if (err == nil) != v.ok {
+			t.Errorf("Case %v. Expect success %v. Got %v", num, v.ok, err)
+			continue
+		}
+
+		if !strings.Contains(subject, v.subject) || !strings.Contains(text, v.text) || !strings.Contains(html, v.html) {
+			t.Errorf("Case %v. Expect %q, %q, %q. Got %q, %q, %q", num, v.subject, v.text, v.html, subject, text, html)
+		}
+	}
+}
+
+func TestLoadTemplates(t *testing.T) {
+	templates, err := LoadTemplates("templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if names := strings.Join(templates.Names(), ","); names != "account_locked,confirmation,email_change,password_reset" {
+		t.Errorf("Expect all messages. Got %v", names)
+	}
+
+	if !templates.HasLanguage("ru") || templates.HasLanguage("de") || templates.HasLanguage("") {
+		t.Error("Expect only languages with templates")
+	}
+
+	// a message without an HTML version
+	dir, err := ioutil.TempDir("", "templates")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	os.Mkdir(filepath.Join(dir, "en"), 0755)
+	for _, file := range []string{"layout.txt", "layout.html", "confirmation.txt"} {
+		data, _ := ioutil.ReadFile(filepath.Join("templates", "en", file))
+		ioutil.WriteFile(filepath.Join(dir, "en", file), data, 0644)
+	}
+
+	if _, err := LoadTemplates(dir); err == nil {
+		t.Error("Expect an error without an HTML version")
+	}
+
+	if _, err := LoadTemplates(filepath.Join(dir, "en")); err == nil {
+		t.Error("Expect an error without languages")
+	}
+}
+
+func TestCommand(t *testing.T) {
+	var err error
+	if Emails, err = LoadTemplates("templates"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args     []string
+		expected string
+		ok       bool
+	}{
+		{[]string{"preview", "password_reset"}, "Subject: ", true},
+		{[]string{"preview", "confirmation", "ru"}, "Подтвердите регистрацию", true},
+		{[]string{"preview"}, "", false},
+		{[]string{"show", "confirmation"}, "", false},
+		{[]string{"preview", "unknown"}, "", false},
+	}
+
+	for num, v := range tests {
+		var out bytes.Buffer
+		err := Command(v.args, &out)
+		if (err == nil) != v.ok || !strings.Contains(out.String(), v.expected) {
+			t.Errorf("Case %v. Expect %q, %v. Got %q, %v", num, v.expected, v.ok, out.String(), err)
+		}
+	}
+}
@@ -0,0 +1,66 @@
+package mailer
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net/textproto"
+	"time"
+)
+
+// Names of transports in PROJ_MAIL_TRANSPORT
+const (
+	TransportMailgun = "mailgun" // Mailgun API
+	TransportSmtp    = "smtp"    // any SMTP server
+	TransportDir     = "dir"     // .eml files in a directory, for local development
+	TransportMemory  = "memory"  // nothing leaves the process, for tests
+)
+
+// Message is an email with a plain text and an HTML version
+type Message struct {
+	From    string
+	To      string
+	Subject string
+	Text    string
+	Html    string
+}
+
+// Transport delivers emails. Implementations are safe for concurrent use
+type Transport interface {
+	Send(m Message) error
+}
+
+// Bytes encodes a message as a MIME email (RFC 5322) where the plain text and HTML are alternatives
+func (m Message) Bytes() []byte {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain", m.Text},
+		{"text/html", m.Html},
+	} {
+		if part.content == "" {
+			continue
+		}
+
+		pw, _ := w.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {part.contentType + "; charset=utf-8"},
+			"Content-Transfer-Encoding": {"quoted-printable"},
+		})
+		qp := quotedprintable.NewWriter(pw)
+		qp.Write([]byte(part.content)) // line breaks become CRLF
+		qp.Close()
+	}
+	w.Close()
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", m.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", m.To)
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", m.Subject))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n", w.Boundary())
+	msg.Write(body.Bytes())
+	return msg.Bytes()
+}
@@ -0,0 +1,347 @@
+// Package migrate evolves the database schema with numbered migrations.
+// Each migration is a pair of files in a migrations folder: 0002_add_smth.up.sql and
+// 0002_add_smth.down.sql. Applied versions are stored in schema_migrations table
+package migrate
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	// All can be passed as a number of steps to apply/revert every migration
+	All = -1
+	// lockKey is a key of the advisory lock which prevents concurrent migrations
+	lockKey = 7315044812
+)
+
+// Migration describes one version of the schema
+type Migration struct {
+	Version int
+	Name    string
+	Up      string // path to the file which applies the migration
+	Down    string // path to the file which reverts the migration
+}
+
+// State describes whether a migration is applied. Migrations applied to the database but missing
+// in the folder have empty Up and Down
+type State struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+var fileNameRe = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+// Load reads all migrations from the folder sorted by version. Every version has to have both
+// up and down files and the versions should be unique
+func Load(dir string) ([]Migration, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, f := range files {
+		m := fileNameRe.FindStringSubmatch(f.Name())
+		if f.IsDir() || m == nil {
+			continue
+		}
+
+		version, _ := strconv.Atoi(m[1])
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = migration
+		} else if migration.Name != m[2] {
+			return nil, fmt.Errorf("migration %d has two names: %s and %s", version, migration.Name, m[2])
+		}
+
+		path := filepath.Join(dir, f.Name())
+		if m[3] == "up" {
+			migration.Up = path
+		} else {
+			migration.Down = path
+		}
+	}
+
+	migrations := []Migration{}
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %d (%s) should have both up and down files", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+	return migrations, nil
+}
+
+// Up applies at most steps pending migrations (All to apply everything) and returns the applied ones
+func Up(db *sql.DB, dir string, steps int) ([]Migration, error) {
+	migrations, err := Load(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	done := []Migration{}
+	for steps == All || len(done) < steps {
+		migration, err := step(db, func(applied map[int]time.Time) (*Migration, error) {
+			for i, m := range migrations {
+				if _, ok := applied[m.Version]; !ok {
+					return &migrations[i], nil
+				}
+			}
+			return nil, nil
+		}, true)
+		if err != nil || migration == nil {
+			return done, err
+		}
+		done = append(done, *migration)
+	}
+	return done, nil
+}
+
+// Down reverts at most steps latest migrations (All to revert everything) and returns the reverted ones
+func Down(db *sql.DB, dir string, steps int) ([]Migration, error) {
+	migrations, err := Load(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	done := []Migration{}
+	for steps == All || len(done) < steps {
+		migration, err := step(db, func(applied map[int]time.Time) (*Migration, error) {
+			latest := -1
+			for version := range applied {
+				if version > latest {
+					latest = version
+				}
+			}
+			if latest == -1 {
+				return nil, nil
+			}
+
+			for i, m := range migrations {
+				if m.Version == latest {
+					return &migrations[i], nil
+				}
+			}
+			return nil, fmt.Errorf("migration %d is applied, but its files are missing", latest)
+		}, false)
+		if err != nil || migration == nil {
+			return done, err
+		}
+		done = append(done, *migration)
+	}
+	return done, nil
+}
+
+// Status returns the state of every migration found either in the folder or in the database
+func Status(db *sql.DB, dir string) ([]State, error) {
+	migrations, err := Load(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ensureTable(db); err != nil {
+		return nil, err
+	}
+
+	applied, err := getApplied(db)
+	if err != nil {
+		return nil, err
+	}
+
+	states := []State{}
+	for _, m := range migrations {
+		state := State{Migration: m}
+		if t, ok := applied[m.Version]; ok {
+			state.AppliedAt = &t
+			delete(applied, m.Version)
+		}
+		states = append(states, state)
+	}
+
+	for version, t := range applied {
+		appliedAt := t
+		states = append(states, State{Migration{Version: version}, &appliedAt})
+	}
+
+	sort.Slice(states, func(i, j int) bool {
+		return states[i].Version < states[j].Version
+	})
+	return states, nil
+}
+
+// Command executes migrate subcommand of the server: `up [n]`, `down [n|all]` or `status`.
+// Without a number up applies everything and down reverts only the latest migration
+func Command(db *sql.DB, dir string, args []string, out io.Writer) error {
+	if len(args) == 0 {
+		return errors.New("usage: migrate up [n] | down [n|all] | status")
+	}
+
+	if args[0] == "status" {
+		states, err := Status(db, dir)
+		if err != nil {
+			return err
+		}
+
+		for _, s := range states {
+			switch {
+			case s.AppliedAt == nil:
+				fmt.Fprintf(out, "%04d %s: pending\n", s.Version, s.Name)
+			case s.Up == "":
+				fmt.Fprintf(out, "%04d: applied at %s, files are missing\n", s.Version, s.AppliedAt.Format(time.RFC3339))
+			default:
+				fmt.Fprintf(out, "%04d %s: applied at %s\n", s.Version, s.Name, s.AppliedAt.Format(time.RFC3339))
+			}
+		}
+		return nil
+	}
+
+	steps := All
+	if args[0] == "down" {
+		steps = 1
+	}
+	if len(args) > 1 {
+		if args[1] == "all" {
+			steps = All
+		} else if n, err := strconv.Atoi(args[1]); err == nil && n > 0 {
+			steps = n
+		} else {
+			return fmt.Errorf("wrong number of steps: %s", args[1])
+		}
+	}
+
+	var done []Migration
+	var err error
+	switch args[0] {
+	case "up":
+		done, err = Up(db, dir, steps)
+	case "down":
+		done, err = Down(db, dir, steps)
+	default:
+		return fmt.Errorf("unknown migrate command: %s", args[0])
+	}
+
+	for _, m := range done {
+		fmt.Fprintf(out, "%s %04d %s\n", args[0], m.Version, m.Name)
+	}
+	if err == nil && len(done) == 0 {
+		fmt.Fprintln(out, "nothing to do")
+	}
+	return err
+}
+
+// step applies (or reverts) one migration chosen by pick in its own transaction. The advisory lock is
+// taken before the applied versions are read, so concurrent runs wait for each other and never apply
+// the same migration twice. Returns nil migration if there is nothing to do
+func step(db *sql.DB, pick func(map[int]time.Time) (*Migration, error), isUp bool) (*Migration, error) {
+	if err := ensureTable(db); err != nil {
+		return nil, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, lockKey); err != nil {
+		return nil, err
+	}
+
+	applied, err := getApplied(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	migration, err := pick(applied)
+	if err != nil || migration == nil {
+		return nil, err
+	}
+
+	path := migration.Down
+	if isUp {
+		path = migration.Up
+	}
+
+	query, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// without arguments lib/pq uses simple query protocol, so a file can have many statements
+	if _, err := tx.Exec(string(query)); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	if isUp {
+		_, err = tx.Exec(`INSERT INTO schema_migrations (version, name) VALUES ($1, $2)`, migration.Version, migration.Name)
+	} else {
+		_, err = tx.Exec(`DELETE FROM schema_migrations WHERE version = $1`, migration.Version)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return migration, tx.Commit()
+}
+
+// ensureTable creates bookkeeping table. It is done under the same lock, because two concurrent
+// CREATE TABLE IF NOT EXISTS can still fail
+func ensureTable(db *sql.DB) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, lockKey); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version integer PRIMARY KEY,
+			name character varying(255) NOT NULL,
+			applied_at timestamp without time zone NOT NULL DEFAULT now()
+		)`); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func getApplied(q querier) (map[int]time.Time, error) {
+	rows, err := q.Query(`SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]time.Time{}
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
@@ -0,0 +1,309 @@
+package migrate
+
+import (
+	"../config"
+	"database/sql"
+	"fmt"
+	_ "github.com/lib/pq"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var db *sql.DB
+
+// tests use their own schema, so the schema of the project and its schema_migrations are not touched
+func TestMain(m *testing.M) {
+	config.Init()
+	dbURL := fmt.Sprintf("postgresql://%s:%s@%s:%d/%s?sslmode=disable&search_path=migrate_tests",
+		config.Cfg.DbUser,
+		config.Cfg.DbPass,
+		config.Cfg.DbHost,
+		config.Cfg.DbPort,
+		config.Cfg.DbName,
+	)
+
+	var err error
+	if db, err = sql.Open("postgres", dbURL); err != nil {
+		log.Fatal(err)
+	}
+
+	if _, err := db.Exec(`CREATE SCHEMA IF NOT EXISTS migrate_tests`); err != nil {
+		log.Fatal(err)
+	}
+	retCode := m.Run()
+
+	db.Exec(`DROP SCHEMA migrate_tests CASCADE`)
+	db.Close()
+	os.Exit(retCode)
+}
+
+func createFiles(t *testing.T, names ...string) string {
+	dir, err := ioutil.TempDir("", "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range names {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte("SELECT 1;"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestLoad(t *testing.T) {
+	dir := createFiles(t,
+		"0002_add_votes.up.sql", "0002_add_votes.down.sql",
+		"0001_initial.down.sql", "0001_initial.up.sql",
+		"0010_later.up.sql", "0010_later.down.sql",
+		"README.md", "populate.sql",
+	)
+	defer os.RemoveAll(dir)
+
+	migrations, err := Load(dir)
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+
+	expected := []struct {
+		version int
+		name    string
+	}{{1, "initial"}, {2, "add_votes"}, {10, "later"}}
+	if len(migrations) != len(expected) {
+		t.Fatalf("Expected %d migrations, got %d", len(expected), len(migrations))
+	}
+
+	for i, v := range expected {
+		m := migrations[i]
+		if m.Version != v.version || m.Name != v.name {
+			t.Errorf("Expected migration %d %s, got %d %s", v.version, v.name, m.Version, m.Name)
+		}
+		if filepath.Ext(m.Up) != ".sql" || filepath.Dir(m.Up) != dir || filepath.Dir(m.Down) != dir {
+			t.Errorf("Up and down files do not match: %s, %s", m.Up, m.Down)
+		}
+	}
+}
+
+func TestLoadWrong(t *testing.T) {
+	for _, files := range [][]string{
+		{"0001_initial.up.sql"},
+		{"0001_initial.down.sql"},
+		{"0001_initial.up.sql", "0001_initial.down.sql", "0002_smth.up.sql"},
+		{"0001_initial.up.sql", "0001_other.down.sql"},
+	} {
+		dir := createFiles(t, files...)
+		if _, err := Load(dir); err == nil {
+			t.Errorf("Expected an error for files %v", files)
+		}
+		os.RemoveAll(dir)
+	}
+
+	if _, err := Load("/does/not/exist"); err == nil {
+		t.Error("Expected an error for missing folder")
+	}
+}
+
+func TestCommandWrongArgs(t *testing.T) {
+	for _, args := range [][]string{
+		{},
+		{"up", "0"},
+		{"down", "-3"},
+		{"down", "few"},
+		{"sideways"},
+	} {
+		if err := Command(nil, "", args, ioutil.Discard); err == nil {
+			t.Errorf("Expected an error for arguments %v", args)
+		}
+	}
+}
+
+// createMigrations creates migrations which add and drop tables a, b and c. A migration is broken if
+// its table is in broken
+func createMigrations(t *testing.T, broken ...string) string {
+	dir, err := ioutil.TempDir("", "migrations")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i, table := range []string{"a", "b", "c"} {
+		up := fmt.Sprintf("CREATE TABLE %s (id integer);\nINSERT INTO %s VALUES (%d);", table, table, i)
+		for _, b := range broken {
+			if b == table {
+				up += "\nSELECT * FROM does_not_exist;"
+			}
+		}
+
+		name := filepath.Join(dir, fmt.Sprintf("%04d_add_%s", i+1, table))
+		if err := ioutil.WriteFile(name+".up.sql", []byte(up), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(name+".down.sql", []byte("DROP TABLE "+table+";"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+// cleanUp removes everything that a test has applied
+func cleanUp(t *testing.T) {
+	if _, err := db.Exec(`
+		DROP TABLE IF EXISTS a, b, c;
+		DROP TABLE IF EXISTS schema_migrations;`); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func getTables(t *testing.T) string {
+	tables := ""
+	for _, table := range []string{"a", "b", "c"} {
+		isExists := false
+		if err := db.QueryRow(`
+			SELECT EXISTS (
+				SELECT 1 FROM information_schema.tables
+				WHERE table_schema = 'migrate_tests' AND table_name = $1
+			)`, table,
+		).Scan(&isExists); err != nil {
+			t.Fatal(err)
+		}
+		if isExists {
+			tables += table
+		}
+	}
+	return tables
+}
+
+func getVersions(migrations []Migration) []int {
+	versions := []int{}
+	for _, m := range migrations {
+		versions = append(versions, m.Version)
+	}
+	return versions
+}
+
+func TestUpDown(t *testing.T) {
+	cleanUp(t)
+	dir := createMigrations(t)
+	defer os.RemoveAll(dir)
+
+	table := []struct {
+		isUp     bool
+		steps    int
+		versions string
+		tables   string
+	}{
+		{true, 2, "[1 2]", "ab"},
+		{true, All, "[3]", "abc"},
+		{true, All, "[]", "abc"},
+		{false, 1, "[3]", "ab"},
+		{false, All, "[2 1]", ""},
+		{false, All, "[]", ""},
+		{true, 1, "[1]", "a"},
+	}
+	for num, v := range table {
+		var migrations []Migration
+		var err error
+		if v.isUp {
+			migrations, err = Up(db, dir, v.steps)
+		} else {
+			migrations, err = Down(db, dir, v.steps)
+		}
+
+		versions := fmt.Sprint(getVersions(migrations))
+		if err != nil || versions != v.versions || getTables(t) != v.tables {
+			t.Errorf("Case %v. Expected %v and tables %q, got %v, %v and tables %q", num, v.versions, v.tables, versions, err, getTables(t))
+		}
+	}
+
+	states, err := Status(db, dir)
+	if err != nil || len(states) != 3 || states[0].AppliedAt == nil || states[1].AppliedAt != nil || states[2].AppliedAt != nil {
+		t.Errorf("Expected only the first migration to be applied, got %v, %v", states, err)
+	}
+
+	// a migration which is applied, but its files are missing can't be reverted
+	os.Remove(filepath.Join(dir, "0001_add_a.up.sql"))
+	os.Remove(filepath.Join(dir, "0001_add_a.down.sql"))
+	if states, err := Status(db, dir); err != nil || len(states) != 3 || states[0].AppliedAt == nil || states[0].Up != "" {
+		t.Errorf("Expected an applied migration without files, got %v, %v", states, err)
+	}
+
+	if _, err := Down(db, dir, 1); err == nil || getTables(t) != "a" {
+		t.Errorf("Expected a migration without files not to be reverted, got %v", err)
+	}
+}
+
+func TestUpBroken(t *testing.T) {
+	cleanUp(t)
+	dir := createMigrations(t, "b")
+	defer os.RemoveAll(dir)
+
+	// a broken migration is rolled back completely and the next ones are not applied
+	migrations, err := Up(db, dir, All)
+	if err == nil || fmt.Sprint(getVersions(migrations)) != "[1]" || getTables(t) != "a" {
+		t.Errorf("Expected only the first migration to be applied, got %v, %v and tables %q", migrations, err, getTables(t))
+	}
+
+	if states, err := Status(db, dir); err != nil || states[0].AppliedAt == nil || states[1].AppliedAt != nil {
+		t.Errorf("Expected the broken migration not to be recorded, got %v, %v", states, err)
+	}
+}
+
+func TestLock(t *testing.T) {
+	cleanUp(t)
+	dir := createMigrations(t)
+	defer os.RemoveAll(dir)
+
+	// while somebody holds the lock, migrations wait
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, lockKey); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	go func() {
+		_, err := Up(db, dir, All)
+		done <- err
+	}()
+
+	select {
+	case err := <-done:
+		t.Errorf("Expected migrations to wait for the lock, got %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	tx.Rollback()
+	if err := <-done; err != nil || getTables(t) != "abc" {
+		t.Errorf("Expected migrations to be applied after the lock is released, got %v and tables %q", err, getTables(t))
+	}
+
+	// concurrent runs apply every migration exactly once
+	if _, err := Down(db, dir, All); err != nil {
+		t.Fatal(err)
+	}
+
+	applied := make(chan []Migration)
+	for i := 0; i < 5; i++ {
+		go func() {
+			migrations, err := Up(db, dir, All)
+			if err != nil {
+				t.Error(err)
+			}
+			applied <- migrations
+		}()
+	}
+
+	versions := []int{}
+	for i := 0; i < 5; i++ {
+		versions = append(versions, getVersions(<-applied)...)
+	}
+
+	if len(versions) != 3 || getTables(t) != "abc" {
+		t.Errorf("Expected every migration to be applied once, got %v", versions)
+	}
+}
@@ -1,18 +1,59 @@
 package misc
 
 import (
+	"encoding/base64"
+	"log"
 	"math/rand"
 	"net/mail"
+	"strconv"
 	"strings"
+	"time"
 )
 
 const (
-	letterBytes    = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
-	passwordMinLen = 8
-	ConfCodeLen    = 20   // length of the confirmation code which will be sent to a newly created user
-	MaxTags        = 4    // maximum number of tags possible for a purchase
-	MaxLenS        = 40   // maximum length of the small field in SQL
-	MaxLenB        = 1000 // maximum length of the big field in SQL
+	letterBytes     = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	passwordMinLen  = 8
+	MaxTags         = 4    // maximum number of tags possible for a purchase
+	MaxLenS         = 40   // maximum length of the small field in SQL
+	MaxLenB         = 1000 // maximum length of the big field in SQL
+	MaxLenUserAgent = 256  // maximum length of User-Agent stored with a session
+	PageSize        = 20   // number of elements on a page if a client has not asked for a specific number
+	MaxPageSize     = 100  // maximum number of elements a client can ask for on one page
+	ResetTokenTtl   = 60   // for how many minutes a token to reset a password is valid
+	ConfCodeTtl     = 1440 // for how many minutes a confirmation code of an email is valid
+	ConfCodeResend  = 1    // minimum number of minutes between two confirmation emails of a user
+	MfaTokenTtl     = 5    // for how many minutes a token of the second step of a login is valid
+	MfaMaxAttempts  = 5    // number of wrong codes after which a token of the second step stops working
+	RecoveryCodeNum = 10   // number of recovery codes a user gets after enabling 2FA
+	UploadTtl       = 30   // for how many days an uploaded image can be used as an avatar or in a purchase
+)
+
+// Roles of users. A role is carried in a jwt token
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator" // manages brands and tags
+	RoleAdmin     = "admin"     // manages roles of other users and everything a moderator does
+)
+
+// Kinds and statuses of proposals
+const (
+	ProposalBrand    = "brand"
+	ProposalTag      = "tag"
+	ProposalPending  = "pending"
+	ProposalApproved = "approved"
+	ProposalRejected = "rejected"
+)
+
+// Kinds of uploaded images. An image of one kind can't be used as another
+const (
+	UploadAvatar   = "avatar"
+	UploadPurchase = "purchase"
+)
+
+// Statuses of emails in the outbox. Delivered emails are removed from it
+const (
+	EmailPending = "pending" // waits for the next attempt of a delivery
+	EmailDead    = "dead"    // failed too many times. Is sent again only if an admin asks
 )
 
 // Error codes
@@ -22,6 +63,10 @@ const (
 	NoElement       = 101 // searched for an element by ID. Have not found it.
 	NoPurchase      = 102 // purchase with such ID does not exist
 	NotNatural      = 103 // provided value was not a natural number
+	NoQuestion      = 104 // question with such ID does not exist
+	NoAnswer        = 105 // answer with such ID does not exist
+	NoProposal      = 106 // pending proposal with such ID does not exist
+	NoEmail         = 107 // dead email with such ID and with texts does not exist
 
 	WrongName           = 201 // name is too long or empty
 	WrongDescr          = 202 // description is too long or empty
@@ -35,10 +80,33 @@ const (
 	AnswerOtherPurchase = 210 // user can answer only question about his purchase
 	NoTags              = 211 // user has not provided any tags
 	WrongImg            = 212 // something wrong with the image
+	WrongCursor         = 213 // pagination cursor is malformed
+	WrongPageSize       = 214 // number of elements on a page is not in [1, MaxPageSize]
+	LikeAndIgnore       = 215 // a tag or a brand can't be liked and ignored at the same time
+	WrongSort           = 216 // elements can't be sorted in the requested order
+	NotYourPurchase     = 217 // user can change only his own purchases
+	WrongResetToken     = 218 // password reset token does not exist, expired or was already used
+	WrongOldPassword    = 219 // current password provided to change it is not correct
+	WrongRefreshToken   = 220 // refresh token does not exist, was already used or its session has ended
+	WrongKind           = 221 // proposal is neither a brand nor a tag
+	WrongRole           = 222 // role is not one of user, moderator, admin
+	TooManyAttempts     = 223 // too many failed logins or confirmation emails. A client should wait before the next attempt
+	WrongMfaCode        = 224 // code from an authenticator app or a recovery code is not correct
+	WrongMfaToken       = 225 // token of the second step of a login does not exist, expired or had too many wrong codes
+	MfaEnabled          = 226 // 2FA is already enabled
+	MfaNotSetUp         = 227 // 2FA was not set up or is not enabled
+	WrongConfCode       = 228 // confirmation code is not correct or there is no email to confirm
+	ConfCodeExpired     = 229 // confirmation code is correct, but expired. A new one should be requested
+	NothingToConfirm    = 230 // user is verified and has no new email, so no confirmation email is sent
+	WrongLanguage       = 231 // emails are not translated to the language
+	WrongUpload         = 232 // image was uploaded by another user, for something else, too long ago or is already used
 
 	NoSalt                = 301 // system does not have enough randomness
 	DbDuplicate           = 302 // duplicate constrain violation. Inserted X, where X already exists and should be unique
 	DbForeignKeyViolation = 303 // foreign key violation
+
+	// failures of the server are answered with 500 Internal Server Error
+	DbError = 500 // database failed, so nothing was stored
 )
 
 // ErrorCode stores code of a problem that happened while processing client's request.
@@ -62,6 +130,41 @@ type Jwt struct {
 	Jwt string `json:"token"`
 }
 
+// Tokens are given to a client after a login. An access JWT token authorizes requests for a short
+// time, a refresh token is exchanged for new tokens when it expires
+type Tokens struct {
+	Jwt           string `json:"token,omitempty"`
+	Refresh_token string `json:"refresh_token,omitempty"`
+	Mfa_token     string `json:"mfa_token,omitempty"` // the only token if a second step of a login is needed
+}
+
+// TotpSetup stores a new TOTP secret and the same secret as a URI for a QR code
+type TotpSetup struct {
+	Secret string `json:"secret"`
+	Uri    string `json:"uri"`
+}
+
+// RecoveryCodes are shown to a user only once, after 2FA is enabled
+type RecoveryCodes struct {
+	Codes []string `json:"recovery_codes"`
+}
+
+// Session describes one of the places where a user is logged in
+type Session struct {
+	Id           int    `json:"id"`
+	User_agent   string `json:"user_agent"`
+	Ip           string `json:"ip"`
+	Issued_at    int64  `json:"issued_at"`
+	Last_used_at int64  `json:"last_used_at"`
+	Is_current   bool   `json:"is_current,omitempty"`
+}
+
+// Client describes who opens or refreshes a session
+type Client struct {
+	User_agent string
+	Ip         string
+}
+
 // Brand stores all information about a Brand model
 type Brand struct {
 	Id        int    `json:"id,omitempty"`
@@ -77,6 +180,50 @@ type Tag struct {
 	Issued_at   int64  `json:"issued_at,omitempty"`
 }
 
+// Proposal stores a brand or a tag which a user wants to add. Created_id is an id of a brand or a tag
+// created after the approval
+type Proposal struct {
+	Id          int    `json:"id"`
+	Kind        string `json:"kind"`
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	User_id     int    `json:"user_id"`
+	Status      string `json:"status"`
+	Issued_at   int64  `json:"issued_at"`
+	Reviewed_at int64  `json:"reviewed_at,omitempty"`
+	Created_id  int    `json:"created_id,omitempty"`
+}
+
+// Upload describes an image uploaded by a user. It can be used only once
+type Upload struct {
+	Name   string `json:"name"`
+	Kind   string `json:"kind"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+	Size   int    `json:"size"`
+	Hash   string `json:"hash"`
+}
+
+// Email is a message of the outbox which could not be delivered
+type Email struct {
+	Id         int    `json:"id"`
+	Recipient  string `json:"recipient"`
+	Subject    string `json:"subject"`
+	Status     string `json:"status"`
+	Attempts   int    `json:"attempts"`
+	Last_error string `json:"last_error"`
+	Created_at int64  `json:"created_at"`
+
+	// when a code or a token of the email expires (0 if it has none). After it the email can't be sent again
+	Secret_expires_at int64 `json:"secret_expires_at,omitempty"`
+}
+
+// EmailPage stores one page of emails and a cursor to the next page (empty on the last page)
+type EmailPage struct {
+	Emails      []*Email `json:"emails"`
+	Next_cursor string   `json:"next_cursor"`
+}
+
 // User stores all information about a User model
 type User struct {
 	Id            int    `json:"id,omitempty"`
@@ -104,12 +251,49 @@ type Purchase struct {
 	Likes_num   int    `json:"likes_num,omitempty"`
 }
 
+// Question stores all information about a Question model together with its answers
+type Question struct {
+	Id          int       `json:"id,omitempty"`
+	Name        string    `json:"name,omitempty"`
+	User        *User     `json:"user,omitempty"`
+	Purchase_id int       `json:"purchase_id,omitempty"`
+	Votes_num   int       `json:"votes_num,omitempty"`
+	Issued_at   int64     `json:"issued_at,omitempty"`
+	Answers     []*Answer `json:"answers"`
+}
+
+// Answer stores all information about an Answer model
+type Answer struct {
+	Id          int    `json:"id,omitempty"`
+	Name        string `json:"name,omitempty"`
+	User        *User  `json:"user,omitempty"`
+	Question_id int    `json:"question_id,omitempty"`
+	Votes_num   int    `json:"votes_num,omitempty"`
+	Issued_at   int64  `json:"issued_at,omitempty"`
+}
+
+// Preferences stores tags and brands which a user likes or wishes to ignore
+type Preferences struct {
+	Tags_like     []int `json:"tags_like"`
+	Tags_ignore   []int `json:"tags_ignore"`
+	Brands_like   []int `json:"brands_like"`
+	Brands_ignore []int `json:"brands_ignore"`
+}
+
+// PurchasePage stores one page of purchases and a cursor to the next page (empty on the last page)
+type PurchasePage struct {
+	Purchases   []*Purchase `json:"purchases"`
+	Next_cursor string      `json:"next_cursor"`
+}
+
 // JwtToken stores authorization information about a user
 type JwtToken struct {
-	UserId   int
-	Iat      int
-	Exp      int
-	Verified bool
+	UserId    int
+	SessionId int
+	Iat       int
+	Exp       int
+	Verified  bool
+	Role      string
 }
 
 type JsonName struct {
@@ -121,6 +305,16 @@ type JsonNameDescr struct {
 	Descr string `json:"descr"`
 }
 
+type JsonKindNameDescr struct {
+	Kind  string `json:"kind"`
+	Name  string `json:"name"`
+	Descr string `json:"descr"`
+}
+
+type JsonRole struct {
+	Role string `json:"role"`
+}
+
 type JsonNicknameAboutAvatar struct {
 	Nickname string `json:"nickname"`
 	About    string `json:"about"`
@@ -139,6 +333,37 @@ type JsonEmailPassword struct {
 	Password string `json:"password"`
 }
 
+type JsonEmail struct {
+	Email string `json:"email"`
+}
+
+type JsonPasswordNewPassword struct {
+	Password    string `json:"password"`
+	NewPassword string `json:"new_password"`
+}
+
+type JsonLanguage struct {
+	Language string `json:"language"`
+}
+
+type JsonCode struct {
+	Code string `json:"code"`
+}
+
+type JsonMfaTokenCode struct {
+	Mfa_token string `json:"mfa_token"`
+	Code      string `json:"code"`
+}
+
+type JsonRefreshToken struct {
+	Refresh_token string `json:"refresh_token"`
+}
+
+type JsonTokenPassword struct {
+	Token    string `json:"token"`
+	Password string `json:"password"`
+}
+
 type JsonNicknameEmailPassword struct {
 	Nickname string `json:"nickname"`
 	Email    string `json:"email"`
@@ -153,6 +378,10 @@ func IsIdValid(id int) bool {
 	return id > 0
 }
 
+func IsRoleValid(role string) bool {
+	return role == RoleUser || role == RoleModerator || role == RoleAdmin
+}
+
 func ValidateString(str string, maxLen int) (string, bool) {
 	str = strings.TrimSpace(str)
 	if len(str) == 0 || len(str) > maxLen {
@@ -178,3 +407,58 @@ func RandomString(n int) string {
 	}
 	return string(b)
 }
+
+// EncodeCursor creates an opaque cursor from a sort key and an id of the last element on a page
+func EncodeCursor(key string, id int) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(key + "|" + strconv.Itoa(id)))
+}
+
+// DecodeCursor extracts a sort key and an id from a cursor created by EncodeCursor
+func DecodeCursor(cursor string) (string, int, bool) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return "", 0, false
+	}
+
+	pos := strings.LastIndex(string(data), "|")
+	if pos <= 0 {
+		return "", 0, false
+	}
+
+	id, err := strconv.Atoi(string(data[pos+1:]))
+	if err != nil || !IsIdValid(id) {
+		return "", 0, false
+	}
+
+	return string(data[:pos]), id, true
+}
+
+// TimeKeyLayout is how Postgres prints a timestamp, which is a sort key of an element in a cursor
+const TimeKeyLayout = "2006-01-02 15:04:05.999999"
+
+// IsTimeKeyValid checks that a sort key is a timestamp printed by Postgres
+func IsTimeKeyValid(key string) bool {
+	_, err := time.Parse(TimeKeyLayout, key)
+	return err == nil
+}
+
+// DecodePage validates a page size and extracts a sort key and an id of the last element of the
+// previous page from a cursor. An empty cursor means the first page, which starts from firstKey
+func DecodePage(cursor string, limit int, firstKey string, isKeyValid func(string) bool) (string, int, int) {
+	if limit <= 0 || limit > MaxPageSize {
+		log.Println("Page size is wrong", limit)
+		return "", 0, WrongPageSize
+	}
+
+	if cursor == "" {
+		return firstKey, 0, NothingToReport
+	}
+
+	key, id, ok := DecodeCursor(cursor)
+	if !ok || !isKeyValid(key) {
+		log.Println("Cursor is wrong", cursor)
+		return "", 0, WrongCursor
+	}
+
+	return key, id, NothingToReport
+}
@@ -18,6 +18,20 @@ func TestIsIdValid(t *testing.T) {
 	}
 }
 
+func TestIsRoleValid(t *testing.T) {
+	for _, v := range []string{"user", "moderator", "admin"} {
+		if !IsRoleValid(v) {
+			t.Errorf("Role %v should be valid", v)
+		}
+	}
+
+	for _, v := range []string{"", "Admin", "root", " user"} {
+		if IsRoleValid(v) {
+			t.Errorf("Role %v should be invalid", v)
+		}
+	}
+}
+
 func TestIsPasswordValid(t *testing.T) {
 	for _, v := range []string{"password", "a123fsdf3", "  sadf3fs", "13ds45sdfdfadf"} {
 		if !IsPasswordValid(v) {
@@ -85,3 +99,51 @@ func TestRandomString(t *testing.T) {
 		}
 	}
 }
+
+func TestCursor(t *testing.T) {
+	table := []struct {
+		key string
+		id  int
+	}{
+		{"2016-07-08 05:07:19.123456", 1},
+		{"2016-07-08 05:07:19", 94},
+		{"1.4668332110000001e+04", 3},
+		{"with|separator", 12},
+	}
+	for _, v := range table {
+		key, id, ok := DecodeCursor(EncodeCursor(v.key, v.id))
+		if !ok || key != v.key || id != v.id {
+			t.Errorf("Expected %v, %v. Got %v, %v, %v", v.key, v.id, key, id, ok)
+		}
+	}
+
+	for _, v := range []string{"", "not base64!", "c29tZXRoaW5n", "fDQ", "a2V5fDA", "a2V5fC0z", "a2V5fGFi"} {
+		if _, _, ok := DecodeCursor(v); ok {
+			t.Errorf("Cursor %v should be invalid", v)
+		}
+	}
+}
+
+func TestDecodePage(t *testing.T) {
+	table := []struct {
+		cursor string
+		limit  int
+		key    string
+		id     int
+		code   int
+	}{
+		{"", 20, "infinity", 0, NothingToReport},
+		{EncodeCursor("2016-07-08 05:07:19.123456", 4), 1, "2016-07-08 05:07:19.123456", 4, NothingToReport},
+		{EncodeCursor("2016-07-08 05:07:19", 4), MaxPageSize, "2016-07-08 05:07:19", 4, NothingToReport},
+		{"", 0, "", 0, WrongPageSize},
+		{"", MaxPageSize + 1, "", 0, WrongPageSize},
+		{"wrong", 20, "", 0, WrongCursor},
+		{EncodeCursor("not a time", 4), 20, "", 0, WrongCursor},
+	}
+	for num, v := range table {
+		key, id, code := DecodePage(v.cursor, v.limit, "infinity", IsTimeKeyValid)
+		if key != v.key || id != v.id || code != v.code {
+			t.Errorf("Case %v. Expected %v, %v, %v. Got %v, %v, %v", num, v.key, v.id, v.code, key, id, code)
+		}
+	}
+}
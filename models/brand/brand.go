@@ -4,6 +4,7 @@ import (
 	"../../misc"
 	"../../psql"
 	"database/sql"
+	"errors"
 	"log"
 	"time"
 )
@@ -114,3 +115,27 @@ func Update(brandId int, name string) int {
 	err, code := psql.IsAffectedOneRow(sqlResult)
 	return code
 }
+
+// ValidateBrand makes sure that the brandId exists in the database
+func ValidateBrand(brandId int) (error, int) {
+	if !misc.IsIdValid(brandId) {
+		return errors.New("brand is not positive"), misc.NoElement
+	}
+
+	exists := false
+	if err := psql.Db.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1
+			FROM brands
+			WHERE id = $1
+		)`, brandId,
+	).Scan(&exists); err != nil {
+		return err, misc.DbError
+	}
+
+	if !exists {
+		return errors.New("brand is missing"), misc.NoElement
+	}
+
+	return nil, misc.NothingToReport
+}
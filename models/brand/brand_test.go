@@ -4,6 +4,7 @@ import (
 	"../../misc"
 	"../../psql"
 	o "../testHelpers"
+	"database/sql"
 	"io/ioutil"
 	"log"
 	"os"
@@ -153,3 +154,28 @@ func TestUpdateBrand(t *testing.T) {
 		}
 	}
 }
+
+func TestValidateBrand(t *testing.T) {
+	o.CleanUpDb()
+
+	for _, v := range []int{1, 2, 3, 4, 5} {
+		if err, code := ValidateBrand(v); err != nil || code != misc.NothingToReport {
+			t.Errorf("Brand %v. Expect to be valid. Got %v, %v", v, err, code)
+		}
+	}
+
+	for _, v := range []int{0, -1, 6, 43} {
+		if err, code := ValidateBrand(v); err == nil || code != misc.NoElement {
+			t.Errorf("Brand %v. Expect %v. Got %v", v, misc.NoElement, code)
+		}
+	}
+
+	// a failure of the database is not hidden from a client
+	db := psql.Db
+	defer func() { psql.Db = db }()
+	psql.Db, _ = sql.Open("postgres", "")
+	psql.Db.Close()
+	if err, code := ValidateBrand(1); err == nil || code != misc.DbError {
+		t.Errorf("Expect %v. Got %v, %v", misc.DbError, err, code)
+	}
+}
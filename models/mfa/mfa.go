@@ -0,0 +1,277 @@
+// Package mfa is a second factor of a login. A user who enabled it proves having an authenticator app
+// (TOTP) or one of the recovery codes after entering a correct password. Login gives such user only a
+// short-lived challenge token, which is exchanged with a code for a session
+package mfa
+
+import (
+	"../../auth"
+	"../../misc"
+	"../../psql"
+	"../session"
+	"database/sql"
+	"errors"
+	"log"
+	"strings"
+	"time"
+)
+
+// Setup creates a new secret for a user who has not enabled 2FA yet. Nothing changes for logins until
+// the secret is confirmed with a code from an app
+func Setup(userId int) (misc.TotpSetup, int) {
+	secret, err := auth.GenerateTotpSecret()
+	if err != nil {
+		log.Println(err)
+		return misc.TotpSetup{}, misc.NoSalt
+	}
+
+	email := ""
+	if err := psql.Db.QueryRow(`
+		UPDATE users
+		SET totp_secret = $1, totp_last_counter = 0
+		WHERE id = $2 AND totp_enabled = False
+		RETURNING email`, secret, userId,
+	).Scan(&email); err != nil {
+		log.Println(err)
+		if err == sql.ErrNoRows {
+			return misc.TotpSetup{}, misc.MfaEnabled
+		}
+		return misc.TotpSetup{}, misc.NothingToReport
+	}
+
+	return misc.TotpSetup{secret, auth.TotpUri(email, secret)}, misc.NothingToReport
+}
+
+// Confirm enables 2FA if a code from an app matches the secret from Setup. Returns recovery codes,
+// which are never shown again
+func Confirm(userId int, code string) (misc.RecoveryCodes, int) {
+	codes := []string{}
+	if err, errCode := psql.Transaction(func(tx *sql.Tx) (error, int) {
+		codes = codes[:0]
+		secret, enabled := "", false
+		if err := tx.QueryRow(`
+			SELECT totp_secret, totp_enabled
+			FROM users
+			WHERE id = $1
+			FOR UPDATE`, userId,
+		).Scan(&secret, &enabled); err != nil {
+			log.Println(err)
+			return err, misc.NothingToReport
+		}
+
+		if enabled {
+			return errors.New("2FA is already enabled"), misc.MfaEnabled
+		}
+
+		if secret == "" {
+			return errors.New("2FA was not set up"), misc.MfaNotSetUp
+		}
+
+		counter, ok := auth.ValidateTotp(secret, strings.TrimSpace(code), time.Now(), 0)
+		if !ok {
+			return errors.New("Wrong code"), misc.WrongMfaCode
+		}
+
+		if _, err := tx.Exec(`
+			UPDATE users
+			SET totp_enabled = True, totp_last_counter = $1
+			WHERE id = $2`, counter, userId,
+		); err != nil {
+			log.Println(err)
+			return err, misc.NothingToReport
+		}
+
+		if _, err := tx.Exec(`
+			DELETE FROM recovery_codes
+			WHERE user_id = $1`, userId,
+		); err != nil {
+			log.Println(err)
+			return err, misc.NothingToReport
+		}
+
+		for i := 0; i < misc.RecoveryCodeNum; i++ {
+			recoveryCode, hash, err := auth.GenerateRecoveryCode()
+			if err != nil {
+				log.Println(err)
+				return err, misc.NoSalt
+			}
+
+			if _, err := tx.Exec(`
+				INSERT INTO recovery_codes (user_id, code_hash)
+				VALUES ($1, $2)`, userId, hash,
+			); err != nil {
+				log.Println(err)
+				return err, misc.NothingToReport
+			}
+			codes = append(codes, recoveryCode)
+		}
+
+		return nil, misc.NothingToReport
+	}); err != nil {
+		return misc.RecoveryCodes{}, errCode
+	}
+
+	return misc.RecoveryCodes{codes}, misc.NothingToReport
+}
+
+// checkCode validates a code from an app or a recovery code of a user with enabled 2FA and marks it
+// as used, so it can't be used again
+func checkCode(tx *sql.Tx, userId int, code string) (error, int) {
+	secret, enabled, lastCounter := "", false, int64(0)
+	if err := tx.QueryRow(`
+		SELECT totp_secret, totp_enabled, totp_last_counter
+		FROM users
+		WHERE id = $1
+		FOR UPDATE`, userId,
+	).Scan(&secret, &enabled, &lastCounter); err != nil {
+		log.Println(err)
+		return err, misc.NothingToReport
+	}
+
+	if !enabled {
+		return errors.New("2FA is not enabled"), misc.MfaNotSetUp
+	}
+
+	code = strings.TrimSpace(code)
+	if counter, ok := auth.ValidateTotp(secret, code, time.Now(), lastCounter); ok {
+		if _, err := tx.Exec(`
+			UPDATE users
+			SET totp_last_counter = $1
+			WHERE id = $2`, counter, userId,
+		); err != nil {
+			log.Println(err)
+			return err, misc.NothingToReport
+		}
+		return nil, misc.NothingToReport
+	}
+
+	// recovery codes are shown in lower case, but users can type them as they like
+	sqlResult, err := tx.Exec(`
+		UPDATE recovery_codes
+		SET used_at = (now() at time zone 'utc')
+		WHERE user_id = $1 AND code_hash = $2 AND used_at IS NULL`, userId, auth.HashToken(strings.ToLower(code)))
+	if err != nil {
+		log.Println(err)
+		return err, misc.NothingToReport
+	}
+
+	if err, _ := psql.IsAffectedOneRow(sqlResult); err != nil {
+		return errors.New("Wrong code"), misc.WrongMfaCode
+	}
+
+	return nil, misc.NothingToReport
+}
+
+// Disable turns 2FA off. A user proves having an app or a recovery code, so a stolen session is not
+// enough to remove the second factor
+func Disable(userId int, code string) int {
+	_, errCode := psql.Transaction(func(tx *sql.Tx) (error, int) {
+		if err, code := checkCode(tx, userId, code); err != nil {
+			return err, code
+		}
+
+		if _, err := tx.Exec(`
+			UPDATE users
+			SET totp_enabled = False, totp_secret = '', totp_last_counter = 0
+			WHERE id = $1`, userId,
+		); err != nil {
+			log.Println(err)
+			return err, misc.NothingToReport
+		}
+
+		if _, err := tx.Exec(`
+			DELETE FROM recovery_codes
+			WHERE user_id = $1`, userId,
+		); err != nil {
+			log.Println(err)
+			return err, misc.NothingToReport
+		}
+
+		return nil, misc.NothingToReport
+	})
+
+	return errCode
+}
+
+// CreateChallenge is called after a correct password of a user with enabled 2FA. Returns a token for
+// the second step of the login
+func CreateChallenge(userId int) (string, int) {
+	token, hash, err := auth.GenerateToken()
+	if err != nil {
+		log.Println(err)
+		return "", misc.NoSalt
+	}
+
+	if _, err := psql.Db.Exec(`
+		INSERT INTO mfa_challenges (user_id, token_hash, expires_at)
+		VALUES ($1, $2, (now() at time zone 'utc') + $3 * interval '1 minute')`, userId, hash, misc.MfaTokenTtl,
+	); err != nil {
+		err, code := psql.CheckSpecificDriverErrors(err)
+		log.Println(err)
+		return "", code
+	}
+
+	return token, misc.NothingToReport
+}
+
+// Exchange finishes a login with 2FA. A token from CreateChallenge together with a correct code opens
+// a new session. Every wrong code is counted, so a token can't be used to guess codes. The email of the
+// user is returned after a correct or a wrong code, so failed logins of the account can be tracked
+func Exchange(mfaToken, code string, client misc.Client) (misc.Tokens, string, int) {
+	userId, email, verified := 0, "", false
+	err, errCode := psql.Transaction(func(tx *sql.Tx) (error, int) {
+		challengeId := 0
+		if err := tx.QueryRow(`
+			SELECT c.id, c.user_id, u.email, u.verified
+			FROM mfa_challenges c
+			JOIN users u ON u.id = c.user_id
+			WHERE c.token_hash = $1 AND c.used_at IS NULL AND c.expires_at > (now() at time zone 'utc')
+				AND c.attempts < $2
+			FOR UPDATE OF c`, auth.HashToken(mfaToken), misc.MfaMaxAttempts,
+		).Scan(&challengeId, &userId, &email, &verified); err != nil {
+			log.Println(err)
+			if err == sql.ErrNoRows {
+				return err, misc.WrongMfaToken
+			}
+			return err, misc.NothingToReport
+		}
+
+		if err, code := checkCode(tx, userId, code); err != nil {
+			if code != misc.WrongMfaCode {
+				return err, code
+			}
+
+			if _, err := tx.Exec(`
+				UPDATE mfa_challenges
+				SET attempts = attempts + 1
+				WHERE id = $1`, challengeId,
+			); err != nil {
+				log.Println(err)
+				return err, misc.NothingToReport
+			}
+
+			// commit the attempt, but do not open a session
+			return nil, misc.WrongMfaCode
+		}
+
+		sqlResult, err := tx.Exec(`
+			UPDATE mfa_challenges
+			SET used_at = (now() at time zone 'utc')
+			WHERE id = $1`, challengeId)
+		if err != nil {
+			log.Println(err)
+			return err, misc.NothingToReport
+		}
+
+		return psql.IsAffectedOneRow(sqlResult)
+	})
+	if errCode == misc.WrongMfaCode {
+		return misc.Tokens{}, email, errCode
+	}
+
+	if err != nil || errCode != misc.NothingToReport {
+		return misc.Tokens{}, "", errCode
+	}
+
+	tokens, errCode := session.Create(userId, verified, client)
+	return tokens, email, errCode
+}
@@ -0,0 +1,177 @@
+package mfa
+
+import (
+	"../../auth"
+	"../../misc"
+	"../../psql"
+	o "../testHelpers"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Setup and db.close will be called before and after each test http://stackoverflow.com/a/34102842/1090562
+func TestMain(m *testing.M) {
+	o.InitAll()
+	log.SetOutput(ioutil.Discard)
+	retCode := m.Run()
+
+	defer psql.Db.Close()
+	o.CleanUpDb()
+	os.Exit(retCode)
+}
+
+// enable turns 2FA of a user on and returns the secret and recovery codes
+func enable(t *testing.T, userId int) (string, []string) {
+	setup, code := Setup(userId)
+	if code != misc.NothingToReport {
+		t.Fatalf("Expect to set up 2FA. Got %v", code)
+	}
+
+	codes, code := Confirm(userId, auth.TotpCode(setup.Secret, time.Now()))
+	if code != misc.NothingToReport {
+		t.Fatalf("Expect to enable 2FA. Got %v", code)
+	}
+	return setup.Secret, codes.Codes
+}
+
+func TestSetup(t *testing.T) {
+	o.CleanUpDb()
+
+	setup, code := Setup(1)
+	if code != misc.NothingToReport || setup.Secret == "" || !strings.Contains(setup.Uri, setup.Secret) {
+		t.Fatalf("Expect a new secret. Got %v, %v", setup, code)
+	}
+
+	// until a code is confirmed a user can start over
+	other, code := Setup(1)
+	if code != misc.NothingToReport || other.Secret == setup.Secret {
+		t.Errorf("Expect another secret. Got %v, %v", other, code)
+	}
+
+	table := []struct {
+		userId int
+		code   string
+		res    int
+	}{
+		{1, auth.TotpCode(setup.Secret, time.Now()), misc.WrongMfaCode},
+		{1, "123", misc.WrongMfaCode},
+		{2, "123456", misc.MfaNotSetUp},
+	}
+	for num, v := range table {
+		if codes, code := Confirm(v.userId, v.code); code != v.res || len(codes.Codes) != 0 {
+			t.Errorf("Case %v. Expect %v. Got %v, %v", num, v.res, codes, code)
+		}
+	}
+
+	codes, code := Confirm(1, " "+auth.TotpCode(other.Secret, time.Now())+" ")
+	if code != misc.NothingToReport || len(codes.Codes) != misc.RecoveryCodeNum {
+		t.Fatalf("Expect recovery codes. Got %v, %v", codes, code)
+	}
+
+	if _, code := Setup(1); code != misc.MfaEnabled {
+		t.Errorf("Expect not to replace a secret of enabled 2FA. Got %v", code)
+	}
+
+	if _, code := Confirm(1, auth.TotpCode(other.Secret, time.Now())); code != misc.MfaEnabled {
+		t.Errorf("Expect not to confirm 2FA twice. Got %v", code)
+	}
+}
+
+func TestExchange(t *testing.T) {
+	o.CleanUpDb()
+
+	secret, recoveryCodes := enable(t, 1)
+	client := misc.Client{"test", "127.0.0.1"}
+
+	mfaToken, code := CreateChallenge(1)
+	if code != misc.NothingToReport || len(mfaToken) < 40 {
+		t.Fatalf("Expect a token. Got %v, %v", mfaToken, code)
+	}
+
+	// the code which enabled 2FA can't be used again
+	table := []struct {
+		mfaToken string
+		code     string
+		res      int
+		isKnown  bool // the email of the user is returned
+	}{
+		{mfaToken, auth.TotpCode(secret, time.Now().Add(-time.Minute)), misc.WrongMfaCode, true},
+		{mfaToken, "abcdefghijklmnop", misc.WrongMfaCode, true},
+		{mfaToken + "a", recoveryCodes[0], misc.WrongMfaToken, false},
+		{"", recoveryCodes[0], misc.WrongMfaToken, false},
+	}
+	for num, v := range table {
+		if tokens, email, code := Exchange(v.mfaToken, v.code, client); code != v.res || tokens.Jwt != "" || (email != "") != v.isKnown {
+			t.Errorf("Case %v. Expect %v. Got %v, %v, %v", num, v.res, tokens, email, code)
+		}
+	}
+
+	tokens, email, code := Exchange(mfaToken, strings.ToUpper(recoveryCodes[0]), client)
+	if code != misc.NothingToReport || tokens.Jwt == "" || tokens.Refresh_token == "" || email == "" {
+		t.Fatalf("Expect a session. Got %v, %v, %v", tokens, email, code)
+	}
+
+	if token, err := auth.ValidateJWT(tokens.Jwt); err != nil || token.UserId != 1 {
+		t.Errorf("Expect a token of the user. Got %v, %v", token, err)
+	}
+
+	if _, _, code := Exchange(mfaToken, recoveryCodes[1], client); code != misc.WrongMfaToken {
+		t.Errorf("Expect a token to be used once. Got %v", code)
+	}
+
+	// a recovery code is used once, a code from an app opens a session
+	mfaToken, _ = CreateChallenge(1)
+	if _, _, code := Exchange(mfaToken, recoveryCodes[0], client); code != misc.WrongMfaCode {
+		t.Errorf("Expect a recovery code to be used once. Got %v", code)
+	}
+
+	if tokens, _, code := Exchange(mfaToken, auth.TotpCode(secret, time.Now().Add(30*time.Second)), client); code != misc.NothingToReport || tokens.Jwt == "" {
+		t.Errorf("Expect a session with a code from an app. Got %v, %v", tokens, code)
+	}
+
+	// a token stops working after too many wrong codes
+	mfaToken, _ = CreateChallenge(1)
+	for i := 0; i < misc.MfaMaxAttempts; i++ {
+		Exchange(mfaToken, "000000", client)
+	}
+
+	if _, _, code := Exchange(mfaToken, recoveryCodes[2], client); code != misc.WrongMfaToken {
+		t.Errorf("Expect a token to stop working. Got %v", code)
+	}
+
+	if _, code := CreateChallenge(100); code != misc.DbForeignKeyViolation {
+		t.Errorf("Expect no token for a missing user. Got %v", code)
+	}
+}
+
+func TestDisable(t *testing.T) {
+	o.CleanUpDb()
+
+	_, recoveryCodes := enable(t, 1)
+
+	table := []struct {
+		userId int
+		code   string
+		res    int
+	}{
+		{1, "000000", misc.WrongMfaCode},
+		{1, "", misc.WrongMfaCode},
+		{2, recoveryCodes[0], misc.MfaNotSetUp},
+		{1, recoveryCodes[0], misc.NothingToReport},
+		{1, recoveryCodes[1], misc.MfaNotSetUp},
+	}
+	for num, v := range table {
+		if code := Disable(v.userId, v.code); code != v.res {
+			t.Errorf("Case %v. Expect %v. Got %v", num, v.res, code)
+		}
+	}
+
+	// 2FA can be set up again
+	if _, codes := enable(t, 1); codes[0] == recoveryCodes[0] {
+		t.Error("Expect new recovery codes")
+	}
+}
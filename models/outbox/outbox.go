@@ -0,0 +1,93 @@
+// Package outbox lets admins see emails which could not be delivered and send them again. Emails
+// are put in the outbox and delivered by the mailer
+package outbox
+
+import (
+	"../../misc"
+	"../../psql"
+	"log"
+	"time"
+)
+
+// ShowFailed returns a page of dead emails and pending emails which have failed at least once. The
+// latest are the first. Emails are sorted by (created_at, id), so the pages are stable while new
+// emails fail
+func ShowFailed(cursor string, limit int) (misc.EmailPage, int) {
+	// the first page starts from infinity, every email was created before it
+	createdKey, lastId, code := misc.DecodePage(cursor, limit, "infinity", misc.IsTimeKeyValid)
+	if code != misc.NothingToReport {
+		return misc.EmailPage{Emails: []*misc.Email{}}, code
+	}
+
+	rows, err := psql.Db.Query(`
+		SELECT id, recipient, subject, status, attempts, last_error, created_at, secret_expires_at, created_at::text
+		FROM email_outbox
+		WHERE (status = $1 OR attempts > 0) AND (created_at, id) < ($2::timestamp, $3)
+		ORDER BY created_at DESC, id DESC
+		LIMIT $4`, misc.EmailDead, createdKey, lastId, limit+1)
+	if err != nil {
+		log.Println(err)
+		return misc.EmailPage{Emails: []*misc.Email{}}, misc.NothingToReport
+	}
+	defer rows.Close()
+
+	emails, keys := []*misc.Email{}, []string{}
+	for rows.Next() {
+		e, key := misc.Email{}, ""
+		var createdAt time.Time
+		var secretExpiresAt *time.Time
+		if err := rows.Scan(&e.Id, &e.Recipient, &e.Subject, &e.Status, &e.Attempts, &e.Last_error, &createdAt, &secretExpiresAt, &key); err != nil {
+			log.Println(err)
+			return misc.EmailPage{Emails: []*misc.Email{}}, misc.NothingToReport
+		}
+
+		e.Created_at = createdAt.Unix()
+		if secretExpiresAt != nil {
+			e.Secret_expires_at = secretExpiresAt.Unix()
+		}
+		emails, keys = append(emails, &e), append(keys, key)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Println(err)
+		return misc.EmailPage{Emails: []*misc.Email{}}, misc.NothingToReport
+	}
+
+	if len(emails) <= limit {
+		return misc.EmailPage{Emails: emails}, misc.NothingToReport
+	}
+
+	return misc.EmailPage{
+		Emails:      emails[:limit],
+		Next_cursor: misc.EncodeCursor(keys[limit-1], emails[limit-1].Id),
+	}, misc.NothingToReport
+}
+
+// Retry gives a dead email a new set of attempts. It is delivered by the next free worker. An email
+// with an expired code or token has no texts anymore, a user has to ask for a new code instead
+func Retry(emailId int) int {
+	if !misc.IsIdValid(emailId) {
+		log.Println("Email id is not correct", emailId)
+		return misc.NoEmail
+	}
+
+	sqlResult, err := psql.Db.Exec(`
+		UPDATE email_outbox
+		SET status = $1, attempts = 0, next_attempt_at = (now() at time zone 'utc')
+		WHERE id = $2 AND status = $3
+			AND (secret_expires_at IS NULL OR secret_expires_at > (now() at time zone 'utc'))`,
+		misc.EmailPending, emailId, misc.EmailDead)
+	if err != nil {
+		log.Println(err)
+		return misc.NothingToReport
+	}
+
+	if err, code := psql.IsAffectedOneRow(sqlResult); err != nil {
+		if code == misc.NothingUpdated {
+			return misc.NoEmail
+		}
+		return code
+	}
+
+	return misc.NothingToReport
+}
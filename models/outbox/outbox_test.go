@@ -0,0 +1,215 @@
+package outbox
+
+import (
+	"../../config"
+	"../../mailer"
+	"../../misc"
+	"../../psql"
+	o "../testHelpers"
+	"errors"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Setup and db.close will be called before and after each test http://stackoverflow.com/a/34102842/1090562
+func TestMain(m *testing.M) {
+	o.InitAll()
+	log.SetOutput(ioutil.Discard)
+	retCode := m.Run()
+
+	defer psql.Db.Close()
+	o.CleanUpDb()
+	os.Exit(retCode)
+}
+
+// brokenTransport fails to send anything, like a mail service which is down
+type brokenTransport struct{}
+
+func (brokenTransport) Send(m mailer.Message) error {
+	return errors.New("service is down")
+}
+
+func TestDeliveryAndRetry(t *testing.T) {
+	o.CleanUpDb()
+
+	memory, attempts := mailer.Sender, config.Cfg.MailAttempts
+	defer func() { mailer.Sender, config.Cfg.MailAttempts = memory, attempts }()
+	mailer.Sender, config.Cfg.MailAttempts = brokenTransport{}, 2
+
+	if err := mailer.AccountLocked(psql.Db, "outbox@gmail.com", mailer.DefaultLanguage, 15); err != nil {
+		t.Fatal(err)
+	}
+
+	// a failed email waits before the next attempt
+	if n := mailer.Deliver(psql.Db); n != 1 {
+		t.Errorf("Expect one attempt. Got %v", n)
+	}
+
+	if n := mailer.Deliver(psql.Db); n != 0 {
+		t.Errorf("Expect no attempts before the backoff ends. Got %v", n)
+	}
+
+	page, _ := ShowFailed("", misc.PageSize)
+	emails := page.Emails
+	if len(emails) != 1 || emails[0].Status != misc.EmailPending || emails[0].Attempts != 1 || emails[0].Last_error != "service is down" {
+		t.Fatalf("Expect a failed pending email. Got %v", emails)
+	}
+	emailId := emails[0].Id
+
+	if code := Retry(emailId); code != misc.NoEmail {
+		t.Errorf("Expect to retry only dead emails. Got %v", code)
+	}
+
+	// after PROJ_MAIL_MAX_ATTEMPTS failures an email is dead
+	psql.Db.Exec(`UPDATE email_outbox SET next_attempt_at = (now() at time zone 'utc')`)
+	mailer.Deliver(psql.Db)
+	psql.Db.Exec(`UPDATE email_outbox SET next_attempt_at = (now() at time zone 'utc')`)
+	if n := mailer.Deliver(psql.Db); n != 0 {
+		t.Errorf("Expect dead emails not to be delivered. Got %v", n)
+	}
+
+	if page, _ := ShowFailed("", misc.PageSize); len(page.Emails) != 1 || page.Emails[0].Status != misc.EmailDead || page.Emails[0].Attempts != 2 {
+		t.Fatalf("Expect a dead email. Got %v", page.Emails)
+	}
+
+	table := []struct {
+		emailId int
+		code    int
+	}{
+		{0, misc.NoEmail},
+		{100, misc.NoEmail},
+		{emailId, misc.NothingToReport},
+		{emailId, misc.NoEmail},
+	}
+	for num, v := range table {
+		if code := Retry(v.emailId); code != v.code {
+			t.Errorf("Case %v. Expect %v. Got %v", num, v.code, code)
+		}
+	}
+
+	// once the service is back, a retried email is delivered and leaves the outbox
+	mailer.Sender = memory
+	if n := mailer.Deliver(psql.Db); n != 1 {
+		t.Errorf("Expect a retried email to be delivered. Got %v", n)
+	}
+
+	if m, ok := o.LastEmail("outbox@gmail.com"); !ok || m.Subject == "" {
+		t.Errorf("Expect an email to arrive. Got %v, %v", m, ok)
+	}
+
+	if page, _ := ShowFailed("", misc.PageSize); len(page.Emails) != 0 {
+		t.Errorf("Expect no failed emails. Got %v", page.Emails)
+	}
+}
+
+func TestDeadEmailWithSecret(t *testing.T) {
+	o.CleanUpDb()
+
+	memory, attempts := mailer.Sender, config.Cfg.MailAttempts
+	defer func() { mailer.Sender, config.Cfg.MailAttempts = memory, attempts }()
+	mailer.Sender, config.Cfg.MailAttempts = brokenTransport{}, 1
+
+	if err := mailer.PasswordReset(psql.Db, "outbox@gmail.com", mailer.DefaultLanguage, "secret_token"); err != nil {
+		t.Fatal(err)
+	}
+	mailer.Deliver(psql.Db)
+
+	page, _ := ShowFailed("", misc.PageSize)
+	if len(page.Emails) != 1 || page.Emails[0].Status != misc.EmailDead || page.Emails[0].Secret_expires_at <= time.Now().Unix() {
+		t.Fatalf("Expect a dead email with a valid secret. Got %v", page.Emails)
+	}
+	emailId := page.Emails[0].Id
+
+	// while the token is valid, the email can be sent again
+	if code := Retry(emailId); code != misc.NothingToReport {
+		t.Errorf("Expect an email with a valid secret to be sent again. Got %v", code)
+	}
+
+	mailer.Sender = memory
+	if n := mailer.Deliver(psql.Db); n != 1 {
+		t.Errorf("Expect a retried email to be delivered. Got %v", n)
+	}
+
+	if m, ok := o.LastEmail("outbox@gmail.com"); !ok || !strings.Contains(m.Text, "secret_token") {
+		t.Errorf("Expect an email with the token to arrive. Got %v, %v", m, ok)
+	}
+
+	// texts of dead and pending emails are removed when their secret expires
+	mailer.Sender = brokenTransport{}
+	mailer.PasswordReset(psql.Db, "outbox@gmail.com", mailer.DefaultLanguage, "secret_token")
+	mailer.Deliver(psql.Db)
+	mailer.PasswordReset(psql.Db, "outbox@gmail.com", mailer.DefaultLanguage, "secret_token")
+	mailer.AccountLocked(psql.Db, "outbox@gmail.com", mailer.DefaultLanguage, 15)
+	psql.Db.Exec(`
+		UPDATE email_outbox
+		SET secret_expires_at = (now() at time zone 'utc') - interval '1 minute'
+		WHERE secret_expires_at IS NOT NULL`)
+	mailer.Sender = memory
+	if n := mailer.Deliver(psql.Db); n != 1 {
+		t.Errorf("Expect only an email without a secret to be delivered. Got %v", n)
+	}
+
+	texts, dead := "", 0
+	psql.Db.QueryRow(`SELECT string_agg(text_body || html_body, ''), count(*) FROM email_outbox WHERE status = $1`, misc.EmailDead).Scan(&texts, &dead)
+	if texts != "" || dead != 2 {
+		t.Errorf("Expect texts of emails with an expired secret to be removed. Got %v, %v", texts, dead)
+	}
+
+	page, _ = ShowFailed("", misc.PageSize)
+	for _, e := range page.Emails {
+		if code := Retry(e.Id); code != misc.NoEmail {
+			t.Errorf("Expect an email with an expired secret not to be sent again. Got %v", code)
+		}
+	}
+}
+
+func TestShowFailedPages(t *testing.T) {
+	o.CleanUpDb()
+
+	for i := 0; i < 5; i++ {
+		psql.Db.Exec(`
+			INSERT INTO email_outbox (sender, recipient, subject, text_body, html_body, status, attempts)
+			VALUES ('from@unnamed.com', 'to@gmail.com', $1, '', '', $2, 1)`, strconv.Itoa(i), misc.EmailDead)
+	}
+
+	// a pending email which has never failed is not shown
+	mailer.AccountLocked(psql.Db, "outbox@gmail.com", mailer.DefaultLanguage, 15)
+
+	subjects, cursor := []string{}, ""
+	for pages := 0; pages == 0 || cursor != ""; pages++ {
+		page, code := ShowFailed(cursor, 2)
+		if code != misc.NothingToReport || len(page.Emails) > 2 || pages > 3 {
+			t.Fatalf("Expect pages of at most 2 emails. Got %v, %v", page, code)
+		}
+
+		for _, e := range page.Emails {
+			subjects = append(subjects, e.Subject)
+		}
+		cursor = page.Next_cursor
+	}
+
+	if strings.Join(subjects, ",") != "4,3,2,1,0" {
+		t.Errorf("Expect all failed emails from the latest one. Got %v", subjects)
+	}
+
+	table := []struct {
+		cursor string
+		limit  int
+		code   int
+	}{
+		{"", 0, misc.WrongPageSize},
+		{"", misc.MaxPageSize + 1, misc.WrongPageSize},
+		{"wrong", 2, misc.WrongCursor},
+		{misc.EncodeCursor("not a time", 1), 2, misc.WrongCursor},
+	}
+	for num, v := range table {
+		if page, code := ShowFailed(v.cursor, v.limit); code != v.code || len(page.Emails) != 0 {
+			t.Errorf("Case %v. Expect %v. Got %v, %v", num, v.code, page, code)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+// Package proposal is a review queue of brands and tags. Regular users can't change the catalog, so
+// they propose new brands and tags and moderators approve or reject them
+package proposal
+
+import (
+	"../../misc"
+	"../../psql"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+)
+
+// showProposals returns proposals selected by a condition in a specific order
+func showProposals(condition, order string, args ...interface{}) ([]*misc.Proposal, int) {
+	rows, err := psql.Db.Query(`
+		SELECT id, kind, name, description, user_id, status, issued_at, reviewed_at, created_id
+		FROM proposals
+		WHERE `+condition+`
+		ORDER BY `+order, args...)
+	if err != nil {
+		log.Println(err)
+		return []*misc.Proposal{}, misc.NothingToReport
+	}
+	defer rows.Close()
+
+	proposals := []*misc.Proposal{}
+	for rows.Next() {
+		p := misc.Proposal{}
+		var issuedAt time.Time
+		var reviewedAt *time.Time
+		var createdId sql.NullInt64
+		if err := rows.Scan(&p.Id, &p.Kind, &p.Name, &p.Description, &p.User_id, &p.Status, &issuedAt, &reviewedAt, &createdId); err != nil {
+			log.Println(err)
+			return []*misc.Proposal{}, misc.NothingToReport
+		}
+
+		p.Issued_at, p.Created_id = issuedAt.Unix(), int(createdId.Int64)
+		if reviewedAt != nil {
+			p.Reviewed_at = reviewedAt.Unix()
+		}
+		proposals = append(proposals, &p)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Println(err)
+		return []*misc.Proposal{}, misc.NothingToReport
+	}
+
+	return proposals, misc.NothingToReport
+}
+
+// ShowPending returns all proposals waiting for a review. The oldest are the first
+func ShowPending() ([]*misc.Proposal, int) {
+	return showProposals("status = $1", "id", misc.ProposalPending)
+}
+
+// ShowByUserId returns all proposals of a user. The latest are the first
+func ShowByUserId(userId int) ([]*misc.Proposal, int) {
+	return showProposals("user_id = $1", "id DESC", userId)
+}
+
+// Create adds a brand or a tag to the review queue. Brands have no description
+func Create(userId int, kind, name, descr string) (int, int) {
+	if kind != misc.ProposalBrand && kind != misc.ProposalTag {
+		log.Println("Wrong kind of a proposal", kind)
+		return 0, misc.WrongKind
+	}
+
+	name, ok := misc.ValidateString(name, misc.MaxLenS)
+	if !ok {
+		log.Println("Wrong name for a proposal", name)
+		return 0, misc.WrongName
+	}
+
+	if kind == misc.ProposalTag {
+		if descr, ok = misc.ValidateString(descr, misc.MaxLenB); !ok {
+			log.Println("Wrong descr for a proposal", descr)
+			return 0, misc.WrongDescr
+		}
+	} else {
+		descr = ""
+	}
+
+	proposalId := 0
+	err := psql.Db.QueryRow(`
+		INSERT INTO proposals (kind, name, description, user_id)
+		VALUES ($1, $2, $3, $4)
+		RETURNING id`, kind, name, descr, userId,
+	).Scan(&proposalId)
+	if err == nil {
+		return proposalId, misc.NothingToReport
+	}
+
+	err, code := psql.CheckSpecificDriverErrors(err)
+	log.Println(err)
+	return 0, code
+}
+
+// Approve creates a brand or a tag from a pending proposal and returns its id
+func Approve(proposalId, moderatorId int) (int, int) {
+	if !misc.IsIdValid(proposalId) {
+		log.Println("Proposal id is not correct", proposalId)
+		return 0, misc.NoProposal
+	}
+
+	createdId := 0
+	if err, code := psql.Transaction(func(tx *sql.Tx) (error, int) {
+		kind, name, descr := "", "", ""
+		if err := tx.QueryRow(`
+			SELECT kind, name, description
+			FROM proposals
+			WHERE id = $1 AND status = $2
+			FOR UPDATE`, proposalId, misc.ProposalPending,
+		).Scan(&kind, &name, &descr); err != nil {
+			log.Println(err)
+			if err == sql.ErrNoRows {
+				return err, misc.NoProposal
+			}
+			return err, misc.NothingToReport
+		}
+
+		var err error
+		switch kind {
+		case misc.ProposalBrand:
+			err = tx.QueryRow(`
+				INSERT INTO brands (name)
+				VALUES ($1)
+				RETURNING id`, name,
+			).Scan(&createdId)
+		case misc.ProposalTag:
+			err = tx.QueryRow(`
+				INSERT INTO tags (name, description)
+				VALUES ($1, $2)
+				RETURNING id`, name, descr,
+			).Scan(&createdId)
+		default:
+			return errors.New("Unknown kind of a proposal"), misc.WrongKind
+		}
+		if err, code := psql.CheckSpecificDriverErrors(err); err != nil {
+			log.Println(err)
+			return err, code
+		}
+
+		sqlResult, err := tx.Exec(`
+			UPDATE proposals
+			SET status = $1, reviewer_id = $2, reviewed_at = (now() at time zone 'utc'), created_id = $3
+			WHERE id = $4`, misc.ProposalApproved, moderatorId, createdId, proposalId)
+		if err, code := psql.CheckSpecificDriverErrors(err); err != nil {
+			log.Println(err)
+			return err, code
+		}
+
+		return psql.IsAffectedOneRow(sqlResult)
+	}); err != nil {
+		return 0, code
+	}
+
+	return createdId, misc.NothingToReport
+}
+
+// Reject removes a pending proposal from the review queue
+func Reject(proposalId, moderatorId int) int {
+	if !misc.IsIdValid(proposalId) {
+		log.Println("Proposal id is not correct", proposalId)
+		return misc.NoProposal
+	}
+
+	sqlResult, err := psql.Db.Exec(`
+		UPDATE proposals
+		SET status = $1, reviewer_id = $2, reviewed_at = (now() at time zone 'utc')
+		WHERE id = $3 AND status = $4`, misc.ProposalRejected, moderatorId, proposalId, misc.ProposalPending)
+	if err, code := psql.CheckSpecificDriverErrors(err); err != nil {
+		log.Println(err)
+		return code
+	}
+
+	if err, _ := psql.IsAffectedOneRow(sqlResult); err != nil {
+		return misc.NoProposal
+	}
+
+	return misc.NothingToReport
+}
@@ -0,0 +1,141 @@
+package proposal
+
+import (
+	"../../misc"
+	"../../psql"
+	"../brand"
+	"../tag"
+	o "../testHelpers"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+)
+
+// Setup and db.close will be called before and after each test http://stackoverflow.com/a/34102842/1090562
+func TestMain(m *testing.M) {
+	o.InitAll()
+	log.SetOutput(ioutil.Discard)
+	retCode := m.Run()
+
+	defer psql.Db.Close()
+	o.CleanUpDb()
+	os.Exit(retCode)
+}
+
+func TestCreate(t *testing.T) {
+	o.CleanUpDb()
+
+	table := []struct {
+		userId int
+		kind   string
+		name   string
+		descr  string
+		code   int
+	}{
+		{3, "shop", "Amazon", "", misc.WrongKind},
+		{3, misc.ProposalBrand, "", "", misc.WrongName},
+		{3, misc.ProposalBrand, o.RandomString(misc.MaxLenS, 1, 1), "", misc.WrongName},
+		{3, misc.ProposalTag, "watch", "", misc.WrongDescr},
+		{100, misc.ProposalBrand, "Tesla", "", misc.DbForeignKeyViolation},
+		{3, misc.ProposalBrand, "Tesla", "ignored description", misc.NothingToReport},
+		{4, misc.ProposalTag, "watch", "Things which show time", misc.NothingToReport},
+		{3, misc.ProposalBrand, "Apple", "", misc.NothingToReport},
+	}
+	for num, v := range table {
+		id, code := Create(v.userId, v.kind, v.name, v.descr)
+		if code != v.code || (code == misc.NothingToReport) != (id > 0) {
+			t.Errorf("Case %v. Expect %v. Got %v, %v", num, v.code, id, code)
+		}
+	}
+
+	proposals, _ := ShowPending()
+	if len(proposals) != 3 || proposals[0].Name != "Tesla" || proposals[0].Description != "" || proposals[1].Kind != misc.ProposalTag {
+		t.Errorf("Expect 3 pending proposals in order. Got %v", proposals)
+	}
+
+	if proposals, _ := ShowByUserId(3); len(proposals) != 2 || proposals[0].Name != "Apple" || proposals[0].Status != misc.ProposalPending {
+		t.Errorf("Expect 2 proposals of a user, the latest first. Got %v", proposals)
+	}
+}
+
+func TestApprove(t *testing.T) {
+	o.CleanUpDb()
+
+	brandId, _ := Create(3, misc.ProposalBrand, "Tesla", "")
+	tagId, _ := Create(4, misc.ProposalTag, "watch", "Things which show time")
+	duplicateId, _ := Create(3, misc.ProposalBrand, "Apple", "")
+
+	table := []struct {
+		proposalId int
+		code       int
+	}{
+		{0, misc.NoProposal},
+		{100, misc.NoProposal},
+		{duplicateId, misc.DbDuplicate},
+		{brandId, misc.NothingToReport},
+		{brandId, misc.NoProposal},
+		{tagId, misc.NothingToReport},
+	}
+	for num, v := range table {
+		id, code := Approve(v.proposalId, 2)
+		if code != v.code || (code == misc.NothingToReport) != (id > 0) {
+			t.Errorf("Case %v. Expect %v. Got %v, %v", num, v.code, id, code)
+		}
+	}
+
+	proposals, _ := ShowByUserId(3)
+	if len(proposals) != 2 || proposals[1].Status != misc.ProposalApproved || proposals[1].Reviewed_at == 0 {
+		t.Fatalf("Expect a proposal to be approved. Got %v", proposals)
+	}
+
+	if b, code := brand.ShowById(proposals[1].Created_id); code != misc.NothingToReport || b.Name != "Tesla" {
+		t.Errorf("Expect a brand to be created. Got %v, %v", b, code)
+	}
+
+	// a failed approval does not change anything
+	if proposals[0].Status != misc.ProposalPending || proposals[0].Created_id != 0 {
+		t.Errorf("Expect a duplicate to stay pending. Got %v", proposals[0])
+	}
+
+	proposals, _ = ShowByUserId(4)
+	if tg, code := tag.ShowById(proposals[0].Created_id); code != misc.NothingToReport || tg.Name != "watch" || tg.Description != "Things which show time" {
+		t.Errorf("Expect a tag to be created. Got %v, %v", tg, code)
+	}
+
+	if proposals, _ := ShowPending(); len(proposals) != 1 || proposals[0].Id != duplicateId {
+		t.Errorf("Expect only a duplicate to be pending. Got %v", proposals)
+	}
+}
+
+func TestReject(t *testing.T) {
+	o.CleanUpDb()
+
+	proposalId, _ := Create(3, misc.ProposalBrand, "Tesla", "")
+	approvedId, _ := Create(3, misc.ProposalBrand, "Nike", "")
+	Approve(approvedId, 2)
+
+	table := []struct {
+		proposalId int
+		code       int
+	}{
+		{0, misc.NoProposal},
+		{100, misc.NoProposal},
+		{approvedId, misc.NoProposal},
+		{proposalId, misc.NothingToReport},
+		{proposalId, misc.NoProposal},
+	}
+	for num, v := range table {
+		if code := Reject(v.proposalId, 2); code != v.code {
+			t.Errorf("Case %v. Expect %v. Got %v", num, v.code, code)
+		}
+	}
+
+	if _, code := Approve(proposalId, 2); code != misc.NoProposal {
+		t.Errorf("Expect a rejected proposal not to be approved. Got %v", code)
+	}
+
+	if proposals, _ := ShowPending(); len(proposals) != 0 {
+		t.Errorf("Expect no pending proposals. Got %v", proposals)
+	}
+}
@@ -5,48 +5,95 @@ import (
 	"../../misc"
 	"../../psql"
 	"../tag"
+	"../upload"
 	"database/sql"
+	"errors"
+	"fmt"
 	"log"
+	"regexp"
 	"strconv"
 	"strings"
 	"time"
 )
 
-func getPurchases(rows *sql.Rows, err error) ([]*misc.Purchase, int) {
+// getPage converts rows into a page of purchases. Every row ends with a sort key of the purchase,
+// which is used to create a cursor. One extra row is expected to find out whether a next page exists
+func getPage(rows *sql.Rows, err error, limit int) (misc.PurchasePage, int) {
 	if err != nil {
 		log.Println(err)
-		return []*misc.Purchase{}, misc.NothingToReport
+		return misc.PurchasePage{Purchases: []*misc.Purchase{}}, misc.NothingToReport
 	}
 	defer rows.Close()
 
-	purchases, tagString := []*misc.Purchase{}, ""
+	purchases, tagString, keys := []*misc.Purchase{}, "", []string{}
 	var timestamp time.Time
 	for rows.Next() {
-		p := misc.Purchase{}
-		if err := rows.Scan(&p.Id, &p.Image, &p.Description, &p.User_id, &timestamp, &tagString, &p.Brand, &p.Likes_num); err != nil {
+		p, key := misc.Purchase{}, ""
+		if err := rows.Scan(&p.Id, &p.Image, &p.Description, &p.User_id, &timestamp, &tagString, &p.Brand, &p.Likes_num, &key); err != nil {
 			log.Println(err)
-			return []*misc.Purchase{}, misc.NothingToReport
+			return misc.PurchasePage{Purchases: []*misc.Purchase{}}, misc.NothingToReport
 		}
 
 		for _, v := range strings.Split(tagString[1:len(tagString)-1], ",") {
 			if tagId, err := strconv.Atoi(v); err != nil {
 				log.Println(err)
-				return []*misc.Purchase{}, misc.NothingToReport
+				return misc.PurchasePage{Purchases: []*misc.Purchase{}}, misc.NothingToReport
 			} else {
 				p.Tags = append(p.Tags, tagId)
 			}
 		}
 
 		p.Issued_at = timestamp.Unix()
-		purchases = append(purchases, &p)
+		purchases, keys = append(purchases, &p), append(keys, key)
 	}
 
 	if err = rows.Err(); err != nil {
 		log.Println(err)
-		return []*misc.Purchase{}, misc.NothingToReport
+		return misc.PurchasePage{Purchases: []*misc.Purchase{}}, misc.NothingToReport
 	}
 
-	return purchases, misc.NothingToReport
+	if len(purchases) <= limit {
+		return misc.PurchasePage{Purchases: purchases}, misc.NothingToReport
+	}
+
+	return misc.PurchasePage{
+		Purchases:   purchases[:limit],
+		Next_cursor: misc.EncodeCursor(keys[limit-1], purchases[limit-1].Id),
+	}, misc.NothingToReport
+}
+
+// maxScore is greater than any feed score, so the first page of a feed starts from it. A score grows by
+// 1 for every 12.5 hours, it reaches maxScore in more than a million years
+const maxScore = "1000000000"
+
+var scoreKeyRe = regexp.MustCompile(`^\d+(\.\d+)?$`)
+
+// isScoreKeyValid checks that a sort key is a feed score, a number printed by Postgres as a numeric
+func isScoreKeyValid(key string) bool {
+	return scoreKeyRe.MatchString(key)
+}
+
+// showPage returns a page of purchases which satisfy a condition, starting from the newest one.
+// Purchases are sorted by (issued_at, id), so the pages are stable while new purchases are
+// created. The condition can use placeholders $1 ... $len(args)
+func showPage(cursor string, limit int, condition string, args ...interface{}) (misc.PurchasePage, int) {
+	// the first page starts from infinity, every purchase was issued before it
+	issuedAt, lastId, code := misc.DecodePage(cursor, limit, "infinity", misc.IsTimeKeyValid)
+	if code != misc.NothingToReport {
+		return misc.PurchasePage{Purchases: []*misc.Purchase{}}, code
+	}
+
+	n := len(args)
+	rows, err := psql.Db.Query(fmt.Sprintf(`
+		SELECT id, image, description, user_id, issued_at, tag_ids, brand_id, likes_num, issued_at::text
+		FROM purchases
+		WHERE %s AND (issued_at, id) < ($%d::timestamp, $%d)
+		ORDER BY issued_at DESC, id DESC
+		LIMIT $%d`, condition, n+1, n+2, n+3),
+		append(args, issuedAt, lastId, limit+1)...,
+	)
+
+	return getPage(rows, err, limit)
 }
 
 func getCreatorByPurchaseId(purchaseId int) (int, int) {
@@ -101,14 +148,9 @@ func getCreatorByQuestionId(questionId int) (int, int) {
 	return whosePurchase, misc.NothingToReport
 }
 
-// ShowAll returns all purchases
-func ShowAll() ([]*misc.Purchase, int) {
-	rows, err := psql.Db.Query(`
-		SELECT id, image, description, user_id, issued_at, tag_ids, brand_id, likes_num
-		FROM purchases
-		ORDER BY issued_at DESC`)
-
-	return getPurchases(rows, err)
+// ShowAll returns a page of all purchases
+func ShowAll(cursor string, limit int) (misc.PurchasePage, int) {
+	return showPage(cursor, limit, "TRUE")
 }
 
 // ShowById returns one purchase with Id
@@ -147,51 +189,70 @@ func ShowById(purchaseId int) (misc.Purchase, int) {
 	return p, misc.NothingToReport
 }
 
-// ShowByUserId returns all purchases done by user Id
-func ShowByUserId(userId int) ([]*misc.Purchase, int) {
+// ShowByUserId returns a page of purchases done by user Id
+func ShowByUserId(userId int, cursor string, limit int) (misc.PurchasePage, int) {
 	// userId is the current user and is always valid
-	rows, err := psql.Db.Query(`
-		SELECT id, image, description, user_id, issued_at, tag_ids, brand_id, likes_num
-		FROM purchases
-		WHERE user_id = $1
-		ORDER BY issued_at DESC`, userId)
-
-	return getPurchases(rows, err)
+	return showPage(cursor, limit, "user_id = $1", userId)
 }
 
-// ShowByBrandId returns all purchases with a brand Id
-func ShowByBrandId(brandId int) ([]*misc.Purchase, int) {
+// ShowByBrandId returns a page of purchases with a brand Id
+func ShowByBrandId(brandId int, cursor string, limit int) (misc.PurchasePage, int) {
 	if !misc.IsIdValid(brandId) {
 		log.Println("Brand Id is wrong", brandId)
-		return []*misc.Purchase{}, misc.NothingToReport
+		return misc.PurchasePage{Purchases: []*misc.Purchase{}}, misc.NothingToReport
 	}
 
-	rows, err := psql.Db.Query(`
-		SELECT id, image, description, user_id, issued_at, tag_ids, brand_id, likes_num
-		FROM purchases
-		WHERE brand_id = $1
-		ORDER BY issued_at DESC`, brandId)
-
-	return getPurchases(rows, err)
+	return showPage(cursor, limit, "brand_id = $1", brandId)
 }
 
-// ShowByTagId returns all purchases with a tag Id
-func ShowByTagId(tagId int) ([]*misc.Purchase, int) {
+// ShowByTagId returns a page of purchases with a tag Id
+func ShowByTagId(tagId int, cursor string, limit int) (misc.PurchasePage, int) {
 	if !misc.IsIdValid(tagId) {
 		log.Println("Tag ID is wrong", tagId)
-		return []*misc.Purchase{}, misc.NothingToReport
+		return misc.PurchasePage{Purchases: []*misc.Purchase{}}, misc.NothingToReport
 	}
 
-	rows, err := psql.Db.Query(`
-		SELECT id, image, description, user_id, issued_at, tag_ids, brand_id, likes_num
-		FROM purchases
-		WHERE $1 = ANY (tag_ids)
-		ORDER BY issued_at DESC`, tagId)
+	return showPage(cursor, limit, "$1 = ANY (tag_ids)", tagId)
+}
 
-	return getPurchases(rows, err)
+// Feed returns a page of purchases which a user might be interested in: purchases of people whom
+// the user follows and purchases with tags/brands the user likes. Purchases with ignored tags/brands
+// are never shown. Purchases are ranked by a score which grows by 1 for every 10 times more likes
+// and for every 12.5 hours of being newer. The score does not depend on the current time, so the
+// pages are stable. It is rounded to a numeric, so its text in a cursor is exact and does not depend
+// on how a version of Postgres prints floats
+func Feed(userId int, cursor string, limit int) (misc.PurchasePage, int) {
+	// userId is the current user and is always valid
+	score, lastId, code := misc.DecodePage(cursor, limit, maxScore, isScoreKeyValid)
+	if code != misc.NothingToReport {
+		return misc.PurchasePage{Purchases: []*misc.Purchase{}}, code
+	}
+
+	rows, err := psql.Db.Query(`
+		SELECT id, image, description, user_id, issued_at, tag_ids, brand_id, likes_num, score::text
+		FROM (
+			SELECT p.*, round((log((p.likes_num + 1)::float8) + extract(epoch FROM p.issued_at)::float8 / 45000)::numeric, 9) AS score
+			FROM purchases p, users u
+			WHERE u.id = $1 AND p.user_id <> u.id AND (
+				p.user_id IN (
+					SELECT whom_id
+					FROM followers
+					WHERE who_id = $1
+				)
+				OR p.tag_ids && u.tags_like
+				OR p.brand_id = ANY (u.brands_like)
+			)
+			AND NOT p.tag_ids && u.tags_ignore
+			AND NOT p.brand_id = ANY (u.brands_ignore)
+		) AS feed
+		WHERE (score, id) < ($2::numeric, $3)
+		ORDER BY score DESC, id DESC
+		LIMIT $4`, userId, score, lastId, limit+1)
+
+	return getPage(rows, err, limit)
 }
 
-// Create a new purchase
+// Create a new purchase. The image has to be an unused upload of the user
 func Create(userId int, description, image string, brandId int, tagsId []int) (int, int) {
 	// userID is the current user and should be valid
 	description, ok := misc.ValidateString(description, misc.MaxLenB)
@@ -221,31 +282,234 @@ func Create(userId int, description, image string, brandId int, tagsId []int) (i
 	}
 
 	tagsToInsert := "{" + strings.Join(stringTagIds, ",") + "}"
-	err := psql.Db.QueryRow(`
-		INSERT INTO purchases (image, description, user_id, tag_ids, brand_id)
-		VALUES ($1, $2, $3, $4, $5)
-		RETURNING id`, image, description, userId, tagsToInsert, brandId).Scan(&id)
-	if err != nil {
+	if err, code := psql.Transaction(func(tx *sql.Tx) (error, int) {
+		if err, code := upload.Consume(tx, userId, misc.UploadPurchase, image); err != nil {
+			return err, code
+		}
+
+		if err := tx.QueryRow(`
+			INSERT INTO purchases (image, description, user_id, tag_ids, brand_id)
+			VALUES ($1, $2, $3, $4, $5)
+			RETURNING id`, image, description, userId, tagsToInsert, brandId,
+		).Scan(&id); err != nil {
+			err, code := psql.CheckSpecificDriverErrors(err)
+			log.Println(err)
+			return err, code
+		}
+
+		sqlResult, err := tx.Exec(`
+			UPDATE users
+			SET purchases_num = purchases_num + 1
+			WHERE id=$1`, userId)
 		if err, code := psql.CheckSpecificDriverErrors(err); err != nil {
 			log.Println(err)
-			return 0, code
+			return err, code
 		}
+
+		return psql.IsAffectedOneRow(sqlResult)
+	}); err != nil {
+		return 0, code
 	}
 
-	sqlResult, err := psql.Db.Exec(`
-		UPDATE users
-		SET purchases_num = purchases_num + 1
-		WHERE id=$1`, userId)
-	if err, code := psql.CheckSpecificDriverErrors(err); err != nil {
+	return id, misc.NothingToReport
+}
+
+// removeImageIfUnused removes the files of a purchase image if no purchase references it anymore
+func removeImageIfUnused(image string) {
+	isUsed := true
+	if err := psql.Db.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1
+			FROM purchases
+			WHERE image = $1
+		)`, image,
+	).Scan(&isUsed); err != nil {
 		log.Println(err)
-		return 0, code
+		return
 	}
 
-	if err, code := psql.IsAffectedOneRow(sqlResult); err != nil {
-		return 0, code
+	if !isUsed {
+		imager.RemovePurchase(image)
 	}
+}
 
-	return id, misc.NothingToReport
+// Update changes information about a purchase. Only the creator of a purchase can change it
+func Update(purchaseId, userId int, description, image string, brandId int, tagsId []int) int {
+	if !misc.IsIdValid(purchaseId) {
+		log.Println("Purchase ID is wrong", purchaseId)
+		return misc.NoPurchase
+	}
+
+	whosePurchase, oldImage := 0, ""
+	if err := psql.Db.QueryRow(`
+		SELECT user_id, image
+		FROM purchases
+		WHERE id = $1`, purchaseId,
+	).Scan(&whosePurchase, &oldImage); err != nil {
+		if err == sql.ErrNoRows {
+			log.Println(err)
+			return misc.NoPurchase
+		}
+
+		log.Println(err)
+		return misc.NothingToReport
+	}
+
+	if whosePurchase != userId {
+		log.Println("can change only own purchase")
+		return misc.NotYourPurchase
+	}
+
+	description, ok := misc.ValidateString(description, misc.MaxLenB)
+	if !ok {
+		log.Println("description is wrong", description)
+		return misc.WrongDescr
+	}
+
+	// an old image might be uploaded long time ago, only a new one has to be an own fresh upload
+	if image != oldImage && !imager.IsPurchaseValid(image) {
+		log.Println("Purchase is not valid", image)
+		return misc.WrongImg
+	}
+
+	if brandId < 0 {
+		log.Println("BrandID is wrong", brandId)
+		return misc.NoElement
+	}
+
+	if err, code := tag.ValidateTags(tagsId); err != nil {
+		log.Println(err)
+		return code
+	}
+
+	if err, code := psql.Transaction(func(tx *sql.Tx) (error, int) {
+		if image != oldImage {
+			if err, code := upload.Consume(tx, userId, misc.UploadPurchase, image); err != nil {
+				return err, code
+			}
+		}
+
+		sqlResult, err := tx.Exec(`
+			UPDATE purchases
+			SET description = $1, image = $2, brand_id = $3, tag_ids = $4
+			WHERE id = $5 AND user_id = $6`, description, image, brandId, psql.FormatIntArray(tagsId), purchaseId, userId)
+		if err, code := psql.CheckSpecificDriverErrors(err); err != nil {
+			log.Println(err)
+			return err, code
+		}
+
+		return psql.IsAffectedOneRow(sqlResult)
+	}); err != nil {
+		return code
+	}
+
+	if image != oldImage {
+		removeImageIfUnused(oldImage)
+	}
+
+	return misc.NothingToReport
+}
+
+// Delete removes a purchase together with its likes, questions, answers and votes for them.
+// All the counters of affected users are decreased. Only the creator of a purchase can delete it
+func Delete(purchaseId, userId int) int {
+	if !misc.IsIdValid(purchaseId) {
+		log.Println("Purchase ID is wrong", purchaseId)
+		return misc.NoPurchase
+	}
+
+	image := ""
+	if err, code := psql.Transaction(func(tx *sql.Tx) (error, int) {
+		whosePurchase := 0
+		if err := tx.QueryRow(`
+			SELECT user_id, image
+			FROM purchases
+			WHERE id = $1
+			FOR UPDATE`, purchaseId,
+		).Scan(&whosePurchase, &image); err != nil {
+			if err == sql.ErrNoRows {
+				log.Println(err)
+				return err, misc.NoPurchase
+			}
+
+			log.Println(err)
+			return err, misc.NothingToReport
+		}
+
+		if whosePurchase != userId {
+			log.Println("can delete only own purchase")
+			return errors.New("can delete only own purchase"), misc.NotYourPurchase
+		}
+
+		// order matters: votes and answers reference questions, everything references the purchase
+		for _, query := range []string{`
+			UPDATE users u
+			SET answers_num = u.answers_num - a.num, expertise = u.expertise - a.votes
+			FROM (
+				SELECT user_id, COUNT(*) AS num, SUM(votes_num) AS votes
+				FROM answers
+				WHERE question_id IN (SELECT id FROM questions WHERE purchase_id = $1)
+				GROUP BY user_id
+			) a
+			WHERE u.id = a.user_id`, `
+			UPDATE users u
+			SET questions_num = u.questions_num - q.num
+			FROM (
+				SELECT user_id, COUNT(*) AS num
+				FROM questions
+				WHERE purchase_id = $1
+				GROUP BY user_id
+			) q
+			WHERE u.id = q.user_id`, `
+			DELETE FROM votes_answers
+			WHERE answer_id IN (
+				SELECT id
+				FROM answers
+				WHERE question_id IN (SELECT id FROM questions WHERE purchase_id = $1)
+			)`, `
+			DELETE FROM answers
+			WHERE question_id IN (SELECT id FROM questions WHERE purchase_id = $1)`, `
+			DELETE FROM votes_questions
+			WHERE question_id IN (SELECT id FROM questions WHERE purchase_id = $1)`, `
+			DELETE FROM questions
+			WHERE purchase_id = $1`, `
+			DELETE FROM likes
+			WHERE purchase_id = $1`,
+		} {
+			if _, err := tx.Exec(query, purchaseId); err != nil {
+				log.Println(err)
+				return err, misc.NothingToReport
+			}
+		}
+
+		sqlResult, err := tx.Exec(`
+			DELETE FROM purchases
+			WHERE id = $1`, purchaseId)
+		if err, code := psql.CheckSpecificDriverErrors(err); err != nil {
+			log.Println(err)
+			return err, code
+		}
+
+		if err, code := psql.IsAffectedOneRow(sqlResult); err != nil {
+			return err, code
+		}
+
+		sqlResult, err = tx.Exec(`
+			UPDATE users
+			SET purchases_num = purchases_num - 1
+			WHERE id = $1`, userId)
+		if err, code := psql.CheckSpecificDriverErrors(err); err != nil {
+			log.Println(err)
+			return err, code
+		}
+
+		return psql.IsAffectedOneRow(sqlResult)
+	}); err != nil {
+		return code
+	}
+
+	removeImageIfUnused(image)
+	return misc.NothingToReport
 }
 
 // Like a purchase with some Id
@@ -267,31 +531,31 @@ func Like(purchaseId, userId int) int {
 	}
 
 	// now allow the person to vote for someones else purchase
-	sqlResult, err := psql.Db.Exec(`
-		INSERT INTO likes (purchase_id, user_id)
-		VALUES ($1, $2)`, purchaseId, userId)
-	if err, code := psql.CheckSpecificDriverErrors(err); err != nil {
-		log.Println(err)
-		return code
-	}
-	if err, code := psql.IsAffectedOneRow(sqlResult); err != nil {
-		return code
-	}
+	_, code = psql.Transaction(func(tx *sql.Tx) (error, int) {
+		sqlResult, err := tx.Exec(`
+			INSERT INTO likes (purchase_id, user_id)
+			VALUES ($1, $2)`, purchaseId, userId)
+		if err, code := psql.CheckSpecificDriverErrors(err); err != nil {
+			log.Println(err)
+			return err, code
+		}
+		if err, code := psql.IsAffectedOneRow(sqlResult); err != nil {
+			return err, code
+		}
 
-	sqlResult, err = psql.Db.Exec(`
-		UPDATE purchases
-		SET likes_num = likes_num + 1
-		WHERE id = $1`, purchaseId)
-	if err, code := psql.CheckSpecificDriverErrors(err); err != nil {
-		log.Println(err)
-		return code
-	}
+		sqlResult, err = tx.Exec(`
+			UPDATE purchases
+			SET likes_num = likes_num + 1
+			WHERE id = $1`, purchaseId)
+		if err, code := psql.CheckSpecificDriverErrors(err); err != nil {
+			log.Println(err)
+			return err, code
+		}
 
-	if err, code := psql.IsAffectedOneRow(sqlResult); err != nil {
-		return code
-	}
+		return psql.IsAffectedOneRow(sqlResult)
+	})
 
-	return misc.NothingToReport
+	return code
 }
 
 // Unlike a purchase which a user previously liked
@@ -312,31 +576,31 @@ func Unlike(purchaseId, userId int) int {
 		return misc.VoteForYourself
 	}
 
-	sqlResult, err := psql.Db.Exec(`
-		DELETE FROM likes
-		WHERE purchase_id = $1 AND user_id = $2`, purchaseId, userId)
-	if err, code := psql.CheckSpecificDriverErrors(err); err != nil {
-		log.Println(err)
-		return code
-	}
-	if err, code := psql.IsAffectedOneRow(sqlResult); err != nil {
-		return code
-	}
+	_, code = psql.Transaction(func(tx *sql.Tx) (error, int) {
+		sqlResult, err := tx.Exec(`
+			DELETE FROM likes
+			WHERE purchase_id = $1 AND user_id = $2`, purchaseId, userId)
+		if err, code := psql.CheckSpecificDriverErrors(err); err != nil {
+			log.Println(err)
+			return err, code
+		}
+		if err, code := psql.IsAffectedOneRow(sqlResult); err != nil {
+			return err, code
+		}
 
-	sqlResult, err = psql.Db.Exec(`
-		UPDATE purchases
-		SET likes_num = likes_num - 1
-		WHERE id = $1`, purchaseId)
-	if err, code := psql.CheckSpecificDriverErrors(err); err != nil {
-		log.Println(err)
-		return code
-	}
+		sqlResult, err = tx.Exec(`
+			UPDATE purchases
+			SET likes_num = likes_num - 1
+			WHERE id = $1`, purchaseId)
+		if err, code := psql.CheckSpecificDriverErrors(err); err != nil {
+			log.Println(err)
+			return err, code
+		}
 
-	if err, code := psql.IsAffectedOneRow(sqlResult); err != nil {
-		return code
-	}
+		return psql.IsAffectedOneRow(sqlResult)
+	})
 
-	return misc.NothingToReport
+	return code
 }
 
 // AskQuestion about a specific purchase
@@ -358,27 +622,28 @@ func AskQuestion(purchaseId, userId int, question string) (int, int) {
 	}
 
 	questionId := 0
-	err := psql.Db.QueryRow(`
-		INSERT INTO questions (user_id, purchase_id, name)
-		VALUES ($1, $2, $3)
-		RETURNING id`, userId, purchaseId, question,
-	).Scan(&questionId)
-	if err != nil {
-		err, code := psql.CheckSpecificDriverErrors(err)
-		log.Println(err)
-		return 0, code
-	}
+	if err, code := psql.Transaction(func(tx *sql.Tx) (error, int) {
+		if err := tx.QueryRow(`
+			INSERT INTO questions (user_id, purchase_id, name)
+			VALUES ($1, $2, $3)
+			RETURNING id`, userId, purchaseId, question,
+		).Scan(&questionId); err != nil {
+			err, code := psql.CheckSpecificDriverErrors(err)
+			log.Println(err)
+			return err, code
+		}
 
-	sqlResult, err := psql.Db.Exec(`
-		UPDATE users
-		SET questions_num = questions_num + 1
-		WHERE id = $1`, userId)
-	if err, code := psql.CheckSpecificDriverErrors(err); err != nil {
-		log.Println(err)
-		return 0, code
-	}
+		sqlResult, err := tx.Exec(`
+			UPDATE users
+			SET questions_num = questions_num + 1
+			WHERE id = $1`, userId)
+		if err, code := psql.CheckSpecificDriverErrors(err); err != nil {
+			log.Println(err)
+			return err, code
+		}
 
-	if err, code := psql.IsAffectedOneRow(sqlResult); err != nil {
+		return psql.IsAffectedOneRow(sqlResult)
+	}); err != nil {
 		return 0, code
 	}
 
@@ -404,27 +669,28 @@ func AnswerQuestion(questionId, userId int, answer string) (int, int) {
 	}
 
 	answerId := 0
-	err := psql.Db.QueryRow(`
-		INSERT INTO answers (user_id, question_id, name)
-		VALUES ($1, $2, $3)
-		RETURNING id`, userId, questionId, answer,
-	).Scan(&answerId)
-	if err != nil {
-		err, code := psql.CheckSpecificDriverErrors(err)
-		log.Println(err)
-		return 0, code
-	}
+	if err, code := psql.Transaction(func(tx *sql.Tx) (error, int) {
+		if err := tx.QueryRow(`
+			INSERT INTO answers (user_id, question_id, name)
+			VALUES ($1, $2, $3)
+			RETURNING id`, userId, questionId, answer,
+		).Scan(&answerId); err != nil {
+			err, code := psql.CheckSpecificDriverErrors(err)
+			log.Println(err)
+			return err, code
+		}
 
-	sqlResult, err := psql.Db.Exec(`
-		UPDATE users
-		SET answers_num = answers_num + 1
-		WHERE id = $1`, userId)
-	if err, code := psql.CheckSpecificDriverErrors(err); err != nil {
-		log.Println(err)
-		return 0, code
-	}
+		sqlResult, err := tx.Exec(`
+			UPDATE users
+			SET answers_num = answers_num + 1
+			WHERE id = $1`, userId)
+		if err, code := psql.CheckSpecificDriverErrors(err); err != nil {
+			log.Println(err)
+			return err, code
+		}
 
-	if err, code := psql.IsAffectedOneRow(sqlResult); err != nil {
+		return psql.IsAffectedOneRow(sqlResult)
+	}); err != nil {
 		return 0, code
 	}
 
@@ -8,6 +8,7 @@ import (
 	"log"
 	"os"
 	"reflect"
+	"regexp"
 	"testing"
 )
 
@@ -43,14 +44,15 @@ func TestShowByUserId(t *testing.T) {
 	}
 
 	for num, v := range tableSuccess {
-		purchases, code := ShowByUserId(v.userId)
+		page, code := ShowByUserId(v.userId, "", misc.PageSize)
+		purchases := page.Purchases
 		if code != misc.NothingToReport || len(purchases) != v.numPurchases {
 			t.Errorf("Case %v. Expect 0 %v. Got %v %v", num, len(purchases), code, v.numPurchases)
 		}
 	}
 
-	purchases, _ := ShowByUserId(4)
-	p, b := purchases[0], o.AllPurchases[2]
+	page, _ := ShowByUserId(4, "", misc.PageSize)
+	p, b := page.Purchases[0], o.AllPurchases[2]
 	if p.Id != b.Id || p.Image != b.Image || p.Description != b.Description || p.Likes_num != b.Likes_num || p.User_id != b.User_id || p.Brand != b.Brand {
 		t.Errorf("Expect %v. Got %v", b, p)
 	}
@@ -63,7 +65,8 @@ func TestShowByUserId(t *testing.T) {
 func TestShowAll(t *testing.T) {
 	o.CleanUpDb()
 
-	purchases, code := ShowAll()
+	page, code := ShowAll("", misc.PageSize)
+	purchases := page.Purchases
 	if code != misc.NothingToReport {
 		t.Errorf("Expect %v. Got %v", misc.NothingToReport, code)
 	}
@@ -135,7 +138,8 @@ func TestShowByBrandId(t *testing.T) {
 		{9, map[int]bool{}},
 	}
 	for num, v := range tableSuccess {
-		purchases, code := ShowByBrandId(v.brandId)
+		page, code := ShowByBrandId(v.brandId, "", misc.PageSize)
+		purchases := page.Purchases
 		if code != misc.NothingToReport {
 			t.Errorf("Case %v. Expect correct execution. Got %v", num, code)
 		}
@@ -179,7 +183,8 @@ func TestShowByTagId(t *testing.T) {
 		{-1, map[int]bool{}},
 	}
 	for num, v := range tableSuccess {
-		purchases, code := ShowByTagId(v.tagId)
+		page, code := ShowByTagId(v.tagId, "", misc.PageSize)
+		purchases := page.Purchases
 		if code != misc.NothingToReport {
 			t.Errorf("Case %v. Expect correct execution. Got %v", num, code)
 		}
@@ -207,6 +212,241 @@ func TestShowByTagId(t *testing.T) {
 	}
 }
 
+func TestShowAllPages(t *testing.T) {
+	o.CleanUpDb()
+
+	// walking through pages of any size should return every purchase exactly once, newest first
+	for _, limit := range []int{1, 2, 3, len(o.AllPurchases), misc.MaxPageSize} {
+		ids, cursor := []int{}, ""
+		for i := 0; i <= len(o.AllPurchases); i++ {
+			page, code := ShowAll(cursor, limit)
+			if code != misc.NothingToReport || len(page.Purchases) > limit {
+				t.Errorf("Limit %v. Expect at most %v purchases. Got %v, %v", limit, limit, len(page.Purchases), code)
+			}
+
+			for _, p := range page.Purchases {
+				ids = append(ids, p.Id)
+			}
+
+			if cursor = page.Next_cursor; cursor == "" {
+				break
+			}
+		}
+
+		if !reflect.DeepEqual(ids, []int{4, 3, 2, 1}) {
+			t.Errorf("Limit %v. Expect %v. Got %v", limit, []int{4, 3, 2, 1}, ids)
+		}
+	}
+
+	// a purchase created after the first page was returned does not shift the next page
+	page, _ := ShowByUserId(1, "", 1)
+	psql.Db.Exec(`
+		INSERT INTO purchases (image, description, user_id, tag_ids, brand_id)
+		VALUES ('1467954439_isForTests.jpg', 'Something new', 1, '{2}', 0)`)
+	page, _ = ShowByUserId(1, page.Next_cursor, 1)
+	if len(page.Purchases) != 1 || page.Purchases[0].Id != 3 {
+		t.Errorf("Expect purchase 3 on the second page. Got %v", page.Purchases)
+	}
+
+	tableFail := []struct {
+		cursor string
+		limit  int
+		code   int
+	}{
+		{"", 0, misc.WrongPageSize},
+		{"", -1, misc.WrongPageSize},
+		{"", misc.MaxPageSize + 1, misc.WrongPageSize},
+		{"not a cursor", 5, misc.WrongCursor},
+		{misc.EncodeCursor("yesterday", 3), 5, misc.WrongCursor},
+		{misc.EncodeCursor("2016-07-08 05:07:19", 0), 5, misc.WrongCursor},
+	}
+	for num, v := range tableFail {
+		page, code := ShowAll(v.cursor, v.limit)
+		if code != v.code || len(page.Purchases) != 0 || page.Next_cursor != "" {
+			t.Errorf("Case %v. Expect %v. Got %v, %v", num, v.code, code, page)
+		}
+	}
+}
+
+func TestFeed(t *testing.T) {
+	o.CleanUpDb()
+
+	// seeded users have no preferences, so set up some of them
+	psql.Db.Exec(`UPDATE users SET tags_like = '{2}', brands_ignore = '{5}' WHERE id = 2`)
+	psql.Db.Exec(`UPDATE users SET brands_like = '{5}', tags_ignore = '{4}' WHERE id = 1`)
+	psql.Db.Exec(`UPDATE users SET tags_like = '{2, 4}', tags_ignore = '{2}' WHERE id = 3`)
+
+	tableSuccess := []struct {
+		userId      int
+		purchaseIds []int
+	}{
+		{1, []int{2}},    // follows user 4, own purchases are not shown
+		{2, []int{4, 1}}, // likes drones, purchase 4 has more likes
+		{3, []int{3}},    // ignoring a tag is stronger than liking it
+		{4, []int{}},     // follows nobody and likes nothing
+		{6, []int{}},     // follows a user without purchases
+		{11, []int{}},    // does not exist
+	}
+	for num, v := range tableSuccess {
+		page, code := Feed(v.userId, "", misc.PageSize)
+		if code != misc.NothingToReport || page.Next_cursor != "" {
+			t.Errorf("Case %v. Expect correct execution. Got %v, %v", num, code, page.Next_cursor)
+		}
+
+		ids := []int{}
+		for _, p := range page.Purchases {
+			ids = append(ids, p.Id)
+		}
+
+		if !reflect.DeepEqual(ids, v.purchaseIds) {
+			t.Errorf("Case %v. Expect %v. Got %v", num, v.purchaseIds, ids)
+		}
+	}
+
+	page, _ := Feed(2, "", 1)
+	if len(page.Purchases) != 1 || page.Purchases[0].Id != 4 || page.Next_cursor == "" {
+		t.Errorf("Expect purchase 4 and a cursor. Got %v", page)
+	}
+
+	// a score in a cursor is exact, it does not depend on how floats are printed
+	if score, _, _ := misc.DecodeCursor(page.Next_cursor); !regexp.MustCompile(`^\d+\.\d{9}$`).MatchString(score) {
+		t.Errorf("Expect a score with 9 decimal digits. Got %v", score)
+	}
+
+	page, _ = Feed(2, page.Next_cursor, 1)
+	if len(page.Purchases) != 1 || page.Purchases[0].Id != 1 || page.Next_cursor != "" {
+		t.Errorf("Expect purchase 1 without a cursor. Got %v", page)
+	}
+
+	for num, cursor := range []string{"not a cursor", misc.EncodeCursor("2016-07-08 05:07:19", 3), misc.EncodeCursor("NaN", 3)} {
+		if _, code := Feed(2, cursor, 1); code != misc.WrongCursor {
+			t.Errorf("Case %v. Expect %v. Got %v", num, misc.WrongCursor, code)
+		}
+	}
+}
+
+func TestUpdate(t *testing.T) {
+	o.CleanUpDb()
+
+	img, descr := "1467954439_isForTests.jpg", o.RandomString(misc.MaxLenB, 0, 0)
+	tableFail := []struct {
+		purchaseId int
+		userId     int
+		descr      string
+		image      string
+		brandId    int
+		tagIds     []int
+		code       int
+	}{
+		{1, 2, descr, img, 0, []int{2}, misc.NotYourPurchase},
+		{9, 1, descr, img, 0, []int{2}, misc.NoPurchase},
+		{0, 1, descr, img, 0, []int{2}, misc.NoPurchase},
+		{1, 1, "  ", img, 0, []int{2}, misc.WrongDescr},
+		{1, 1, o.RandomString(misc.MaxLenB, 1, 1), img, 0, []int{2}, misc.WrongDescr},
+		{1, 1, descr, "1467954439_missing.jpg", 0, []int{2}, misc.WrongImg},
+		{1, 1, descr, img, 0, []int{9}, misc.WrongTags},
+		{1, 1, descr, img, 0, []int{}, misc.NoTags},
+		{1, 1, descr, img, 9, []int{2}, misc.DbForeignKeyViolation},
+		{1, 1, descr, img, -1, []int{2}, misc.NoElement},
+	}
+	for num, v := range tableFail {
+		if code := Update(v.purchaseId, v.userId, v.descr, v.image, v.brandId, v.tagIds); code != v.code {
+			t.Errorf("Case %v. Expect %v. Got %v", num, v.code, code)
+		}
+	}
+
+	p, _ := ShowById(1)
+	if p.Description != o.AllPurchases[1].Description {
+		t.Errorf("Expect purchase to stay the same. Got %v", p)
+	}
+
+	if code := Update(1, 1, descr, img, 4, []int{4, 2}); code != misc.NothingToReport {
+		t.Errorf("Expect correct execution. Got %v", code)
+	}
+
+	p, _ = ShowById(1)
+	if p.Description != descr || p.Image != img || p.Brand != 4 || !reflect.DeepEqual(p.Tags, []int{4, 2}) || p.User_id != 1 {
+		t.Errorf("Expect purchase to be updated. Got %v", p)
+	}
+
+	// a new image has to be an own upload, the old one does not
+	psql.Db.Exec(`UPDATE purchases SET image = '1467954439_old.jpg' WHERE id = 1`)
+	o.GiveUpload(2, misc.UploadPurchase, img)
+	if code := Update(1, 1, descr, img, 4, []int{4, 2}); code != misc.WrongUpload {
+		t.Errorf("Expect an upload of another user not to be used. Got %v", code)
+	}
+
+	o.GiveUpload(1, misc.UploadPurchase, img)
+	if code := Update(1, 1, descr, img, 4, []int{4, 2}); code != misc.NothingToReport {
+		t.Errorf("Expect an own upload to be used. Got %v", code)
+	}
+}
+
+// getCounters returns purchases_num, questions_num, answers_num and expertise of a user
+func getCounters(userId int) []int {
+	counters := make([]int, 4)
+	psql.Db.QueryRow(`
+		SELECT purchases_num, questions_num, answers_num, expertise
+		FROM users
+		WHERE id = $1`, userId,
+	).Scan(&counters[0], &counters[1], &counters[2], &counters[3])
+	return counters
+}
+
+func TestDelete(t *testing.T) {
+	o.CleanUpDb()
+
+	// the answer about purchase 1 got an upvote which gave its author some expertise
+	psql.Db.Exec(`INSERT INTO votes_answers (user_id, answer_id, is_voting_up) VALUES (2, 1, TRUE)`)
+	psql.Db.Exec(`UPDATE answers SET votes_num = 1 WHERE id = 1`)
+	psql.Db.Exec(`UPDATE users SET expertise = 1 WHERE id = 1`)
+
+	tableFail := []struct {
+		purchaseId int
+		userId     int
+		code       int
+	}{
+		{1, 7, misc.NotYourPurchase},
+		{2, 1, misc.NotYourPurchase},
+		{9, 1, misc.NoPurchase},
+		{-1, 1, misc.NoPurchase},
+	}
+	for num, v := range tableFail {
+		if code := Delete(v.purchaseId, v.userId); code != v.code {
+			t.Errorf("Case %v. Expect %v. Got %v", num, v.code, code)
+		}
+	}
+
+	table := []struct {
+		purchaseId int
+		userId     int
+		counters   map[int][]int
+	}{
+		{1, 1, map[int][]int{1: {2, 0, 0, 0}, 7: {0, 1, 0, 0}}},
+		{3, 1, map[int][]int{1: {1, 0, 0, 0}, 4: {1, 0, 0, 0}}},
+		{4, 1, map[int][]int{1: {0, 0, 0, 0}, 7: {0, 0, 0, 0}}},
+	}
+	for num, v := range table {
+		if code := Delete(v.purchaseId, v.userId); code != misc.NothingToReport {
+			t.Errorf("Case %v. Expect correct execution. Got %v", num, code)
+		}
+
+		if _, code := ShowById(v.purchaseId); code != misc.NoElement {
+			t.Errorf("Case %v. Expect purchase to be deleted. Got %v", num, code)
+		}
+
+		for userId, counters := range v.counters {
+			if c := getCounters(userId); !reflect.DeepEqual(c, counters) {
+				t.Errorf("Case %v. User %v. Expect %v. Got %v", num, userId, counters, c)
+			}
+		}
+	}
+
+	if code := Delete(1, 1); code != misc.NoPurchase {
+		t.Errorf("Expect %v. Got %v", misc.NoPurchase, code)
+	}
+}
+
 func TestLike(t *testing.T) {
 	o.CleanUpDb()
 
@@ -314,6 +554,7 @@ func TestUnlike(t *testing.T) {
 func TestCreate(t *testing.T) {
 	o.CleanUpDb()
 
+	img := "1467954439_isForTests.jpg"
 	tableSuccess := []struct {
 		userId  int
 		descr   string
@@ -328,7 +569,8 @@ func TestCreate(t *testing.T) {
 		{5, o.RandomString(misc.MaxLenB, 0, 0), 1, []int{2, 4, 5, 3}},
 	}
 	for num, v := range tableSuccess {
-		id, code := Create(v.userId, v.descr, v.brandId, v.tagIds)
+		o.GiveUpload(v.userId, misc.UploadPurchase, img)
+		id, code := Create(v.userId, v.descr, img, v.brandId, v.tagIds)
 		if code != misc.NothingToReport {
 			t.Errorf("Case %v. Expect correct execution. Got %v", num, code)
 		}
@@ -338,7 +580,7 @@ func TestCreate(t *testing.T) {
 		}
 
 		p, _ := ShowById(id)
-		if p.Id != id || p.Description != v.descr || p.Brand != v.brandId {
+		if p.Id != id || p.Description != v.descr || p.Image != img || p.Brand != v.brandId {
 			t.Errorf("Case %v. Expect %v %v %v. Got %v %v %v", num, p.Id, len(p.Description), p.Brand, id, len(v.descr), v.brandId)
 		}
 
@@ -351,26 +593,62 @@ func TestCreate(t *testing.T) {
 		}
 	}
 
+	// a user which does not exist can't upload anything, so the upload belongs to nobody (0)
 	tableFail := []struct {
-		userId  int
-		descr   string
-		brandId int
-		tagIds  []int
-		code    int
+		userId   int
+		uploader int
+		descr    string
+		image    string
+		brandId  int
+		tagIds   []int
+		code     int
 	}{
-		{19, o.RandomString(misc.MaxLenB, 0, 0), 1, []int{4}, misc.DbForeignKeyViolation},
-		{8, o.RandomString(misc.MaxLenB, 0, 0), 1, []int{}, misc.NoTags},
-		{5, o.RandomString(misc.MaxLenB, 0, 0), 1, []int{1, 3, 3}, misc.WrongTags},
-		{1, o.RandomString(misc.MaxLenB, 0, 0), 1, []int{1, 3, 9}, misc.WrongTags},
-		{2, o.RandomString(misc.MaxLenB, 0, 0), 1, []int{1, 3, 2, 5, 1}, misc.WrongTagsNum},
-		{3, o.RandomString(misc.MaxLenB, 0, 0), 9, []int{1, 3}, misc.DbForeignKeyViolation},
-		{3, o.RandomString(misc.MaxLenB, 1, 0), 2, []int{1, 3}, misc.WrongDescr},
-		{3, o.RandomString(misc.MaxLenB, 1, 1), 2, []int{1, 3}, misc.WrongDescr},
+		{19, 0, o.RandomString(misc.MaxLenB, 0, 0), img, 1, []int{4}, misc.WrongUpload},
+		{8, 8, o.RandomString(misc.MaxLenB, 0, 0), img, 1, []int{}, misc.NoTags},
+		{5, 5, o.RandomString(misc.MaxLenB, 0, 0), img, 1, []int{1, 3, 3}, misc.WrongTags},
+		{1, 1, o.RandomString(misc.MaxLenB, 0, 0), img, 1, []int{1, 3, 9}, misc.WrongTags},
+		{2, 2, o.RandomString(misc.MaxLenB, 0, 0), img, 1, []int{1, 3, 2, 5, 1}, misc.WrongTagsNum},
+		{3, 3, o.RandomString(misc.MaxLenB, 0, 0), img, 9, []int{1, 3}, misc.DbForeignKeyViolation},
+		{3, 3, o.RandomString(misc.MaxLenB, 1, 0), img, 2, []int{1, 3}, misc.WrongDescr},
+		{3, 3, o.RandomString(misc.MaxLenB, 1, 1), img, 2, []int{1, 3}, misc.WrongDescr},
+		{3, 3, o.RandomString(misc.MaxLenB, 0, 0), "1467954439_missing.jpg", 2, []int{1, 3}, misc.WrongImg},
+		{3, 2, o.RandomString(misc.MaxLenB, 0, 0), img, 2, []int{1, 3}, misc.WrongUpload},
 	}
 	for num, v := range tableFail {
-		id, code := Create(v.userId, v.descr, v.brandId, v.tagIds)
+		if v.uploader != 0 {
+			o.GiveUpload(v.uploader, misc.UploadPurchase, img)
+		}
+
+		id, code := Create(v.userId, v.descr, v.image, v.brandId, v.tagIds)
 		if id != 0 || code != v.code {
 			t.Errorf("Case %v. Expect failing. Got %v", num, code)
 		}
 	}
 }
+
+func TestCreateUsesUploadOnce(t *testing.T) {
+	o.CleanUpDb()
+
+	img, descr := "1467954439_isForTests.jpg", o.RandomString(misc.MaxLenB, 0, 0)
+	o.GiveUpload(3, misc.UploadAvatar, img)
+	if _, code := Create(3, descr, img, 1, []int{2}); code != misc.WrongUpload {
+		t.Errorf("Expect an avatar not to be used in a purchase. Got %v", code)
+	}
+
+	o.GiveUpload(3, misc.UploadPurchase, img)
+	psql.Db.Exec(`
+		UPDATE uploads
+		SET created_at = created_at - $1 * interval '1 day' - interval '1 hour'`, misc.UploadTtl)
+	if _, code := Create(3, descr, img, 1, []int{2}); code != misc.WrongUpload {
+		t.Errorf("Expect an old upload not to be used. Got %v", code)
+	}
+
+	o.GiveUpload(3, misc.UploadPurchase, img)
+	if _, code := Create(3, descr, img, 1, []int{2}); code != misc.NothingToReport {
+		t.Errorf("Expect a fresh upload to be used. Got %v", code)
+	}
+
+	if _, code := Create(3, descr, img, 1, []int{2}); code != misc.WrongUpload {
+		t.Errorf("Expect an upload to be used only once. Got %v", code)
+	}
+}
@@ -0,0 +1,338 @@
+package question
+
+import (
+	"../../misc"
+	"../../psql"
+	"database/sql"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// possible orders of questions and answers
+const (
+	SortByTime  = "time"  // newest first
+	SortByVotes = "votes" // most voted first, newest first among equally voted
+)
+
+// orderBy converts an order requested by a client into an ORDER BY clause for a table aliased as t
+func orderBy(sort string) (string, bool) {
+	switch sort {
+	case SortByTime, "":
+		return "t.issued_at DESC, t.id DESC", true
+	case SortByVotes:
+		return "t.votes_num DESC, t.issued_at DESC, t.id DESC", true
+	}
+
+	return "", false
+}
+
+// getQuestions extracts questions together with information about people who asked them
+func getQuestions(rows *sql.Rows, err error) ([]*misc.Question, int) {
+	if err != nil {
+		log.Println(err)
+		return []*misc.Question{}, misc.NothingToReport
+	}
+	defer rows.Close()
+
+	questions := []*misc.Question{}
+	var timestamp time.Time
+	for rows.Next() {
+		q, u := misc.Question{Answers: []*misc.Answer{}}, misc.User{}
+		if err := rows.Scan(&q.Id, &q.Name, &q.Purchase_id, &q.Votes_num, &timestamp, &u.Id, &u.Nickname, &u.Image); err != nil {
+			log.Println(err)
+			return []*misc.Question{}, misc.NothingToReport
+		}
+		q.User, q.Issued_at = &u, timestamp.Unix()
+		questions = append(questions, &q)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Println(err)
+		return []*misc.Question{}, misc.NothingToReport
+	}
+
+	return questions, misc.NothingToReport
+}
+
+// getAnswers extracts answers together with information about people who wrote them
+func getAnswers(rows *sql.Rows, err error) ([]*misc.Answer, int) {
+	if err != nil {
+		log.Println(err)
+		return []*misc.Answer{}, misc.NothingToReport
+	}
+	defer rows.Close()
+
+	answers := []*misc.Answer{}
+	var timestamp time.Time
+	for rows.Next() {
+		a, u := misc.Answer{}, misc.User{}
+		if err := rows.Scan(&a.Id, &a.Name, &a.Question_id, &a.Votes_num, &timestamp, &u.Id, &u.Nickname, &u.Image); err != nil {
+			log.Println(err)
+			return []*misc.Answer{}, misc.NothingToReport
+		}
+		a.User, a.Issued_at = &u, timestamp.Unix()
+		answers = append(answers, &a)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Println(err)
+		return []*misc.Answer{}, misc.NothingToReport
+	}
+
+	return answers, misc.NothingToReport
+}
+
+// showQuestions returns questions which satisfy a condition with all their answers. Questions and
+// answers for every question are sorted in the same order
+func showQuestions(sort, condition string, args ...interface{}) ([]*misc.Question, int) {
+	order, ok := orderBy(sort)
+	if !ok {
+		log.Println("Wrong sort order", sort)
+		return []*misc.Question{}, misc.WrongSort
+	}
+
+	rows, err := psql.Db.Query(fmt.Sprintf(`
+		SELECT t.id, t.name, t.purchase_id, t.votes_num, t.issued_at, u.id, u.nickname, u.image
+		FROM questions t
+		JOIN users u ON u.id = t.user_id
+		WHERE %s
+		ORDER BY %s`, condition, order), args...)
+	questions, code := getQuestions(rows, err)
+	if len(questions) == 0 {
+		return questions, code
+	}
+
+	questionIds, byId := make([]int, len(questions)), map[int]*misc.Question{}
+	for i, q := range questions {
+		questionIds[i], byId[q.Id] = q.Id, q
+	}
+
+	rows, err = psql.Db.Query(fmt.Sprintf(`
+		SELECT t.id, t.name, t.question_id, t.votes_num, t.issued_at, u.id, u.nickname, u.image
+		FROM answers t
+		JOIN users u ON u.id = t.user_id
+		WHERE t.question_id = ANY ($1::int[])
+		ORDER BY %s`, order), psql.FormatIntArray(questionIds))
+	answers, code := getAnswers(rows, err)
+	for _, a := range answers {
+		byId[a.Question_id].Answers = append(byId[a.Question_id].Answers, a)
+	}
+
+	return questions, code
+}
+
+// ShowById returns a question with Id and all its answers
+func ShowById(questionId int) (misc.Question, int) {
+	if !misc.IsIdValid(questionId) {
+		log.Println("Question ID is wrong", questionId)
+		return misc.Question{}, misc.NoQuestion
+	}
+
+	questions, code := showQuestions(SortByVotes, "t.id = $1", questionId)
+	if len(questions) == 0 {
+		log.Println("Question does not exist", questionId)
+		return misc.Question{}, misc.NoQuestion
+	}
+
+	return *questions[0], code
+}
+
+// ShowByPurchaseId returns all questions about a purchase with all their answers
+func ShowByPurchaseId(purchaseId int, sort string) ([]*misc.Question, int) {
+	if !misc.IsIdValid(purchaseId) {
+		log.Println("Purchase ID is wrong", purchaseId)
+		return []*misc.Question{}, misc.NothingToReport
+	}
+
+	return showQuestions(sort, "t.purchase_id = $1", purchaseId)
+}
+
+// ShowByUserId returns all questions asked by a user with all their answers
+func ShowByUserId(userId int, sort string) ([]*misc.Question, int) {
+	if !misc.IsIdValid(userId) {
+		log.Println("User ID is wrong", userId)
+		return []*misc.Question{}, misc.NothingToReport
+	}
+
+	return showQuestions(sort, "t.user_id = $1", userId)
+}
+
+// ShowAnswersByUserId returns all answers written by a user
+func ShowAnswersByUserId(userId int, sort string) ([]*misc.Answer, int) {
+	order, ok := orderBy(sort)
+	if !ok {
+		log.Println("Wrong sort order", sort)
+		return []*misc.Answer{}, misc.WrongSort
+	}
+
+	if !misc.IsIdValid(userId) {
+		log.Println("User ID is wrong", userId)
+		return []*misc.Answer{}, misc.NothingToReport
+	}
+
+	rows, err := psql.Db.Query(fmt.Sprintf(`
+		SELECT t.id, t.name, t.question_id, t.votes_num, t.issued_at, u.id, u.nickname, u.image
+		FROM answers t
+		JOIN users u ON u.id = t.user_id
+		WHERE t.user_id = $1
+		ORDER BY %s`, order), userId)
+
+	return getAnswers(rows, err)
+}
+
+// getAuthor returns an id of a user who created an element with id in a table (questions/answers)
+func getAuthor(table string, id int, codeMissing int) (int, int) {
+	if !misc.IsIdValid(id) {
+		log.Println("Id is wrong", table, id)
+		return 0, codeMissing
+	}
+
+	authorId := 0
+	if err := psql.Db.QueryRow(fmt.Sprintf(`
+		SELECT user_id
+		FROM %s
+		WHERE id = $1`, table), id,
+	).Scan(&authorId); err != nil {
+		if err == sql.ErrNoRows {
+			log.Println(err)
+			return 0, codeMissing
+		}
+
+		log.Println(err)
+		return 0, misc.NothingToReport
+	}
+
+	return authorId, misc.NothingToReport
+}
+
+// castVote stores a vote of a user in a votes table (votes_questions/votes_answers). It returns how
+// much the number of votes of the element has changed: 1 for a new vote and 2 if a user changed
+// the direction of a previous vote (negative for downvotes). Voting twice in the same direction is
+// a duplicate. An error of the database is returned as it is, so a transaction can be retried
+func castVote(tx *sql.Tx, table, column string, id, userId int, isUp bool) (int, error, int) {
+	delta := 1
+	if !isUp {
+		delta = -1
+	}
+
+	wasUp := false
+	err := tx.QueryRow(fmt.Sprintf(`
+		SELECT is_voting_up
+		FROM %s
+		WHERE %s = $1 AND user_id = $2
+		FOR UPDATE`, table, column), id, userId,
+	).Scan(&wasUp)
+	if err != nil && err != sql.ErrNoRows {
+		log.Println(err)
+		return 0, err, misc.NothingToReport
+	}
+
+	var sqlResult sql.Result
+	if err == sql.ErrNoRows {
+		sqlResult, err = tx.Exec(fmt.Sprintf(`
+			INSERT INTO %s (%s, user_id, is_voting_up)
+			VALUES ($1, $2, $3)`, table, column), id, userId, isUp)
+	} else if wasUp == isUp {
+		log.Println("Already voted", table, id, userId)
+		return 0, errors.New("already voted"), misc.DbDuplicate
+	} else {
+		delta *= 2
+		sqlResult, err = tx.Exec(fmt.Sprintf(`
+			UPDATE %s
+			SET is_voting_up = $3, issued_at = (now() at time zone 'utc')
+			WHERE %s = $1 AND user_id = $2`, table, column), id, userId, isUp)
+	}
+
+	if err, code := psql.CheckSpecificDriverErrors(err); err != nil {
+		log.Println(err)
+		return 0, err, code
+	}
+
+	if err, code := psql.IsAffectedOneRow(sqlResult); err != nil {
+		return 0, err, code
+	}
+
+	return delta, nil, misc.NothingToReport
+}
+
+// VoteQuestion votes a question up or down. A user can change the direction of his vote
+func VoteQuestion(questionId, userId int, isUp bool) int {
+	authorId, code := getAuthor("questions", questionId, misc.NoQuestion)
+	if authorId == 0 {
+		return code
+	}
+
+	if authorId == userId {
+		log.Println("can't vote for own question")
+		return misc.VoteForYourself
+	}
+
+	_, code = psql.Transaction(func(tx *sql.Tx) (error, int) {
+		delta, err, code := castVote(tx, "votes_questions", "question_id", questionId, userId, isUp)
+		if err != nil {
+			return err, code
+		}
+
+		sqlResult, err := tx.Exec(`
+			UPDATE questions
+			SET votes_num = votes_num + $1
+			WHERE id = $2`, delta, questionId)
+		if err, code := psql.CheckSpecificDriverErrors(err); err != nil {
+			log.Println(err)
+			return err, code
+		}
+
+		return psql.IsAffectedOneRow(sqlResult)
+	})
+
+	return code
+}
+
+// VoteAnswer votes an answer up or down. A user can change the direction of his vote.
+// The expertise of the author of the answer changes together with the votes of the answer
+func VoteAnswer(answerId, userId int, isUp bool) int {
+	authorId, code := getAuthor("answers", answerId, misc.NoAnswer)
+	if authorId == 0 {
+		return code
+	}
+
+	if authorId == userId {
+		log.Println("can't vote for own answer")
+		return misc.VoteForYourself
+	}
+
+	_, code = psql.Transaction(func(tx *sql.Tx) (error, int) {
+		delta, err, code := castVote(tx, "votes_answers", "answer_id", answerId, userId, isUp)
+		if err != nil {
+			return err, code
+		}
+
+		sqlResult, err := tx.Exec(`
+			UPDATE answers
+			SET votes_num = votes_num + $1
+			WHERE id = $2`, delta, answerId)
+		if err, code := psql.CheckSpecificDriverErrors(err); err != nil {
+			log.Println(err)
+			return err, code
+		}
+
+		if err, code := psql.IsAffectedOneRow(sqlResult); err != nil {
+			return err, code
+		}
+
+		sqlResult, err = tx.Exec(`
+			UPDATE users
+			SET expertise = expertise + $1
+			WHERE id = $2`, delta, authorId)
+		if err, code := psql.CheckSpecificDriverErrors(err); err != nil {
+			log.Println(err)
+			return err, code
+		}
+
+		return psql.IsAffectedOneRow(sqlResult)
+	})
+
+	return code
+}
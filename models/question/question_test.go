@@ -0,0 +1,223 @@
+package question
+
+import (
+	"../../misc"
+	"../../psql"
+	o "../testHelpers"
+	"io/ioutil"
+	"log"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// Setup and db.close will be called before and after each test http://stackoverflow.com/a/34102842/1090562
+func TestMain(m *testing.M) {
+	o.InitAll()
+	log.SetOutput(ioutil.Discard)
+	retCode := m.Run()
+
+	defer psql.Db.Close()
+	o.CleanUpDb()
+	os.Exit(retCode)
+}
+
+// getVotes returns the number of votes of a question/answer and the expertise of its author
+func getVotes(table string, id int) (int, int) {
+	votes, expertise := 0, 0
+	psql.Db.QueryRow(`
+		SELECT t.votes_num, u.expertise
+		FROM `+table+` t, users u
+		WHERE t.id = $1 AND u.id = t.user_id`, id,
+	).Scan(&votes, &expertise)
+	return votes, expertise
+}
+
+// questionIds returns ids of the questions and ids of answers for every question
+func questionIds(questions []*misc.Question) ([]int, [][]int) {
+	ids, answerIds := []int{}, [][]int{}
+	for _, q := range questions {
+		ids = append(ids, q.Id)
+		answers := []int{}
+		for _, a := range q.Answers {
+			answers = append(answers, a.Id)
+		}
+		answerIds = append(answerIds, answers)
+	}
+	return ids, answerIds
+}
+
+func TestShowById(t *testing.T) {
+	o.CleanUpDb()
+
+	q, code := ShowById(2)
+	if code != misc.NothingToReport || q.Id != 2 || q.Purchase_id != 1 || q.Votes_num != 0 ||
+		q.Name != "What is the maximum distance from the transmitter?" || q.Issued_at == 0 {
+		t.Errorf("Expect question 2. Got %v, %v", q, code)
+	}
+
+	if q.User == nil || q.User.Id != 7 || q.User.Nickname != "Stephen Hawking" || q.User.Image != "1467954473_isForTests.jpg" {
+		t.Errorf("Expect Stephen Hawking. Got %v", q.User)
+	}
+
+	if len(q.Answers) != 1 || q.Answers[0].Id != 1 || q.Answers[0].Question_id != 2 || q.Answers[0].User.Id != 1 {
+		t.Errorf("Expect answer 1 by user 1. Got %v", q.Answers)
+	}
+
+	q, code = ShowById(1)
+	if code != misc.NothingToReport || q.Id != 1 || len(q.Answers) != 0 {
+		t.Errorf("Expect question 1 without answers. Got %v, %v", q, code)
+	}
+
+	for num, v := range []int{0, -1, 3, 43} {
+		if q, code := ShowById(v); code != misc.NoQuestion || q.Id != 0 {
+			t.Errorf("Case %v. Expect %v. Got %v, %v", num, misc.NoQuestion, q, code)
+		}
+	}
+}
+
+func TestShowByPurchaseId(t *testing.T) {
+	o.CleanUpDb()
+
+	table := []struct {
+		purchaseId  int
+		questionIds []int
+		answerIds   [][]int
+	}{
+		{1, []int{2}, [][]int{{1}}},
+		{4, []int{1}, [][]int{{}}},
+		{2, []int{}, [][]int{}},
+		{-1, []int{}, [][]int{}},
+		{43, []int{}, [][]int{}},
+	}
+	for num, v := range table {
+		questions, code := ShowByPurchaseId(v.purchaseId, SortByTime)
+		ids, answerIds := questionIds(questions)
+		if code != misc.NothingToReport || !reflect.DeepEqual(ids, v.questionIds) || !reflect.DeepEqual(answerIds, v.answerIds) {
+			t.Errorf("Case %v. Expect %v %v. Got %v %v %v", num, v.questionIds, v.answerIds, ids, answerIds, code)
+		}
+	}
+
+	if _, code := ShowByPurchaseId(1, "likes"); code != misc.WrongSort {
+		t.Errorf("Expect %v. Got %v", misc.WrongSort, code)
+	}
+}
+
+func TestShowByUserId(t *testing.T) {
+	o.CleanUpDb()
+
+	table := []struct {
+		userId      int
+		sort        string
+		questionIds []int
+	}{
+		{7, SortByTime, []int{2, 1}},
+		{7, "", []int{2, 1}},
+		{7, SortByVotes, []int{2, 1}},
+		{1, SortByTime, []int{}},
+		{-1, SortByTime, []int{}},
+	}
+	for num, v := range table {
+		questions, code := ShowByUserId(v.userId, v.sort)
+		if ids, _ := questionIds(questions); code != misc.NothingToReport || !reflect.DeepEqual(ids, v.questionIds) {
+			t.Errorf("Case %v. Expect %v. Got %v, %v", num, v.questionIds, ids, code)
+		}
+	}
+
+	// the older question becomes first after it gets a vote
+	VoteQuestion(1, 2, true)
+	questions, _ := ShowByUserId(7, SortByVotes)
+	if ids, _ := questionIds(questions); !reflect.DeepEqual(ids, []int{1, 2}) || questions[0].Votes_num != 1 {
+		t.Errorf("Expect %v. Got %v", []int{1, 2}, ids)
+	}
+
+	if _, code := ShowByUserId(7, "random"); code != misc.WrongSort {
+		t.Errorf("Expect %v. Got %v", misc.WrongSort, code)
+	}
+}
+
+func TestShowAnswersByUserId(t *testing.T) {
+	o.CleanUpDb()
+
+	answers, code := ShowAnswersByUserId(1, SortByVotes)
+	if code != misc.NothingToReport || len(answers) != 1 || answers[0].Id != 1 || answers[0].Question_id != 2 ||
+		answers[0].User.Nickname != "Albert Einstein" {
+		t.Errorf("Expect answer 1. Got %v, %v", answers, code)
+	}
+
+	for num, v := range []int{7, 0, 43} {
+		if answers, code := ShowAnswersByUserId(v, SortByTime); code != misc.NothingToReport || len(answers) != 0 {
+			t.Errorf("Case %v. Expect no answers. Got %v, %v", num, answers, code)
+		}
+	}
+
+	if _, code := ShowAnswersByUserId(1, "random"); code != misc.WrongSort {
+		t.Errorf("Expect %v. Got %v", misc.WrongSort, code)
+	}
+}
+
+func TestVoteQuestion(t *testing.T) {
+	o.CleanUpDb()
+
+	table := []struct {
+		questionId int
+		userId     int
+		isUp       bool
+		code       int
+		votesNum   int
+	}{
+		{1, 7, true, misc.VoteForYourself, 0},
+		{1, 2, true, misc.NothingToReport, 1},
+		{1, 2, true, misc.DbDuplicate, 1},
+		{1, 3, true, misc.NothingToReport, 2},
+		{1, 2, false, misc.NothingToReport, 0},
+		{1, 2, false, misc.DbDuplicate, 0},
+		{1, 4, false, misc.NothingToReport, -1},
+		{1, 43, true, misc.DbForeignKeyViolation, -1},
+		{2, 1, false, misc.NothingToReport, -1},
+		{0, 1, true, misc.NoQuestion, 0},
+		{9, 1, true, misc.NoQuestion, 0},
+	}
+	for num, v := range table {
+		if code := VoteQuestion(v.questionId, v.userId, v.isUp); code != v.code {
+			t.Errorf("Case %v. Expect %v. Got %v", num, v.code, code)
+		}
+
+		if votes, _ := getVotes("questions", v.questionId); votes != v.votesNum {
+			t.Errorf("Case %v. Expect %v votes. Got %v", num, v.votesNum, votes)
+		}
+	}
+}
+
+func TestVoteAnswer(t *testing.T) {
+	o.CleanUpDb()
+
+	table := []struct {
+		answerId  int
+		userId    int
+		isUp      bool
+		code      int
+		votesNum  int
+		expertise int
+	}{
+		{1, 1, true, misc.VoteForYourself, 0, 0},
+		{1, 7, true, misc.NothingToReport, 1, 1},
+		{1, 7, true, misc.DbDuplicate, 1, 1},
+		{1, 2, true, misc.NothingToReport, 2, 2},
+		{1, 7, false, misc.NothingToReport, 0, 0},
+		{1, 3, false, misc.NothingToReport, -1, -1},
+		{1, 3, false, misc.DbDuplicate, -1, -1},
+		{1, 43, true, misc.DbForeignKeyViolation, -1, -1},
+		{0, 2, true, misc.NoAnswer, 0, 0},
+		{2, 2, true, misc.NoAnswer, 0, 0},
+	}
+	for num, v := range table {
+		if code := VoteAnswer(v.answerId, v.userId, v.isUp); code != v.code {
+			t.Errorf("Case %v. Expect %v. Got %v", num, v.code, code)
+		}
+
+		if votes, expertise := getVotes("answers", v.answerId); votes != v.votesNum || expertise != v.expertise {
+			t.Errorf("Case %v. Expect (%v, %v). Got (%v, %v)", num, v.votesNum, v.expertise, votes, expertise)
+		}
+	}
+}
@@ -0,0 +1,220 @@
+// Package session manages places where users are logged in. A session gives a client a short-lived
+// access JWT token and a long-lived opaque refresh token. Every refresh token can be exchanged for
+// new tokens only once: using it the second time means it was stolen, so the session is revoked
+package session
+
+import (
+	"../../auth"
+	"../../config"
+	"../../misc"
+	"../../psql"
+	"database/sql"
+	"errors"
+	"log"
+	"time"
+)
+
+// insertRefreshToken creates a new refresh token for a session
+func insertRefreshToken(tx *sql.Tx, sessionId int) (string, error, int) {
+	token, hash, err := auth.GenerateToken()
+	if err != nil {
+		log.Println(err)
+		return "", err, misc.NothingToReport
+	}
+
+	if _, err := tx.Exec(`
+		INSERT INTO refresh_tokens (session_id, token_hash)
+		VALUES ($1, $2)`, sessionId, hash,
+	); err != nil {
+		log.Println(err)
+		return "", err, misc.NothingToReport
+	}
+
+	return token, nil, misc.NothingToReport
+}
+
+// Create opens a new session for a user who has just logged in
+func Create(userId int, verified bool, client misc.Client) (misc.Tokens, int) {
+	sessionId, role, tokens := 0, "", misc.Tokens{}
+	if err, code := psql.Transaction(func(tx *sql.Tx) (error, int) {
+		if err := tx.QueryRow(`
+			INSERT INTO sessions (user_id, user_agent, ip, expires_at)
+			VALUES ($1, $2, $3, (now() at time zone 'utc') + $4 * interval '1 day')
+			RETURNING id, (SELECT role FROM users WHERE id = $1)`, userId, client.User_agent, client.Ip, config.Cfg.ExpDays,
+		).Scan(&sessionId, &role); err != nil {
+			err, code := psql.CheckSpecificDriverErrors(err)
+			log.Println(err)
+			return err, code
+		}
+
+		var err error
+		var code int
+		tokens.Refresh_token, err, code = insertRefreshToken(tx, sessionId)
+		return err, code
+	}); err != nil {
+		return misc.Tokens{}, code
+	}
+
+	jwt, err := auth.CreateJWT(userId, sessionId, verified, role)
+	if err != nil {
+		log.Println(err)
+		return misc.Tokens{}, misc.NothingToReport
+	}
+
+	tokens.Jwt = jwt
+	return tokens, misc.NothingToReport
+}
+
+// Refresh exchanges a refresh token for a new access token and a new refresh token. If an already
+// used refresh token is provided, the whole session is revoked. A new access token has the current
+// role of a user, so a changed role is applied after the next refresh
+func Refresh(refreshToken string, client misc.Client) (misc.Tokens, int) {
+	userId, sessionId, verified, role, tokens := 0, 0, false, "", misc.Tokens{}
+	err, code := psql.Transaction(func(tx *sql.Tx) (error, int) {
+		tokenId, isUsed, isActive := 0, false, false
+		if err := tx.QueryRow(`
+			SELECT t.id, t.used_at IS NOT NULL, s.id, s.user_id, u.verified, u.role,
+				s.revoked_at IS NULL AND s.expires_at > (now() at time zone 'utc')
+			FROM refresh_tokens t
+			JOIN sessions s ON s.id = t.session_id
+			JOIN users u ON u.id = s.user_id
+			WHERE t.token_hash = $1
+			FOR UPDATE OF t, s`, auth.HashToken(refreshToken),
+		).Scan(&tokenId, &isUsed, &sessionId, &userId, &verified, &role, &isActive); err != nil {
+			log.Println(err)
+			if err == sql.ErrNoRows {
+				return err, misc.WrongRefreshToken
+			}
+			return err, misc.NothingToReport
+		}
+
+		if isUsed {
+			// either a client or an attacker has a copy of the token. Nobody can use the session anymore
+			log.Println("Refresh token is reused. Revoking session", sessionId)
+			if _, err := tx.Exec(`
+				UPDATE sessions
+				SET revoked_at = (now() at time zone 'utc')
+				WHERE id = $1 AND revoked_at IS NULL`, sessionId,
+			); err != nil {
+				log.Println(err)
+				return err, misc.NothingToReport
+			}
+
+			// commit the revocation, but do not give any tokens
+			return nil, misc.WrongRefreshToken
+		}
+
+		if !isActive {
+			log.Println("Session is not active", sessionId)
+			return errors.New("Session is not active"), misc.WrongRefreshToken
+		}
+
+		if _, err := tx.Exec(`
+			UPDATE refresh_tokens
+			SET used_at = (now() at time zone 'utc')
+			WHERE id = $1`, tokenId,
+		); err != nil {
+			log.Println(err)
+			return err, misc.NothingToReport
+		}
+
+		if _, err := tx.Exec(`
+			UPDATE sessions
+			SET last_used_at = (now() at time zone 'utc'), user_agent = $1, ip = $2
+			WHERE id = $3`, client.User_agent, client.Ip, sessionId,
+		); err != nil {
+			log.Println(err)
+			return err, misc.NothingToReport
+		}
+
+		var err error
+		var code int
+		tokens.Refresh_token, err, code = insertRefreshToken(tx, sessionId)
+		return err, code
+	})
+	if err != nil || code != misc.NothingToReport {
+		return misc.Tokens{}, code
+	}
+
+	jwt, err := auth.CreateJWT(userId, sessionId, verified, role)
+	if err != nil {
+		log.Println(err)
+		return misc.Tokens{}, misc.NothingToReport
+	}
+
+	tokens.Jwt = jwt
+	return tokens, misc.NothingToReport
+}
+
+// Revoke ends a session of a user. Access tokens of the session stop working immediately
+func Revoke(sessionId, userId int) int {
+	if !misc.IsIdValid(sessionId) {
+		log.Println("Session id is not correct", sessionId)
+		return misc.NoElement
+	}
+
+	sqlResult, err := psql.Db.Exec(`
+		UPDATE sessions
+		SET revoked_at = (now() at time zone 'utc')
+		WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL`, sessionId, userId)
+	if err, code := psql.CheckSpecificDriverErrors(err); err != nil {
+		log.Println(err)
+		return code
+	}
+
+	if err, code := psql.IsAffectedOneRow(sqlResult); err != nil {
+		return code
+	}
+
+	return misc.NothingToReport
+}
+
+// ShowByUserId returns all active sessions of a user. The session with currentId is marked
+func ShowByUserId(userId, currentId int) ([]*misc.Session, int) {
+	rows, err := psql.Db.Query(`
+		SELECT id, user_agent, ip, issued_at, last_used_at
+		FROM sessions
+		WHERE user_id = $1 AND revoked_at IS NULL AND expires_at > (now() at time zone 'utc')
+		ORDER BY last_used_at DESC, id DESC`, userId)
+	if err != nil {
+		log.Println(err)
+		return []*misc.Session{}, misc.NothingToReport
+	}
+	defer rows.Close()
+
+	sessions := []*misc.Session{}
+	for rows.Next() {
+		s := misc.Session{}
+		var issuedAt, lastUsedAt time.Time
+		if err := rows.Scan(&s.Id, &s.User_agent, &s.Ip, &issuedAt, &lastUsedAt); err != nil {
+			log.Println(err)
+			return []*misc.Session{}, misc.NothingToReport
+		}
+		s.Issued_at, s.Last_used_at, s.Is_current = issuedAt.Unix(), lastUsedAt.Unix(), s.Id == currentId
+		sessions = append(sessions, &s)
+	}
+
+	if err = rows.Err(); err != nil {
+		log.Println(err)
+		return []*misc.Session{}, misc.NothingToReport
+	}
+
+	return sessions, misc.NothingToReport
+}
+
+// IsActive checks that a session of a user was not revoked and has not expired
+func IsActive(sessionId, userId int) bool {
+	isActive := false
+	if err := psql.Db.QueryRow(`
+		SELECT EXISTS(
+			SELECT 1
+			FROM sessions
+			WHERE id = $1 AND user_id = $2 AND revoked_at IS NULL AND expires_at > (now() at time zone 'utc')
+		)`, sessionId, userId,
+	).Scan(&isActive); err != nil {
+		log.Println(err)
+		return false
+	}
+
+	return isActive
+}
@@ -0,0 +1,156 @@
+package session
+
+import (
+	"../../auth"
+	"../../misc"
+	"../../psql"
+	o "../testHelpers"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+)
+
+// Setup and db.close will be called before and after each test http://stackoverflow.com/a/34102842/1090562
+func TestMain(m *testing.M) {
+	o.InitAll()
+	log.SetOutput(ioutil.Discard)
+	retCode := m.Run()
+
+	defer psql.Db.Close()
+	o.CleanUpDb()
+	os.Exit(retCode)
+}
+
+// getSessionId extracts the session of an access token
+func getSessionId(t *testing.T, tokens misc.Tokens) int {
+	token, err := auth.ValidateJWT(tokens.Jwt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token.SessionId
+}
+
+func TestCreate(t *testing.T) {
+	o.CleanUpDb()
+
+	table := []struct {
+		userId   int
+		verified bool
+	}{
+		{1, true},
+		{2, true},
+		{1, false},
+	}
+	for num, v := range table {
+		tokens, code := Create(v.userId, v.verified, misc.Client{"curl", "127.0.0.1"})
+		if code != misc.NothingToReport || len(tokens.Refresh_token) < 40 {
+			t.Errorf("Case %v. Expect a session. Got %v, %v", num, tokens, code)
+		}
+
+		token, err := auth.ValidateJWT(tokens.Jwt)
+		if err != nil || token.UserId != v.userId || token.Verified != v.verified || !IsActive(token.SessionId, v.userId) {
+			t.Errorf("Case %v. Expect an active session. Got %v, %v", num, token, err)
+		}
+	}
+
+	if tokens, code := Create(100, true, misc.Client{}); code != misc.DbForeignKeyViolation || tokens.Jwt != "" {
+		t.Errorf("Expect no session for a missing user. Got %v, %v", tokens, code)
+	}
+}
+
+func TestRefresh(t *testing.T) {
+	o.CleanUpDb()
+
+	first, _ := Create(1, true, misc.Client{})
+	sessionId := getSessionId(t, first)
+
+	second, code := Refresh(first.Refresh_token, misc.Client{"firefox", "10.0.0.1"})
+	if code != misc.NothingToReport || second.Refresh_token == first.Refresh_token || getSessionId(t, second) != sessionId {
+		t.Errorf("Expect new tokens of the same session. Got %v, %v", second, code)
+	}
+
+	third, code := Refresh(second.Refresh_token, misc.Client{})
+	if code != misc.NothingToReport || getSessionId(t, third) != sessionId {
+		t.Errorf("Expect new tokens of the same session. Got %v, %v", third, code)
+	}
+
+	if tokens, code := Refresh("unknown_token", misc.Client{}); code != misc.WrongRefreshToken || tokens.Jwt != "" {
+		t.Errorf("Expect unknown token to fail. Got %v, %v", tokens, code)
+	}
+
+	// the first token is reused, so it is stolen. Even the latest token should not work anymore
+	if tokens, code := Refresh(first.Refresh_token, misc.Client{}); code != misc.WrongRefreshToken || tokens.Jwt != "" {
+		t.Errorf("Expect reused token to fail. Got %v, %v", tokens, code)
+	}
+
+	if IsActive(sessionId, 1) {
+		t.Error("Expect session to be revoked after a token is reused")
+	}
+
+	if tokens, code := Refresh(third.Refresh_token, misc.Client{}); code != misc.WrongRefreshToken || tokens.Jwt != "" {
+		t.Errorf("Expect token of revoked session to fail. Got %v, %v", tokens, code)
+	}
+}
+
+func TestRevoke(t *testing.T) {
+	o.CleanUpDb()
+
+	tokens, _ := Create(1, true, misc.Client{})
+	sessionId := getSessionId(t, tokens)
+
+	table := []struct {
+		sessionId int
+		userId    int
+		code      int
+	}{
+		{0, 1, misc.NoElement},
+		{sessionId, 2, misc.NothingUpdated},
+		{sessionId + 100, 1, misc.NothingUpdated},
+		{sessionId, 1, misc.NothingToReport},
+		{sessionId, 1, misc.NothingUpdated},
+	}
+	for num, v := range table {
+		if code := Revoke(v.sessionId, v.userId); code != v.code {
+			t.Errorf("Case %v. Expect %v. Got %v", num, v.code, code)
+		}
+	}
+
+	if IsActive(sessionId, 1) {
+		t.Error("Expect session to be revoked")
+	}
+
+	if _, code := Refresh(tokens.Refresh_token, misc.Client{}); code != misc.WrongRefreshToken {
+		t.Errorf("Expect refresh to fail for revoked session. Got %v", code)
+	}
+}
+
+func TestShowByUserId(t *testing.T) {
+	o.CleanUpDb()
+
+	ids := []int{}
+	for _, agent := range []string{"curl", "firefox", "chrome"} {
+		tokens, _ := Create(1, true, misc.Client{agent, "127.0.0.1"})
+		ids = append(ids, getSessionId(t, tokens))
+	}
+	Create(2, true, misc.Client{})
+	Revoke(ids[1], 1)
+
+	sessions, code := ShowByUserId(1, ids[0])
+	if code != misc.NothingToReport || len(sessions) != 2 {
+		t.Fatalf("Expect 2 sessions. Got %v, %v", len(sessions), code)
+	}
+
+	// the latest session is the first one
+	if sessions[0].Id != ids[2] || sessions[0].User_agent != "chrome" || sessions[0].Is_current {
+		t.Errorf("Expect not current session %v. Got %v", ids[2], sessions[0])
+	}
+
+	if sessions[1].Id != ids[0] || sessions[1].Ip != "127.0.0.1" || !sessions[1].Is_current {
+		t.Errorf("Expect current session %v. Got %v", ids[0], sessions[1])
+	}
+
+	if sessions, _ := ShowByUserId(5, 0); len(sessions) != 0 {
+		t.Errorf("Expect no sessions. Got %v", sessions)
+	}
+}
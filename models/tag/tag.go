@@ -154,7 +154,7 @@ func ValidateTags(tagIds []int) (error, int) {
 
 	num := 0
 	if err := psql.Db.QueryRow(buf.String()).Scan(&num); err != nil {
-		return err, misc.NothingToReport
+		return err, misc.DbError
 	}
 
 	if num != len(tagIds) {
@@ -2,18 +2,28 @@
 package testHelpers
 
 import (
+	"../../auth"
 	"../../config"
+	"../../imager"
 	"../../mailer"
+	"../../migrate"
 	"../../misc"
 	"../../psql"
+	"io/ioutil"
 	"log"
 	"math/rand"
-	"os"
-	"os/exec"
+	"path/filepath"
+	"runtime"
 	"sort"
 	"time"
 )
 
+// root is the directory of the project, so the helpers work from test packages of any depth
+var root = func() string {
+	_, file, _, _ := runtime.Caller(0)
+	return filepath.Join(filepath.Dir(file), "..", "..")
+}()
+
 const (
 	letterBytes = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
 )
@@ -103,16 +113,73 @@ func IsApproximatelyNow(createdTime int64) bool {
 func InitAll() {
 	// initialize Db connection
 	config.Init()
+	auth.Init()
+
+	// tests never send real emails, they read them with LastEmail
+	config.Cfg.MailTransport, config.Cfg.MailTemplates = mailer.TransportMemory, filepath.Join(root, "mailer/templates")
 	mailer.Init()
+
+	// images of tests are in the repository
+	config.Cfg.Storage, config.Cfg.StorageDir = imager.StorageLocal, filepath.Join(root, "images")
+	imager.Init()
 	psql.Init()
 }
 
+// LastEmail returns the last email sent to an address during tests. Emails waiting in the outbox
+// are delivered first
+func LastEmail(to string) (mailer.Message, bool) {
+	mailer.Deliver(psql.Db)
+	return mailer.Sender.(*mailer.MemoryTransport).Last(to)
+}
+
 func CleanUpDb() {
-	// prepare database by creating tables and populating it with data
-	cmd := exec.Command("../../SQL/set_up_database.py")
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	if cmd.Run() != nil {
-		log.Fatal("Can't prepare SQL database")
+	// a database created before migrations has tables, but no schema_migrations. Such a schema is
+	// recreated once, so migrations start from scratch
+	isMigrated := false
+	if err := psql.Db.QueryRow(`
+		SELECT EXISTS (
+			SELECT 1
+			FROM information_schema.tables
+			WHERE table_schema = 'public' AND table_name = 'schema_migrations'
+		)`,
+	).Scan(&isMigrated); err != nil {
+		log.Fatal("Can't check migrations: ", err)
+	}
+
+	if !isMigrated {
+		if _, err := psql.Db.Exec(`DROP SCHEMA public CASCADE; CREATE SCHEMA public;`); err != nil {
+			log.Fatal("Can't recreate a schema: ", err)
+		}
+	}
+
+	// prepare database by recreating tables with migrations and populating it with data
+	if _, err := migrate.Down(psql.Db, filepath.Join(root, "SQL/migrations"), migrate.All); err != nil {
+		log.Fatal("Can't revert migrations: ", err)
+	}
+
+	if _, err := migrate.Up(psql.Db, filepath.Join(root, "SQL/migrations"), migrate.All); err != nil {
+		log.Fatal("Can't apply migrations: ", err)
+	}
+
+	data, err := ioutil.ReadFile(filepath.Join(root, "SQL/populate.sql"))
+	if err != nil {
+		log.Fatal("Can't read test data: ", err)
+	}
+
+	if _, err := psql.Db.Exec(string(data)); err != nil {
+		log.Fatal("Can't populate SQL database: ", err)
+	}
+}
+
+// GiveUpload makes an image of the tests a fresh unused upload of a user, so the user can set it
+// as an avatar or use it in a purchase
+func GiveUpload(userId int, kind, name string) {
+	if _, err := psql.Db.Exec(`
+		INSERT INTO uploads (name, user_id, kind, width, height, size, hash)
+		VALUES ($1, $2, $3, 1, 1, 1, '')
+		ON CONFLICT (name) DO UPDATE
+		SET user_id = $2, kind = $3, created_at = (now() at time zone 'utc'), consumed_at = NULL`, name, userId, kind,
+	); err != nil {
+		log.Fatal("Can't give an upload: ", err)
 	}
 }
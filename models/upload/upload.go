@@ -0,0 +1,56 @@
+// Package upload keeps track of images uploaded by users, so an image can be used only by its owner,
+// only for what it was uploaded and only once
+package upload
+
+import (
+	"../../misc"
+	"../../psql"
+	"database/sql"
+	"errors"
+	"log"
+)
+
+// Create remembers that a user has uploaded an image
+func Create(userId int, u misc.Upload) int {
+	if u.Kind != misc.UploadAvatar && u.Kind != misc.UploadPurchase {
+		log.Println("Wrong kind of an upload", u.Kind)
+		return misc.WrongKind
+	}
+
+	_, err := psql.Db.Exec(`
+		INSERT INTO uploads (name, user_id, kind, width, height, size, hash)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`, u.Name, userId, u.Kind, u.Width, u.Height, u.Size, u.Hash)
+	if err != nil {
+		err, code := psql.CheckSpecificDriverErrors(err)
+		log.Println(err)
+		if code == misc.NothingToReport {
+			// the image can't be used without the row, so a client should not get its name
+			return misc.DbError
+		}
+		return code
+	}
+
+	return misc.NothingToReport
+}
+
+// Consume marks an image as used inside of a transaction which uses it. Succeeds only if the user
+// has uploaded the image for this kind of use not longer than misc.UploadTtl days ago and has not
+// used it yet
+func Consume(tx *sql.Tx, userId int, kind, name string) (error, int) {
+	sqlResult, err := tx.Exec(`
+		UPDATE uploads
+		SET consumed_at = (now() at time zone 'utc')
+		WHERE name = $1 AND user_id = $2 AND kind = $3 AND consumed_at IS NULL
+			AND created_at >= (now() at time zone 'utc') - $4 * interval '1 day'`, name, userId, kind, misc.UploadTtl)
+	if err != nil {
+		log.Println(err)
+		return err, misc.NothingToReport
+	}
+
+	if err, _ := psql.IsAffectedOneRow(sqlResult); err != nil {
+		log.Println("Upload can't be used", name, userId, kind)
+		return errors.New("upload can't be used"), misc.WrongUpload
+	}
+
+	return nil, misc.NothingToReport
+}
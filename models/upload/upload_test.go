@@ -0,0 +1,78 @@
+package upload
+
+import (
+	"../../misc"
+	"../../psql"
+	o "../testHelpers"
+	"database/sql"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+)
+
+// Setup and db.close will be called before and after each test http://stackoverflow.com/a/34102842/1090562
+func TestMain(m *testing.M) {
+	o.InitAll()
+	log.SetOutput(ioutil.Discard)
+	retCode := m.Run()
+
+	defer psql.Db.Close()
+	o.CleanUpDb()
+	os.Exit(retCode)
+}
+
+func TestCreate(t *testing.T) {
+	o.CleanUpDb()
+
+	table := []struct {
+		userId int
+		upload misc.Upload
+		code   int
+	}{
+		{1, misc.Upload{"1_a.jpg", misc.UploadAvatar, 300, 200, 1000, "ab"}, misc.NothingToReport},
+		{2, misc.Upload{"1_b.jpg", misc.UploadPurchase, 300, 200, 1000, "ab"}, misc.NothingToReport},
+		{2, misc.Upload{"1_a.jpg", misc.UploadPurchase, 300, 200, 1000, "ab"}, misc.DbDuplicate},
+		{2, misc.Upload{"1_c.jpg", "brand", 300, 200, 1000, "ab"}, misc.WrongKind},
+		{43, misc.Upload{"1_d.jpg", misc.UploadAvatar, 300, 200, 1000, "ab"}, misc.DbForeignKeyViolation},
+		{2, misc.Upload{"1_e.jpg", misc.UploadAvatar, 1 << 40, 200, 1000, "ab"}, misc.DbError}, // out of range of integer
+	}
+	for num, v := range table {
+		if code := Create(v.userId, v.upload); code != v.code {
+			t.Errorf("Case %v. Expect %v. Got %v", num, v.code, code)
+		}
+	}
+}
+
+func TestConsume(t *testing.T) {
+	o.CleanUpDb()
+
+	Create(1, misc.Upload{"1_a.jpg", misc.UploadAvatar, 300, 200, 1000, "ab"})
+	Create(1, misc.Upload{"1_old.jpg", misc.UploadAvatar, 300, 200, 1000, "ab"})
+	psql.Db.Exec(`
+		UPDATE uploads
+		SET created_at = created_at - $1 * interval '1 day' - interval '1 hour'
+		WHERE name = '1_old.jpg'`, misc.UploadTtl)
+
+	table := []struct {
+		userId int
+		kind   string
+		name   string
+		code   int
+	}{
+		{2, misc.UploadAvatar, "1_a.jpg", misc.WrongUpload},
+		{1, misc.UploadPurchase, "1_a.jpg", misc.WrongUpload},
+		{1, misc.UploadAvatar, "1_missing.jpg", misc.WrongUpload},
+		{1, misc.UploadAvatar, "1_old.jpg", misc.WrongUpload},
+		{1, misc.UploadAvatar, "1_a.jpg", misc.NothingToReport},
+		{1, misc.UploadAvatar, "1_a.jpg", misc.WrongUpload}, // already used
+	}
+	for num, v := range table {
+		_, code := psql.Transaction(func(tx *sql.Tx) (error, int) {
+			return Consume(tx, v.userId, v.kind, v.name)
+		})
+		if code != v.code {
+			t.Errorf("Case %v. Expect %v. Got %v", num, v.code, code)
+		}
+	}
+}
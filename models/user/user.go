@@ -6,9 +6,14 @@ import (
 	"../../mailer"
 	"../../misc"
 	"../../psql"
+	"../brand"
+	"../mfa"
+	"../session"
+	"../tag"
+	"../upload"
 	"database/sql"
+	"fmt"
 	"log"
-	"reflect"
 	"time"
 )
 
@@ -42,7 +47,7 @@ func ShowById(userId int) (misc.User, int) {
 	return user, misc.NothingToReport
 }
 
-// Update information about a user
+// Update information about a user. A new avatar has to be an unused upload of the user
 func Update(userId int, nickname, about, image string) int {
 	if !misc.IsIdValid(userId) {
 		log.Println("user was not updated", userId)
@@ -61,21 +66,63 @@ func Update(userId int, nickname, about, image string) int {
 		return misc.WrongDescr
 	}
 
-	if !imager.IsAvatarValid(image) {
+	// storage can be slow, so a new avatar is checked before the user is locked. An old avatar might be
+	// uploaded long time ago, only a new one has to be an own fresh upload
+	oldImage := ""
+	if err := psql.Db.QueryRow(`
+		SELECT image
+		FROM users
+		WHERE id = $1`, userId,
+	).Scan(&oldImage); err != nil {
+		if err == sql.ErrNoRows {
+			log.Println("user was not updated", userId)
+			return misc.NothingUpdated
+		}
+
+		log.Println(err)
+		return misc.NothingToReport
+	}
+
+	if image != "" && image != oldImage && !imager.IsAvatarValid(image) {
 		log.Println("Avatar is not valid", image)
 		return misc.WrongImg
 	}
 
-	sqlResult, err := psql.Db.Exec(`
-		UPDATE users
-		SET nickname = $1, about = $2, image = $3
-		WHERE id = $4`, nickname, about, image, userId)
-	if err, code := psql.CheckSpecificDriverErrors(err); err != nil {
-		log.Println(err)
-		return code
-	}
+	_, code := psql.Transaction(func(tx *sql.Tx) (error, int) {
+		oldImage := ""
+		if err := tx.QueryRow(`
+			SELECT image
+			FROM users
+			WHERE id = $1
+			FOR UPDATE`, userId,
+		).Scan(&oldImage); err != nil {
+			if err == sql.ErrNoRows {
+				log.Println("user was not updated", userId)
+				return err, misc.NothingUpdated
+			}
 
-	err, code := psql.IsAffectedOneRow(sqlResult)
+			log.Println(err)
+			return err, misc.NothingToReport
+		}
+
+		// the avatar could be changed after it was read, so the locked row decides whether it is new
+		if image != "" && image != oldImage {
+			if err, code := upload.Consume(tx, userId, misc.UploadAvatar, image); err != nil {
+				return err, code
+			}
+		}
+
+		sqlResult, err := tx.Exec(`
+			UPDATE users
+			SET nickname = $1, about = $2, image = $3
+			WHERE id = $4`, nickname, about, image, userId)
+		if err, code := psql.CheckSpecificDriverErrors(err); err != nil {
+			log.Println(err)
+			return err, code
+		}
+
+		return psql.IsAffectedOneRow(sqlResult)
+	})
 	return code
 }
 
@@ -91,44 +138,43 @@ func Follow(whoId, whomId int) int {
 		return misc.FollowYourself
 	}
 
-	sqlResult, err := psql.Db.Exec(`
-		INSERT INTO followers (who_id, whom_id)
-		VALUES ($1, $2)`, whoId, whomId)
-	if err, code := psql.CheckSpecificDriverErrors(err); err != nil {
-		log.Println(err)
-		return code
-	}
-	if err, code := psql.IsAffectedOneRow(sqlResult); err != nil {
-		return code
-	}
-
-	sqlResult, err = psql.Db.Exec(`
-		UPDATE users
-		SET followers_num = followers_num + 1
-		WHERE id = $1`, whomId)
-	if err, code := psql.CheckSpecificDriverErrors(err); err != nil {
-		log.Println(err)
-		return code
-	}
+	_, code := psql.Transaction(func(tx *sql.Tx) (error, int) {
+		sqlResult, err := tx.Exec(`
+			INSERT INTO followers (who_id, whom_id)
+			VALUES ($1, $2)`, whoId, whomId)
+		if err, code := psql.CheckSpecificDriverErrors(err); err != nil {
+			log.Println(err)
+			return err, code
+		}
+		if err, code := psql.IsAffectedOneRow(sqlResult); err != nil {
+			return err, code
+		}
 
-	if err, code := psql.IsAffectedOneRow(sqlResult); err != nil {
-		return code
-	}
+		sqlResult, err = tx.Exec(`
+			UPDATE users
+			SET followers_num = followers_num + 1
+			WHERE id = $1`, whomId)
+		if err, code := psql.CheckSpecificDriverErrors(err); err != nil {
+			log.Println(err)
+			return err, code
+		}
+		if err, code := psql.IsAffectedOneRow(sqlResult); err != nil {
+			return err, code
+		}
 
-	sqlResult, err = psql.Db.Exec(`
-		UPDATE users
-		SET following_num = following_num + 1
-		WHERE id = $1`, whoId)
-	if err, code := psql.CheckSpecificDriverErrors(err); err != nil {
-		log.Println(err)
-		return code
-	}
+		sqlResult, err = tx.Exec(`
+			UPDATE users
+			SET following_num = following_num + 1
+			WHERE id = $1`, whoId)
+		if err, code := psql.CheckSpecificDriverErrors(err); err != nil {
+			log.Println(err)
+			return err, code
+		}
 
-	if err, code := psql.IsAffectedOneRow(sqlResult); err != nil {
-		return code
-	}
+		return psql.IsAffectedOneRow(sqlResult)
+	})
 
-	return misc.NothingToReport
+	return code
 }
 
 // Unfollow a user whom you previously followed
@@ -143,45 +189,43 @@ func Unfollow(whoId, whomId int) int {
 		return misc.FollowYourself
 	}
 
-	sqlResult, err := psql.Db.Exec(`
-		DELETE FROM followers
-		WHERE who_id = $1 AND whom_id = $2`, whoId, whomId)
-	if err != nil {
-		log.Println(err)
-		return misc.NothingToReport
-	}
-
-	if err, code := psql.IsAffectedOneRow(sqlResult); err != nil {
-		return code
-	}
-
-	sqlResult, err = psql.Db.Exec(`
-		UPDATE users
-		SET followers_num = followers_num - 1
-		WHERE id = $1`, whomId)
-	if err, code := psql.CheckSpecificDriverErrors(err); err != nil {
-		log.Println(err)
-		return code
-	}
+	_, code := psql.Transaction(func(tx *sql.Tx) (error, int) {
+		sqlResult, err := tx.Exec(`
+			DELETE FROM followers
+			WHERE who_id = $1 AND whom_id = $2`, whoId, whomId)
+		if err, code := psql.CheckSpecificDriverErrors(err); err != nil {
+			log.Println(err)
+			return err, code
+		}
+		if err, code := psql.IsAffectedOneRow(sqlResult); err != nil {
+			return err, code
+		}
 
-	if err, code := psql.IsAffectedOneRow(sqlResult); err != nil {
-		return code
-	}
+		sqlResult, err = tx.Exec(`
+			UPDATE users
+			SET followers_num = followers_num - 1
+			WHERE id = $1`, whomId)
+		if err, code := psql.CheckSpecificDriverErrors(err); err != nil {
+			log.Println(err)
+			return err, code
+		}
+		if err, code := psql.IsAffectedOneRow(sqlResult); err != nil {
+			return err, code
+		}
 
-	sqlResult, err = psql.Db.Exec(`
-		UPDATE users
-		SET following_num = following_num - 1
-		WHERE id = $1`, whoId)
-	if err, code := psql.CheckSpecificDriverErrors(err); err != nil {
-		log.Println(err)
-		return code
-	}
+		sqlResult, err = tx.Exec(`
+			UPDATE users
+			SET following_num = following_num - 1
+			WHERE id = $1`, whoId)
+		if err, code := psql.CheckSpecificDriverErrors(err); err != nil {
+			log.Println(err)
+			return err, code
+		}
 
-	if err, code := psql.IsAffectedOneRow(sqlResult); err != nil {
-		return code
-	}
+		return psql.IsAffectedOneRow(sqlResult)
+	})
 
-	return misc.NothingToReport
+	return code
 }
 
 // GetFollowing returns a list of users whom a user with Id follows
@@ -260,6 +304,128 @@ func GetFollowers(userId int) ([]*misc.User, int) {
 	return users, misc.NothingToReport
 }
 
+// Preference describes one of the arrays of tags/brands which a user likes or ignores
+type Preference struct {
+	column   string // column of the users table which stores the ids
+	opposite string // column which can't have the same id at the same time
+	isTag    bool   // whether ids are tags or brands
+}
+
+// all possible preferences of a user
+var (
+	TagsLike     = Preference{"tags_like", "tags_ignore", true}
+	TagsIgnore   = Preference{"tags_ignore", "tags_like", true}
+	BrandsLike   = Preference{"brands_like", "brands_ignore", false}
+	BrandsIgnore = Preference{"brands_ignore", "brands_like", false}
+)
+
+// ShowPreferences returns all tags and brands a user likes or ignores
+func ShowPreferences(userId int) (misc.Preferences, int) {
+	if !misc.IsIdValid(userId) {
+		log.Println("UserId is not correct", userId)
+		return misc.Preferences{}, misc.NoElement
+	}
+
+	arrays := make([]string, 4)
+	if err := psql.Db.QueryRow(`
+		SELECT tags_like, tags_ignore, brands_like, brands_ignore
+		FROM users
+		WHERE id = $1`, userId,
+	).Scan(&arrays[0], &arrays[1], &arrays[2], &arrays[3]); err != nil {
+		if err == sql.ErrNoRows {
+			log.Println(err)
+			return misc.Preferences{}, misc.NoElement
+		}
+
+		log.Println(err)
+		return misc.Preferences{}, misc.NothingToReport
+	}
+
+	ids := make([][]int, len(arrays))
+	for i, v := range arrays {
+		var err error
+		if ids[i], err = psql.ParseIntArray(v); err != nil {
+			log.Println(err)
+			return misc.Preferences{}, misc.NothingToReport
+		}
+	}
+
+	return misc.Preferences{ids[0], ids[1], ids[2], ids[3]}, misc.NothingToReport
+}
+
+// AddPreference adds a tag/brand to a list of liked/ignored tags/brands of a user.
+// A tag/brand can't be liked and ignored at the same time
+func AddPreference(userId int, pref Preference, id int) int {
+	// userId is the current user and is always valid
+	if pref.isTag {
+		if err, code := tag.ValidateTags([]int{id}); err != nil {
+			log.Println(err)
+			return code
+		}
+	} else if err, code := brand.ValidateBrand(id); err != nil {
+		log.Println(err)
+		return code
+	}
+
+	isAdded, isOpposite := false, false
+	if err := psql.Db.QueryRow(fmt.Sprintf(`
+		SELECT $2 = ANY (%s), $2 = ANY (%s)
+		FROM users
+		WHERE id = $1`, pref.column, pref.opposite), userId, id,
+	).Scan(&isAdded, &isOpposite); err != nil {
+		if err == sql.ErrNoRows {
+			log.Println(err)
+			return misc.NoElement
+		}
+
+		log.Println(err)
+		return misc.DbError
+	}
+
+	if isAdded {
+		log.Println("Preference already exists", pref.column, id)
+		return misc.DbDuplicate
+	}
+
+	if isOpposite {
+		log.Println("Can't like and ignore at the same time", pref.column, id)
+		return misc.LikeAndIgnore
+	}
+
+	// conditions are repeated in case the preferences were changed in between
+	sqlResult, err := psql.Db.Exec(fmt.Sprintf(`
+		UPDATE users
+		SET %[1]s = array_append(%[1]s, $2)
+		WHERE id = $1 AND NOT $2 = ANY (%[1]s) AND NOT $2 = ANY (%[2]s)`, pref.column, pref.opposite), userId, id)
+	if err != nil {
+		log.Println(err)
+		return misc.DbError
+	}
+
+	err, code := psql.IsAffectedOneRow(sqlResult)
+	return code
+}
+
+// RemovePreference removes a tag/brand from a list of liked/ignored tags/brands of a user
+func RemovePreference(userId int, pref Preference, id int) int {
+	if !misc.IsIdValid(id) {
+		log.Println("Id is not correct", id)
+		return misc.NoElement
+	}
+
+	sqlResult, err := psql.Db.Exec(fmt.Sprintf(`
+		UPDATE users
+		SET %[1]s = array_remove(%[1]s, $2)
+		WHERE id = $1 AND $2 = ANY (%[1]s)`, pref.column), userId, id)
+	if err, code := psql.CheckSpecificDriverErrors(err); err != nil {
+		log.Println(err)
+		return code
+	}
+
+	err, code := psql.IsAffectedOneRow(sqlResult)
+	return code
+}
+
 // Create a new user, sends him a confirmation email
 func Create(nickname, email, password string) (int, int) {
 	nickname, ok := misc.ValidateString(nickname, misc.MaxLenS)
@@ -279,86 +445,504 @@ func Create(nickname, email, password string) (int, int) {
 		return 0, misc.WrongPassword
 	}
 
-	salt, err := auth.GenerateSalt()
+	hash, err := auth.HashPassword(password)
 	if err != nil {
 		log.Println(err)
 		return 0, misc.NoSalt
 	}
 
-	hash, err := auth.PasswordHash(password, salt)
+	confirmationCode, confirmationHash, err := auth.GenerateToken()
 	if err != nil {
 		log.Println(err)
-		return 0, misc.NothingToReport
+		return 0, misc.NoSalt
 	}
 
-	userId, confirmationCode := 0, misc.RandomString(misc.ConfCodeLen)
-	err = psql.Db.QueryRow(`
-		INSERT INTO users (nickname, email, password, salt, confirmation_code)
-		VALUES ($1, $2, $3, $4, $5)
-		RETURNING id`, nickname, email, hash, salt, confirmationCode,
-	).Scan(&userId)
-	if err == nil {
-		mailer.EmailConfirmation(email, confirmationCode)
-		return userId, misc.NothingToReport
+	userId := 0
+	if err, code := psql.Transaction(func(tx *sql.Tx) (error, int) {
+		if err := tx.QueryRow(`
+			INSERT INTO users (nickname, email, password, confirmation_hash, confirmation_expires_at, confirmation_sent_at)
+			VALUES ($1, $2, $3, $4, (now() at time zone 'utc') + $5 * interval '1 minute', (now() at time zone 'utc'))
+			RETURNING id`, nickname, email, hash, confirmationHash, misc.ConfCodeTtl,
+		).Scan(&userId); err != nil {
+			err, code := psql.CheckSpecificDriverErrors(err)
+			log.Println(err)
+			return err, code
+		}
+
+		if err := mailer.EmailConfirmation(tx, email, mailer.DefaultLanguage, confirmationCode); err != nil {
+			log.Println(err)
+			return err, misc.NothingToReport
+		}
+
+		return nil, misc.NothingToReport
+	}); err != nil {
+		return 0, code
 	}
 
-	err, code := psql.CheckSpecificDriverErrors(err)
-	log.Println(err)
-	return 0, code
+	return userId, misc.NothingToReport
 }
 
-// VerifyEmail verifies a previously created user
-func VerifyEmail(userId int, confCode string) (string, bool) {
+// VerifyEmail verifies a previously created user or a new email of a user (then the new email
+// replaces the old one). A code works once and only until it expires. A new session is opened
+func VerifyEmail(userId int, confCode string, client misc.Client) (misc.Tokens, int) {
+	confHash, expired := auth.HashToken(confCode), false
+	if err := psql.Db.QueryRow(`
+		SELECT confirmation_expires_at <= (now() at time zone 'utc')
+		FROM users
+		WHERE (verified = False OR pending_email <> '') AND id = $1 AND confirmation_hash = $2`, userId, confHash,
+	).Scan(&expired); err != nil {
+		log.Println(err)
+		if err == sql.ErrNoRows {
+			return misc.Tokens{}, misc.WrongConfCode
+		}
+		return misc.Tokens{}, misc.NothingToReport
+	}
+
+	if expired {
+		log.Println("Confirmation code expired", userId)
+		return misc.Tokens{}, misc.ConfCodeExpired
+	}
+
 	sqlResult, err := psql.Db.Exec(`
 		UPDATE users
-		SET verified = True, confirmation_code = ''
-		WHERE verified = False AND id = $1 AND confirmation_code = $2`, userId, confCode)
-	if err, _ := psql.CheckSpecificDriverErrors(err); err != nil {
+		SET verified = True, pending_email = '',
+			confirmation_hash = NULL, confirmation_expires_at = NULL, confirmation_sent_at = NULL,
+			email = CASE WHEN pending_email = '' THEN email ELSE pending_email END
+		WHERE (verified = False OR pending_email <> '') AND id = $1 AND confirmation_hash = $2
+			AND confirmation_expires_at > (now() at time zone 'utc')`, userId, confHash)
+	if err, code := psql.CheckSpecificDriverErrors(err); err != nil {
 		log.Println(err)
-		return "", false
+		return misc.Tokens{}, code
 	}
 
+	// somebody else has used the code in the meantime
 	if err, _ := psql.IsAffectedOneRow(sqlResult); err != nil {
-		return "", false
+		return misc.Tokens{}, misc.WrongConfCode
 	}
 
-	jwt, err := auth.CreateJWT(userId, true)
+	return session.Create(userId, true, client)
+}
+
+// ResendConfirmation sends a new confirmation code to an email which is not confirmed yet (a new
+// email if a user is changing it). Previous codes stop working. Emails are sent not more often than
+// once in misc.ConfCodeResend minutes
+func ResendConfirmation(userId int) int {
+	confirmationCode, confirmationHash, err := auth.GenerateToken()
+	if err != nil {
+		log.Println(err)
+		return misc.NoSalt
+	}
+
+	if err, _ := psql.Transaction(func(tx *sql.Tx) (error, int) {
+		email, language, isNewEmail := "", "", false
+		if err := tx.QueryRow(`
+			UPDATE users
+			SET confirmation_hash = $1, confirmation_expires_at = (now() at time zone 'utc') + $2 * interval '1 minute',
+				confirmation_sent_at = (now() at time zone 'utc')
+			WHERE id = $3 AND (verified = False OR pending_email <> '')
+				AND (confirmation_sent_at IS NULL OR confirmation_sent_at <= (now() at time zone 'utc') - $4 * interval '1 minute')
+			RETURNING CASE WHEN pending_email = '' THEN email ELSE pending_email END, language, pending_email <> ''`,
+			confirmationHash, misc.ConfCodeTtl, userId, misc.ConfCodeResend,
+		).Scan(&email, &language, &isNewEmail); err != nil {
+			return err, misc.NothingToReport
+		}
+
+		if isNewEmail {
+			return mailer.EmailChangeConfirmation(tx, email, language, confirmationCode), misc.NothingToReport
+		}
+		return mailer.EmailConfirmation(tx, email, language, confirmationCode), misc.NothingToReport
+	}); err != nil {
+		if err != sql.ErrNoRows {
+			log.Println(err)
+			return misc.NothingToReport
+		}
+
+		// either nothing needs a confirmation or the last email was sent recently
+		needsConfirmation := false
+		if err := psql.Db.QueryRow(`
+			SELECT EXISTS(
+				SELECT 1
+				FROM users
+				WHERE id = $1 AND (verified = False OR pending_email <> '')
+			)`, userId,
+		).Scan(&needsConfirmation); err != nil {
+			log.Println(err)
+			return misc.NothingToReport
+		}
+
+		if needsConfirmation {
+			log.Println("Confirmation email was sent recently", userId)
+			return misc.TooManyAttempts
+		}
+		return misc.NothingToConfirm
+	}
+
+	return misc.NothingToReport
+}
+
+// ChangePassword sets a new password for a user who knows the current one. All other sessions of
+// the user are revoked
+func ChangePassword(userId, sessionId int, password, newPassword string) int {
+	if !misc.IsPasswordValid(newPassword) {
+		log.Println("Wrong password")
+		return misc.WrongPassword
+	}
+
+	hash := ""
+	if err := psql.Db.QueryRow(`
+		SELECT password
+		FROM users
+		WHERE id = $1`, userId,
+	).Scan(&hash); err != nil {
+		log.Println(err)
+		if err == sql.ErrNoRows {
+			return misc.NoElement
+		}
+		return misc.NothingToReport
+	}
+
+	if ok, _ := auth.VerifyPassword(password, hash); !ok {
+		log.Println("Wrong old password", userId)
+		return misc.WrongOldPassword
+	}
+
+	hash, err := auth.HashPassword(newPassword)
 	if err != nil {
-		return "", false
+		log.Println(err)
+		return misc.NoSalt
 	}
 
-	return jwt, true
+	_, code := psql.Transaction(func(tx *sql.Tx) (error, int) {
+		sqlResult, err := tx.Exec(`
+			UPDATE users
+			SET password = $1
+			WHERE id = $2`, hash, userId)
+		if err, code := psql.CheckSpecificDriverErrors(err); err != nil {
+			log.Println(err)
+			return err, code
+		}
+
+		if err, code := psql.IsAffectedOneRow(sqlResult); err != nil {
+			return err, code
+		}
+
+		if _, err := tx.Exec(`
+			UPDATE sessions
+			SET revoked_at = (now() at time zone 'utc')
+			WHERE user_id = $1 AND id <> $2 AND revoked_at IS NULL`, userId, sessionId,
+		); err != nil {
+			log.Println(err)
+			return err, misc.NothingToReport
+		}
+
+		return nil, misc.NothingToReport
+	})
+
+	return code
 }
 
-// Login a user
-func Login(email, password string) (string, bool) {
+// ChangeEmail sends a confirmation code to a new email. The email is changed only after the code
+// is confirmed with VerifyEmail
+func ChangeEmail(userId int, email string) int {
+	email, ok := misc.ValidateEmail(email)
+	if !ok {
+		log.Println("Wrong email", email)
+		return misc.WrongEmail
+	}
+
+	isTaken := false
+	if err := psql.Db.QueryRow(`
+		SELECT EXISTS(SELECT 1 FROM users WHERE email = $1)`, email,
+	).Scan(&isTaken); err != nil {
+		log.Println(err)
+		return misc.NothingToReport
+	}
+
+	if isTaken {
+		log.Println("Email is already used", email)
+		return misc.DbDuplicate
+	}
+
+	confirmationCode, confirmationHash, err := auth.GenerateToken()
+	if err != nil {
+		log.Println(err)
+		return misc.NoSalt
+	}
+
+	_, code := psql.Transaction(func(tx *sql.Tx) (error, int) {
+		language := ""
+		err := tx.QueryRow(`
+			UPDATE users
+			SET pending_email = $1, confirmation_hash = $2,
+				confirmation_expires_at = (now() at time zone 'utc') + $3 * interval '1 minute',
+				confirmation_sent_at = (now() at time zone 'utc')
+			WHERE id = $4
+			RETURNING language`, email, confirmationHash, misc.ConfCodeTtl, userId,
+		).Scan(&language)
+		if err == sql.ErrNoRows {
+			log.Println("No user", userId)
+			return err, misc.NothingUpdated
+		}
+
+		if err, code := psql.CheckSpecificDriverErrors(err); err != nil {
+			log.Println(err)
+			return err, code
+		}
+
+		if err := mailer.EmailChangeConfirmation(tx, email, language, confirmationCode); err != nil {
+			log.Println(err)
+			return err, misc.NothingToReport
+		}
+
+		return nil, misc.NothingToReport
+	})
+
+	return code
+}
+
+// SetLanguage chooses a language of emails which a user receives
+func SetLanguage(userId int, language string) int {
+	if !mailer.IsLanguageSupported(language) {
+		log.Println("Wrong language", language)
+		return misc.WrongLanguage
+	}
+
+	sqlResult, err := psql.Db.Exec(`
+		UPDATE users
+		SET language = $1
+		WHERE id = $2`, language, userId)
+	if err, code := psql.CheckSpecificDriverErrors(err); err != nil {
+		log.Println(err)
+		return code
+	}
+
+	if err, code := psql.IsAffectedOneRow(sqlResult); err != nil {
+		return code
+	}
+
+	return misc.NothingToReport
+}
+
+// Login a user. Opens a new session, or only starts a second step of the login if a user has 2FA
+func Login(email, password string, client misc.Client) (misc.Tokens, bool) {
 	email, ok := misc.ValidateEmail(email)
 	if !ok || !misc.IsPasswordValid(password) {
-		return "", false
+		return misc.Tokens{}, false
+	}
+
+	userId, hash, verified, mfaEnabled := 0, "", false, false
+
+	if err := psql.Db.QueryRow(`
+		SELECT id, password, verified, totp_enabled
+		FROM users
+		WHERE email = $1`, email,
+	).Scan(&userId, &hash, &verified, &mfaEnabled); err != nil {
+		return misc.Tokens{}, false
+	}
+
+	ok, weak := auth.VerifyPassword(password, hash)
+	if !ok {
+		return misc.Tokens{}, false
+	}
+
+	// a login is the only moment when a password is known, so old hashes are upgraded here
+	if weak {
+		rehash(userId, password, hash)
+	}
+
+	// a user with 2FA gets a session only after a code from mfa.Exchange
+	if mfaEnabled {
+		mfaToken, code := mfa.CreateChallenge(userId)
+		return misc.Tokens{Mfa_token: mfaToken}, code == misc.NothingToReport && mfaToken != ""
+	}
+
+	tokens, code := session.Create(userId, verified, client)
+	return tokens, code == misc.NothingToReport && tokens.Jwt != ""
+}
+
+// rehash replaces a weak hash of a password with a hash of current parameters. Nothing happens if
+// the password was changed in the meantime. A failure is not important, the next login tries again
+func rehash(userId int, password, oldHash string) {
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		log.Println(err)
+		return
+	}
+
+	if _, err := psql.Db.Exec(`
+		UPDATE users
+		SET password = $1
+		WHERE id = $2 AND password = $3`, hash, userId, oldHash,
+	); err != nil {
+		log.Println(err)
+	}
+}
+
+// NotifyLockout tells an owner of an email that logins to the account are blocked. Nothing happens if
+// the email is not registered
+func NotifyLockout(email string, minutes int) {
+	email, ok := misc.ValidateEmail(email)
+	if !ok {
+		return
+	}
+
+	language := ""
+	if err := psql.Db.QueryRow(`
+		SELECT language
+		FROM users
+		WHERE email = $1`, email,
+	).Scan(&language); err != nil {
+		if err != sql.ErrNoRows {
+			log.Println(err)
+		}
+		return
 	}
 
-	userId, hash, salt, verified := 0, make([]byte, 32), make([]byte, 16), false
+	if err := mailer.AccountLocked(psql.Db, email, language, minutes); err != nil {
+		log.Println(err)
+	}
+}
 
+// RequestPasswordReset emails a one-time token to reset a password. Nothing is reported to a client,
+// so nobody can find out whether an email is registered
+func RequestPasswordReset(email string) {
+	email, ok := misc.ValidateEmail(email)
+	if !ok {
+		log.Println("Wrong email", email)
+		return
+	}
+
+	userId, language := 0, ""
 	if err := psql.Db.QueryRow(`
-		SELECT id, password, salt, verified
+		SELECT id, language
 		FROM users
 		WHERE email = $1`, email,
-	).Scan(&userId, &hash, &salt, &verified); err != nil {
-		return "", false
+	).Scan(&userId, &language); err != nil {
+		log.Println(err)
+		return
 	}
 
-	hashAttempt, err := auth.PasswordHash(password, salt)
+	token, hash, err := auth.GenerateToken()
 	if err != nil {
-		return "", false
+		log.Println(err)
+		return
 	}
 
-	if !reflect.DeepEqual(hashAttempt, hash) {
-		return "", false
+	if err, _ := psql.Transaction(func(tx *sql.Tx) (error, int) {
+		if _, err := tx.Exec(`
+			INSERT INTO password_resets (user_id, token_hash, expires_at)
+			VALUES ($1, $2, (now() at time zone 'utc') + $3 * interval '1 minute')`, userId, hash, misc.ResetTokenTtl,
+		); err != nil {
+			return err, misc.NothingToReport
+		}
+
+		return mailer.PasswordReset(tx, email, language, token), misc.NothingToReport
+	}); err != nil {
+		log.Println(err)
 	}
+}
 
-	jwt, err := auth.CreateJWT(userId, verified)
+// ResetPassword sets a new password for a user who owns a reset token. The token can be used only
+// once. All sessions of the user are revoked
+func ResetPassword(token, password string) int {
+	if !misc.IsPasswordValid(password) {
+		log.Println("Wrong password")
+		return misc.WrongPassword
+	}
+
+	hash, err := auth.HashPassword(password)
 	if err != nil {
-		return "", false
+		log.Println(err)
+		return misc.NoSalt
 	}
-	return jwt, true
+
+	_, code := psql.Transaction(func(tx *sql.Tx) (error, int) {
+		userId := 0
+		if err := tx.QueryRow(`
+			UPDATE password_resets
+			SET used_at = (now() at time zone 'utc')
+			WHERE token_hash = $1 AND used_at IS NULL AND expires_at > (now() at time zone 'utc')
+			RETURNING user_id`, auth.HashToken(token),
+		).Scan(&userId); err != nil {
+			log.Println(err)
+			if err == sql.ErrNoRows {
+				return err, misc.WrongResetToken
+			}
+			return err, misc.NothingToReport
+		}
+
+		// other tokens sent to the same email are useless now
+		if _, err := tx.Exec(`
+			UPDATE password_resets
+			SET used_at = (now() at time zone 'utc')
+			WHERE user_id = $1 AND used_at IS NULL`, userId,
+		); err != nil {
+			log.Println(err)
+			return err, misc.NothingToReport
+		}
+
+		if _, err := tx.Exec(`
+			UPDATE sessions
+			SET revoked_at = (now() at time zone 'utc')
+			WHERE user_id = $1 AND revoked_at IS NULL`, userId,
+		); err != nil {
+			log.Println(err)
+			return err, misc.NothingToReport
+		}
+
+		sqlResult, err := tx.Exec(`
+			UPDATE users
+			SET password = $1
+			WHERE id = $2`, hash, userId)
+		if err, code := psql.CheckSpecificDriverErrors(err); err != nil {
+			log.Println(err)
+			return err, code
+		}
+
+		return psql.IsAffectedOneRow(sqlResult)
+	})
+
+	return code
+}
+
+// SetRole changes a role of a user. All sessions of the user are revoked, so tokens with the old role
+// can't be used anymore
+func SetRole(userId int, role string) int {
+	if !misc.IsIdValid(userId) {
+		log.Println("User id is not correct", userId)
+		return misc.NothingUpdated
+	}
+
+	if !misc.IsRoleValid(role) {
+		log.Println("Role is not correct", role)
+		return misc.WrongRole
+	}
+
+	_, code := psql.Transaction(func(tx *sql.Tx) (error, int) {
+		sqlResult, err := tx.Exec(`
+			UPDATE users
+			SET role = $1
+			WHERE id = $2`, role, userId)
+		if err, code := psql.CheckSpecificDriverErrors(err); err != nil {
+			log.Println(err)
+			return err, code
+		}
+
+		if err, code := psql.IsAffectedOneRow(sqlResult); err != nil {
+			return err, code
+		}
+
+		if _, err := tx.Exec(`
+			UPDATE sessions
+			SET revoked_at = (now() at time zone 'utc')
+			WHERE user_id = $1 AND revoked_at IS NULL`, userId,
+		); err != nil {
+			log.Println(err)
+			return err, misc.NothingToReport
+		}
+
+		return nil, misc.NothingToReport
+	})
+
+	return code
 }
@@ -1,13 +1,20 @@
 package user
 
 import (
+	"../../auth"
+	"../../config"
 	"../../misc"
 	"../../psql"
+	"../mfa"
+	"../session"
 	o "../testHelpers"
 	"io/ioutil"
 	"log"
 	"os"
+	"reflect"
+	"strings"
 	"testing"
+	"time"
 )
 
 // Setup and db.close will be called before and after each test http://stackoverflow.com/a/34102842/1090562
@@ -81,7 +88,7 @@ func TestUpdate(t *testing.T) {
 		{43, o.RandomString(misc.MaxLenS, 0, 0), o.RandomString(misc.MaxLenS, 0, 0), misc.NothingUpdated},
 	}
 	for num, v := range table {
-		code := Update(v.id, v.nickname, v.about)
+		code := Update(v.id, v.nickname, v.about, "")
 		if code != v.code {
 			t.Errorf("Case %v. Expect %v. Got %v", num, v.code, code)
 		}
@@ -95,6 +102,41 @@ func TestUpdate(t *testing.T) {
 	}
 }
 
+func TestUpdateAvatar(t *testing.T) {
+	o.CleanUpDb()
+
+	img, about := "1467954473_isForTests.jpg", o.RandomString(misc.MaxLenB, 0, 0)
+	table := []struct {
+		id       int
+		uploader int // 0 means nobody has a fresh upload of the image
+		kind     string
+		image    string
+		code     int
+	}{
+		{7, 0, "", img, misc.NothingToReport}, // the avatar of the user does not change
+		{1, 0, "", img, misc.WrongUpload},
+		{1, 2, misc.UploadAvatar, img, misc.WrongUpload},
+		{1, 1, misc.UploadPurchase, img, misc.WrongUpload},
+		{1, 1, misc.UploadAvatar, "1467954473_missing.jpg", misc.WrongImg},
+		{1, 1, misc.UploadAvatar, img, misc.NothingToReport},
+		{1, 0, "", "", misc.NothingToReport},
+	}
+	for num, v := range table {
+		psql.Db.Exec(`DELETE FROM uploads`)
+		if v.uploader != 0 {
+			o.GiveUpload(v.uploader, v.kind, img)
+		}
+
+		if code := Update(v.id, o.RandomString(misc.MaxLenS, 0, 0), about, v.image); code != v.code {
+			t.Errorf("Case %v. Expect %v. Got %v", num, v.code, code)
+		}
+
+		if user, _ := ShowById(v.id); v.code == misc.NothingToReport && user.Image != v.image {
+			t.Errorf("Case %v. Expect avatar %v. Got %v", num, v.image, user.Image)
+		}
+	}
+}
+
 func TestGetFollowers(t *testing.T) {
 	o.CleanUpDb()
 
@@ -275,6 +317,105 @@ func TestUnfollow(t *testing.T) {
 	}
 }
 
+func TestAddPreference(t *testing.T) {
+	o.CleanUpDb()
+
+	table := []struct {
+		userId int
+		pref   Preference
+		id     int
+		code   int
+	}{
+		{1, TagsLike, 2, misc.NothingToReport},
+		{1, TagsLike, 4, misc.NothingToReport},
+		{1, TagsLike, 2, misc.DbDuplicate},
+		{1, TagsIgnore, 2, misc.LikeAndIgnore},
+		{1, TagsIgnore, 3, misc.NothingToReport},
+		{1, TagsLike, 3, misc.LikeAndIgnore},
+		{1, TagsLike, 9, misc.WrongTags},
+		{1, TagsIgnore, -1, misc.WrongTags},
+		{1, BrandsLike, 2, misc.NothingToReport},
+		{1, BrandsIgnore, 2, misc.LikeAndIgnore},
+		{1, BrandsIgnore, 5, misc.NothingToReport},
+		{1, BrandsIgnore, 5, misc.DbDuplicate},
+		{1, BrandsLike, 0, misc.NoElement},
+		{1, BrandsLike, 9, misc.NoElement},
+		{2, BrandsLike, 5, misc.NothingToReport},
+		{43, BrandsLike, 5, misc.NoElement},
+	}
+	for num, v := range table {
+		if code := AddPreference(v.userId, v.pref, v.id); code != v.code {
+			t.Errorf("Case %v. Expect %v. Got %v", num, v.code, code)
+		}
+	}
+
+	prefs, code := ShowPreferences(1)
+	expected := misc.Preferences{[]int{2, 4}, []int{3}, []int{2}, []int{5}}
+	if code != misc.NothingToReport || !reflect.DeepEqual(prefs, expected) {
+		t.Errorf("Expect %v. Got %v, %v", expected, prefs, code)
+	}
+}
+
+func TestRemovePreference(t *testing.T) {
+	o.CleanUpDb()
+
+	AddPreference(1, TagsLike, 2)
+	AddPreference(1, TagsLike, 4)
+	AddPreference(1, BrandsIgnore, 5)
+
+	table := []struct {
+		userId int
+		pref   Preference
+		id     int
+		code   int
+	}{
+		{1, TagsLike, 2, misc.NothingToReport},
+		{1, TagsLike, 2, misc.NothingUpdated},
+		{1, TagsIgnore, 4, misc.NothingUpdated},
+		{1, BrandsIgnore, 5, misc.NothingToReport},
+		{1, BrandsIgnore, 0, misc.NoElement},
+		{2, TagsLike, 4, misc.NothingUpdated},
+	}
+	for num, v := range table {
+		if code := RemovePreference(v.userId, v.pref, v.id); code != v.code {
+			t.Errorf("Case %v. Expect %v. Got %v", num, v.code, code)
+		}
+	}
+
+	prefs, _ := ShowPreferences(1)
+	expected := misc.Preferences{[]int{4}, []int{}, []int{}, []int{}}
+	if !reflect.DeepEqual(prefs, expected) {
+		t.Errorf("Expect %v. Got %v", expected, prefs)
+	}
+
+	// an ignored tag can be liked after it was removed from ignored
+	AddPreference(1, TagsIgnore, 3)
+	RemovePreference(1, TagsIgnore, 3)
+	if code := AddPreference(1, TagsLike, 3); code != misc.NothingToReport {
+		t.Errorf("Expect %v. Got %v", misc.NothingToReport, code)
+	}
+
+	for num, id := range []int{0, -1, 43} {
+		if _, code := ShowPreferences(id); code != misc.NoElement {
+			t.Errorf("Case %v. Expect %v. Got %v", num, misc.NoElement, code)
+		}
+	}
+}
+
+// sentCode reads a confirmation code from the last email sent to an address
+func sentCode(t *testing.T, email string) string {
+	msg, ok := o.LastEmail(email)
+	if !ok {
+		t.Fatalf("Expect an email to %v", email)
+	}
+
+	fields := strings.Fields(msg.Text[strings.Index(msg.Text, ":")+1:])
+	if len(fields) == 0 {
+		t.Fatalf("Expect a code in %v", msg.Text)
+	}
+	return fields[0]
+}
+
 func TestCreate(t *testing.T) {
 	o.CleanUpDb()
 
@@ -293,6 +434,10 @@ func TestCreate(t *testing.T) {
 		if code != misc.NothingToReport || userId != v.userId {
 			t.Errorf("Case %v. Expect 0, %v. Got %v, %v", num, v.userId, code, userId)
 		}
+
+		if _, code := VerifyEmail(userId, sentCode(t, v.email), misc.Client{}); code != misc.NothingToReport {
+			t.Errorf("Case %v. Expect to verify with a code from an email. Got %v", num, code)
+		}
 	}
 
 	tableFail := []struct {
@@ -335,9 +480,9 @@ func TestLogin(t *testing.T) {
 		{email, pass},
 	}
 	for num, v := range tableSuccess {
-		jwt, ok := Login(v.email, v.password)
-		if !ok || len(jwt) < 10 {
-			t.Errorf("Case %v. Expect to log in. Got %v, %v", num, ok, jwt)
+		tokens, ok := Login(v.email, v.password, misc.Client{"test", "127.0.0.1"})
+		if !ok || len(tokens.Jwt) < 10 || len(tokens.Refresh_token) < 10 {
+			t.Errorf("Case %v. Expect to log in. Got %v, %v", num, ok, tokens)
 		}
 	}
 
@@ -350,11 +495,42 @@ func TestLogin(t *testing.T) {
 		{tableSuccess[2].email, tableSuccess[2].password + "a"},
 	}
 	for num, v := range tableFail {
-		jwt, ok := Login(v.email, v.password)
-		if ok || jwt != "" {
-			t.Errorf("Case %v. Expect to fail. Got %v, %v", num, ok, jwt)
+		tokens, ok := Login(v.email, v.password, misc.Client{"test", "127.0.0.1"})
+		if ok || tokens.Jwt != "" {
+			t.Errorf("Case %v. Expect to fail. Got %v, %v", num, ok, tokens)
 		}
 	}
+
+	// a user with 2FA gets only a token for the second step
+	setup, _ := mfa.Setup(1)
+	if _, code := mfa.Confirm(1, auth.TotpCode(setup.Secret, time.Now())); code != misc.NothingToReport {
+		t.Fatalf("Expect to enable 2FA. Got %v", code)
+	}
+
+	tokens, ok := Login(tableSuccess[0].email, tableSuccess[0].password, misc.Client{"test", "127.0.0.1"})
+	if !ok || tokens.Jwt != "" || tokens.Refresh_token != "" || len(tokens.Mfa_token) < 40 {
+		t.Errorf("Expect a token of the second step. Got %v, %v", ok, tokens)
+	}
+}
+
+func TestLoginRehash(t *testing.T) {
+	o.CleanUpDb()
+	defer func(alg string) { config.Cfg.PassHash = alg }(config.Cfg.PassHash)
+
+	config.Cfg.PassHash = auth.HashArgon2id
+	if _, ok := Login("albert@gmail.com", "password", misc.Client{"test", "127.0.0.1"}); !ok {
+		t.Fatal("Expect to log in with an old hash")
+	}
+
+	hash := ""
+	psql.Db.QueryRow(`SELECT password FROM users WHERE id = 1`).Scan(&hash)
+	if ok, weak := auth.VerifyPassword("password", hash); !ok || weak {
+		t.Errorf("Expect a hash to be upgraded. Got %v", hash)
+	}
+
+	if _, ok := Login("albert@gmail.com", "password", misc.Client{"test", "127.0.0.1"}); !ok {
+		t.Error("Expect to log in with an upgraded hash")
+	}
 }
 
 func TestVerifyEmail(t *testing.T) {
@@ -369,14 +545,335 @@ func TestVerifyEmail(t *testing.T) {
 		{5, "pqaJaBRgAvzLXqzRrrUI"},
 		{500, "pqaJaBRgAvzLXqzRrrUIsafasdfsad"},
 		{10, "pqaJaBRgAvzLXqzRrrUIsafasdfsad"},
+		{10, ""},
 	}
 	for num, v := range tableFail {
-		if _, ok := VerifyEmail(v.userId, v.verifyCode); ok {
-			t.Errorf("Case %v. Expect to fail. Got True", num)
+		if tokens, code := VerifyEmail(v.userId, v.verifyCode, misc.Client{}); code != misc.WrongConfCode || tokens.Jwt != "" {
+			t.Errorf("Case %v. Expect to fail. Got %v, %v", num, tokens, code)
+		}
+	}
+
+	if tokens, code := VerifyEmail(10, "pqaJaBRgAvzLXqzRrrUI", misc.Client{}); code != misc.NothingToReport || tokens.Jwt == "" {
+		t.Errorf("Expect to verify email. Got %v, %v", tokens, code)
+	}
+
+	if _, code := VerifyEmail(10, "pqaJaBRgAvzLXqzRrrUI", misc.Client{}); code != misc.WrongConfCode {
+		t.Errorf("Expect a code to work only once. Got %v", code)
+	}
+}
+
+func TestVerifyEmailExpired(t *testing.T) {
+	o.CleanUpDb()
+
+	if _, err := psql.Db.Exec(`
+		UPDATE users
+		SET confirmation_expires_at = (now() at time zone 'utc') - interval '1 minute'
+		WHERE id = 10`,
+	); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, code := VerifyEmail(10, "pqaJaBRgAvzLXqzRrrUI", misc.Client{}); code != misc.ConfCodeExpired {
+		t.Errorf("Expect a code to expire. Got %v", code)
+	}
+
+	if _, code := VerifyEmail(10, "pqaJaBRgAvzLXqzRrrUIa", misc.Client{}); code != misc.WrongConfCode {
+		t.Errorf("Expect a wrong code not to be reported as expired. Got %v", code)
+	}
+}
+
+func TestResendConfirmation(t *testing.T) {
+	o.CleanUpDb()
+
+	// the last email of the user 10 was sent an hour ago
+	table := []struct {
+		userId int
+		code   int
+	}{
+		{1, misc.NothingToConfirm},
+		{500, misc.NothingToConfirm},
+		{10, misc.NothingToReport},
+		{10, misc.TooManyAttempts},
+	}
+	for num, v := range table {
+		if code := ResendConfirmation(v.userId); code != v.code {
+			t.Errorf("Case %v. Expect %v. Got %v", num, v.code, code)
+		}
+	}
+
+	if _, code := VerifyEmail(10, "pqaJaBRgAvzLXqzRrrUI", misc.Client{}); code != misc.WrongConfCode {
+		t.Errorf("Expect an old code to stop working. Got %v", code)
+	}
+
+	if _, code := VerifyEmail(10, sentCode(t, "kepler@gmail.com"), misc.Client{}); code != misc.NothingToReport {
+		t.Errorf("Expect a new code to work. Got %v", code)
+	}
+
+	// a user changing an email can get a new code too
+	ChangeEmail(1, "new_albert@gmail.com")
+	if code := ResendConfirmation(1); code != misc.TooManyAttempts {
+		t.Errorf("Expect not to resend right after a change of an email. Got %v", code)
+	}
+}
+
+func TestRequestPasswordReset(t *testing.T) {
+	o.CleanUpDb()
+
+	for _, email := range []string{"albert@gmail.com", "nobody@gmail.com", "not an email", "isaac@gmail.com", "albert@gmail.com"} {
+		RequestPasswordReset(email)
+	}
+
+	table := []struct {
+		userId int
+		tokens int
+	}{
+		{1, 2},
+		{2, 1},
+		{3, 0},
+	}
+	for num, v := range table {
+		tokens := 0
+		if err := psql.Db.QueryRow(`
+			SELECT COUNT(*)
+			FROM password_resets
+			WHERE user_id = $1 AND used_at IS NULL AND expires_at > (now() at time zone 'utc')`, v.userId,
+		).Scan(&tokens); err != nil || tokens != v.tokens {
+			t.Errorf("Case %v. Expect %v tokens. Got %v, %v", num, v.tokens, tokens, err)
+		}
+	}
+}
+
+func TestResetPassword(t *testing.T) {
+	o.CleanUpDb()
+
+	albert, _ := Login("albert@gmail.com", "password", misc.Client{})
+	isaac, _ := Login("isaac@gmail.com", "password", misc.Client{})
+
+	for _, v := range []struct {
+		token   string
+		userId  int
+		minutes int
+	}{
+		{"valid_token", 1, 60},
+		{"another_valid_token", 1, 60},
+		{"expired_token", 1, -1},
+		{"token_of_isaac", 2, 60},
+	} {
+		if _, err := psql.Db.Exec(`
+			INSERT INTO password_resets (user_id, token_hash, expires_at)
+			VALUES ($1, $2, (now() at time zone 'utc') + $3 * interval '1 minute')`, v.userId, auth.HashToken(v.token), v.minutes,
+		); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	table := []struct {
+		token    string
+		password string
+		code     int
+	}{
+		{"valid_token", "short", misc.WrongPassword},
+		{"unknown_token", "new_password", misc.WrongResetToken},
+		{"expired_token", "new_password", misc.WrongResetToken},
+		{"valid_token", "new_password", misc.NothingToReport},
+		{"valid_token", "newer_password", misc.WrongResetToken},
+		{"another_valid_token", "newer_password", misc.WrongResetToken},
+	}
+	for num, v := range table {
+		if code := ResetPassword(v.token, v.password); code != v.code {
+			t.Errorf("Case %v. Expect %v. Got %v", num, v.code, code)
+		}
+	}
+
+	if _, ok := Login("albert@gmail.com", "password", misc.Client{}); ok {
+		t.Error("Expect old password not to work")
+	}
+
+	if _, ok := Login("albert@gmail.com", "new_password", misc.Client{}); !ok {
+		t.Error("Expect to log in with a new password")
+	}
+
+	if _, ok := Login("isaac@gmail.com", "password", misc.Client{}); !ok {
+		t.Error("Expect password of another user not to change")
+	}
+
+	for _, v := range []struct {
+		jwt      string
+		isActive bool
+	}{
+		{albert.Jwt, false},
+		{isaac.Jwt, true},
+	} {
+		token, err := auth.ValidateJWT(v.jwt)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if session.IsActive(token.SessionId, token.UserId) != v.isActive {
+			t.Errorf("Expect session of user %v to be active: %v", token.UserId, v.isActive)
 		}
 	}
+}
+
+func TestChangePassword(t *testing.T) {
+	o.CleanUpDb()
+
+	sessionIds := []int{}
+	for i := 0; i < 2; i++ {
+		tokens, _ := Login("albert@gmail.com", "password", misc.Client{})
+		token, err := auth.ValidateJWT(tokens.Jwt)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sessionIds = append(sessionIds, token.SessionId)
+	}
+
+	table := []struct {
+		userId      int
+		password    string
+		newPassword string
+		code        int
+	}{
+		{1, "password", "short", misc.WrongPassword},
+		{1, "wrong_password", "new_password", misc.WrongOldPassword},
+		{100, "password", "new_password", misc.NoElement},
+		{1, "password", "new_password", misc.NothingToReport},
+		{1, "password", "newer_password", misc.WrongOldPassword},
+	}
+	for num, v := range table {
+		if code := ChangePassword(v.userId, sessionIds[0], v.password, v.newPassword); code != v.code {
+			t.Errorf("Case %v. Expect %v. Got %v", num, v.code, code)
+		}
+	}
+
+	if _, ok := Login("albert@gmail.com", "new_password", misc.Client{}); !ok {
+		t.Error("Expect to log in with a new password")
+	}
+
+	if !session.IsActive(sessionIds[0], 1) {
+		t.Error("Expect current session to stay active")
+	}
+
+	if session.IsActive(sessionIds[1], 1) {
+		t.Error("Expect other sessions to be revoked")
+	}
+}
+
+func TestChangeEmail(t *testing.T) {
+	o.CleanUpDb()
+
+	table := []struct {
+		userId int
+		email  string
+		code   int
+	}{
+		{1, "not an email", misc.WrongEmail},
+		{1, "isaac@gmail.com", misc.DbDuplicate},
+		{1, "albert@gmail.com", misc.DbDuplicate},
+		{100, "new_albert@gmail.com", misc.NothingUpdated},
+		{1, "new_albert@gmail.com", misc.NothingToReport},
+	}
+	for num, v := range table {
+		if code := ChangeEmail(v.userId, v.email); code != v.code {
+			t.Errorf("Case %v. Expect %v. Got %v", num, v.code, code)
+		}
+	}
+
+	// email is not changed until it is confirmed
+	if _, ok := Login("new_albert@gmail.com", "password", misc.Client{}); ok {
+		t.Error("Expect not to log in with unconfirmed email")
+	}
+
+	confCode := sentCode(t, "new_albert@gmail.com")
+
+	if _, code := VerifyEmail(1, confCode+"a", misc.Client{}); code != misc.WrongConfCode {
+		t.Errorf("Expect not to verify with a wrong code. Got %v", code)
+	}
+
+	if _, code := VerifyEmail(1, confCode, misc.Client{}); code != misc.NothingToReport {
+		t.Errorf("Expect to verify a new email. Got %v", code)
+	}
+
+	if _, code := VerifyEmail(1, confCode, misc.Client{}); code != misc.WrongConfCode {
+		t.Errorf("Expect a code to work only once. Got %v", code)
+	}
+
+	if _, ok := Login("new_albert@gmail.com", "password", misc.Client{}); !ok {
+		t.Error("Expect to log in with a new email")
+	}
+
+	if _, ok := Login("albert@gmail.com", "password", misc.Client{}); ok {
+		t.Error("Expect not to log in with an old email")
+	}
+}
+
+func TestSetLanguage(t *testing.T) {
+	o.CleanUpDb()
+
+	table := []struct {
+		userId   int
+		language string
+		code     int
+	}{
+		{1, "xx", misc.WrongLanguage},
+		{1, "", misc.WrongLanguage},
+		{100, "ru", misc.NothingUpdated},
+		{1, "ru", misc.NothingToReport},
+	}
+	for num, v := range table {
+		if code := SetLanguage(v.userId, v.language); code != v.code {
+			t.Errorf("Case %v. Expect %v. Got %v", num, v.code, code)
+		}
+	}
+
+	// emails are sent in the chosen language
+	ChangeEmail(1, "new_albert@gmail.com")
+	if msg, ok := o.LastEmail("new_albert@gmail.com"); !ok || !strings.Contains(msg.Text, "Здравствуйте") {
+		t.Errorf("Expect an email in russian. Got %v", msg.Text)
+	}
+
+	if _, code := VerifyEmail(1, sentCode(t, "new_albert@gmail.com"), misc.Client{}); code != misc.NothingToReport {
+		t.Errorf("Expect a code of a translated email to work. Got %v", code)
+	}
+}
+
+func TestSetRole(t *testing.T) {
+	o.CleanUpDb()
+
+	marie, _ := Login("marie@gmail.com", "password", misc.Client{})
+	if token, err := auth.ValidateJWT(marie.Jwt); err != nil || token.Role != misc.RoleUser {
+		t.Errorf("Expect a regular user. Got %v, %v", token, err)
+	}
+
+	table := []struct {
+		userId int
+		role   string
+		code   int
+	}{
+		{0, misc.RoleModerator, misc.NothingUpdated},
+		{100, misc.RoleModerator, misc.NothingUpdated},
+		{3, "root", misc.WrongRole},
+		{3, "", misc.WrongRole},
+		{3, misc.RoleModerator, misc.NothingToReport},
+	}
+	for num, v := range table {
+		if code := SetRole(v.userId, v.role); code != v.code {
+			t.Errorf("Case %v. Expect %v. Got %v", num, v.code, code)
+		}
+	}
+
+	// a token with the old role can't be used anymore
+	token, err := auth.ValidateJWT(marie.Jwt)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if session.IsActive(token.SessionId, token.UserId) {
+		t.Error("Expect sessions to be revoked after a role is changed")
+	}
 
-	if _, ok := VerifyEmail(10, "pqaJaBRgAvzLXqzRrrUI"); !ok {
-		t.Errorf("Expect to verify email. Got False")
+	marie, _ = Login("marie@gmail.com", "password", misc.Client{})
+	if token, err := auth.ValidateJWT(marie.Jwt); err != nil || token.Role != misc.RoleModerator {
+		t.Errorf("Expect a moderator. Got %v, %v", token, err)
 	}
 }
@@ -9,10 +9,15 @@ import (
 	"fmt"
 	"github.com/lib/pq"
 	"log"
+	"strconv"
+	"strings"
 )
 
 var Db *sql.DB
 
+// maxRetries is how many times a transaction is repeated after a serialization failure or a deadlock
+const maxRetries = 3
+
 // Init prepares the database abstraction for later use
 func Init() {
 	// It does not establish any connections to the database, nor does it validate driver
@@ -32,6 +37,50 @@ func Init() {
 	}
 }
 
+// Transaction runs fn inside of a transaction. The transaction is committed if fn returns no error
+// (fn can still return a code for a client) and is rolled back otherwise. After a serialization
+// failure or a deadlock the whole fn is repeated, so it should not have side effects outside of the
+// database. Returns the error and the code of fn
+func Transaction(fn func(tx *sql.Tx) (error, int)) (error, int) {
+	for attempt := 0; ; attempt++ {
+		err, code := runTransaction(fn)
+		if err == nil || !isRetryable(err) || attempt == maxRetries {
+			return err, code
+		}
+		log.Println("Repeating transaction", err)
+	}
+}
+
+func runTransaction(fn func(tx *sql.Tx) (error, int)) (error, int) {
+	tx, err := Db.Begin()
+	if err != nil {
+		log.Println(err)
+		return err, misc.NothingToReport
+	}
+	defer tx.Rollback()
+
+	err, code := fn(tx)
+	if err != nil {
+		return err, code
+	}
+
+	if err := tx.Commit(); err != nil {
+		log.Println(err)
+		return err, misc.NothingToReport
+	}
+
+	return nil, code
+}
+
+// isRetryable checks whether a transaction failed only because of concurrent transactions
+func isRetryable(err error) bool {
+	if errPg, ok := err.(*pq.Error); ok {
+		s := string(errPg.Code)
+		return s == "40001" || s == "40P01"
+	}
+	return false
+}
+
 // IsAffectedOneRow checks that the result of a query executed with Exec has modified only 1 row
 func IsAffectedOneRow(sqlResult sql.Result) (error, int) {
 	affectedRows, err := sqlResult.RowsAffected()
@@ -67,3 +116,35 @@ func CheckSpecificDriverErrors(err error) (error, int) {
 
 	return err, misc.NothingToReport
 }
+
+// FormatIntArray converts a slice into a text form of psql integer[] like {1,2,3}
+func FormatIntArray(ints []int) string {
+	strs := make([]string, len(ints))
+	for i, v := range ints {
+		strs[i] = strconv.Itoa(v)
+	}
+
+	return "{" + strings.Join(strs, ",") + "}"
+}
+
+// ParseIntArray converts a psql integer[] in a text form like {1,2,3} into a slice
+func ParseIntArray(s string) ([]int, error) {
+	if len(s) < 2 || s[0] != '{' || s[len(s)-1] != '}' {
+		return nil, errors.New(fmt.Sprintf("Not an array: %s", s))
+	}
+
+	ints := []int{}
+	if len(s) == 2 {
+		return ints, nil
+	}
+
+	for _, v := range strings.Split(s[1:len(s)-1], ",") {
+		i, err := strconv.Atoi(v)
+		if err != nil {
+			return nil, err
+		}
+		ints = append(ints, i)
+	}
+
+	return ints, nil
+}
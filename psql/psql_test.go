@@ -0,0 +1,130 @@
+package psql
+
+import (
+	"../config"
+	"../misc"
+	"database/sql"
+	"errors"
+	"github.com/lib/pq"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+)
+
+// tests use their own table, so they do not depend on the schema of the project
+func TestMain(m *testing.M) {
+	config.Init()
+	Init()
+	log.SetOutput(ioutil.Discard)
+	if _, err := Db.Exec(`CREATE TABLE IF NOT EXISTS transaction_tests (id integer PRIMARY KEY)`); err != nil {
+		log.Fatal(err)
+	}
+	retCode := m.Run()
+
+	Db.Exec(`DROP TABLE transaction_tests`)
+	Db.Close()
+	os.Exit(retCode)
+}
+
+// insert adds a row inside of a transaction
+func insert(tx *sql.Tx, id int) (error, int) {
+	_, err := tx.Exec(`INSERT INTO transaction_tests (id) VALUES ($1)`, id)
+	return CheckSpecificDriverErrors(err)
+}
+
+func isStored(id int) bool {
+	isStored := false
+	Db.QueryRow(`SELECT EXISTS (SELECT 1 FROM transaction_tests WHERE id = $1)`, id).Scan(&isStored)
+	return isStored
+}
+
+func TestTransactionCommit(t *testing.T) {
+	Db.Exec(`DELETE FROM transaction_tests`)
+
+	err, code := Transaction(func(tx *sql.Tx) (error, int) {
+		if err, code := insert(tx, 1); err != nil {
+			return err, code
+		}
+		return nil, misc.NothingUpdated // a code for a client does not prevent a commit
+	})
+	if err != nil || code != misc.NothingUpdated || !isStored(1) {
+		t.Errorf("Expect a committed transaction. Got %v, %v", err, code)
+	}
+}
+
+func TestTransactionRollback(t *testing.T) {
+	Db.Exec(`DELETE FROM transaction_tests`)
+
+	table := []struct {
+		fn   func(tx *sql.Tx) (error, int)
+		code int
+	}{
+		{func(tx *sql.Tx) (error, int) {
+			insert(tx, 1)
+			return errors.New("fn failed"), misc.WrongName
+		}, misc.WrongName},
+		{func(tx *sql.Tx) (error, int) {
+			insert(tx, 1)
+			return insert(tx, 1)
+		}, misc.DbDuplicate},
+	}
+	for num, v := range table {
+		if err, code := Transaction(v.fn); err == nil || code != v.code || isStored(1) {
+			t.Errorf("Case %v. Expect a rolled back transaction with %v. Got %v, %v", num, v.code, err, code)
+		}
+	}
+}
+
+func TestTransactionRetry(t *testing.T) {
+	Db.Exec(`DELETE FROM transaction_tests`)
+
+	table := []struct {
+		failures int   // how many times fn fails before it succeeds
+		err      error // error of a failure
+		attempts int
+		isStored bool
+	}{
+		{0, nil, 1, true},
+		{2, &pq.Error{Code: "40001"}, 3, true},
+		{1, &pq.Error{Code: "40P01"}, 2, true},
+		{100, &pq.Error{Code: "40001"}, maxRetries + 1, false},
+		{100, &pq.Error{Code: "23505"}, 1, false},
+		{100, errors.New("not a driver error"), 1, false},
+	}
+	for num, v := range table {
+		attempts := 0
+		err, _ := Transaction(func(tx *sql.Tx) (error, int) {
+			attempts++
+			if err, code := insert(tx, num); err != nil {
+				return err, code
+			}
+
+			if attempts <= v.failures {
+				return v.err, misc.NothingToReport
+			}
+			return nil, misc.NothingToReport
+		})
+		if attempts != v.attempts || (err == nil) != v.isStored || isStored(num) != v.isStored {
+			t.Errorf("Case %v. Expect %v attempts. Got %v, %v", num, v.attempts, attempts, err)
+		}
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	table := []struct {
+		err         error
+		isRetryable bool
+	}{
+		{&pq.Error{Code: "40001"}, true},
+		{&pq.Error{Code: "40P01"}, true},
+		{&pq.Error{Code: "23505"}, false},
+		{errors.New("40001"), false},
+		{sql.ErrNoRows, false},
+	}
+	for num, v := range table {
+		if isRetryable(v.err) != v.isRetryable {
+			t.Errorf("Case %v. Expect %v for %v", num, v.isRetryable, v.err)
+		}
+	}
+}
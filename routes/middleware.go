@@ -0,0 +1,92 @@
+package routes
+
+import (
+	"../auth"
+	"../misc"
+	"../models/session"
+	"context"
+	"github.com/dimfeld/httptreemux"
+	"net/http"
+)
+
+// Policy describes who is allowed to call a route
+type Policy int
+
+const (
+	Anonymous Policy = iota // anyone, a token is not even checked
+	LoggedIn                // anyone with an active session, even if an email is not verified
+	Verified                // users with an active session and a verified email
+	Moderator               // verified moderators and administrators
+	Admin                   // verified administrators
+)
+
+type contextKey int
+
+const jwtTokenKey contextKey = 0
+
+// Auth wraps a handler and calls it only if a request satisfies a policy. A validated jwt token is
+// put on the request context, so a handler can get it with currentJwtToken. A request without a valid
+// token gets Unauthorized, a token which does not satisfy a policy gets Forbidden
+func Auth(policy Policy, handler httptreemux.HandlerFunc) httptreemux.HandlerFunc {
+	if policy == Anonymous {
+		return handler
+	}
+
+	return func(w http.ResponseWriter, r *http.Request, ps map[string]string) {
+		jwtToken, ok := validateJwtToken(r)
+		if !ok {
+			w.Header().Set("Content-Type", "application/javascript")
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		if !isAllowed(policy, jwtToken) {
+			w.Header().Set("Content-Type", "application/javascript")
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+
+		handler(w, r.WithContext(context.WithValue(r.Context(), jwtTokenKey, jwtToken)), ps)
+	}
+}
+
+// validateJwtToken parses a token header for a JWT token. It is valid only while its session is
+// (a user can log out or revoke a session)
+func validateJwtToken(r *http.Request) (misc.JwtToken, bool) {
+	jwtToken, err := auth.ValidateJWT(r.Header.Get("token"))
+	if err != nil || jwtToken.SessionId <= 0 {
+		return misc.JwtToken{}, false
+	}
+
+	if !session.IsActive(jwtToken.SessionId, jwtToken.UserId) {
+		return misc.JwtToken{}, false
+	}
+
+	return jwtToken, true
+}
+
+func isAllowed(policy Policy, jwtToken misc.JwtToken) bool {
+	switch policy {
+	case LoggedIn:
+		return true
+	case Verified:
+		return jwtToken.Verified
+	case Moderator:
+		return jwtToken.Verified && (jwtToken.Role == misc.RoleModerator || jwtToken.Role == misc.RoleAdmin)
+	case Admin:
+		return jwtToken.Verified && jwtToken.Role == misc.RoleAdmin
+	}
+	return false
+}
+
+// currentJwtToken returns a token put on the context by Auth. Handlers behind Anonymous routes get
+// an empty token
+func currentJwtToken(r *http.Request) misc.JwtToken {
+	jwtToken, _ := r.Context().Value(jwtTokenKey).(misc.JwtToken)
+	return jwtToken
+}
+
+// currentUserId returns an id of a user who sends a request or 0 for Anonymous routes
+func currentUserId(r *http.Request) int {
+	return currentJwtToken(r).UserId
+}
@@ -0,0 +1,84 @@
+package routes
+
+import (
+	"../auth"
+	"../config"
+	"../misc"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	config.Init()
+	auth.Init()
+	retCode := m.Run()
+	os.Exit(retCode)
+}
+
+func TestAuthRejects(t *testing.T) {
+	// a token without a session can't be used for anything but Anonymous routes
+	noSession, err := auth.CreateJWT(1, 0, true, misc.RoleUser)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	table := []struct {
+		policy Policy
+		token  string
+		status int
+	}{
+		{Anonymous, "", http.StatusOK},
+		{Anonymous, "wrong.token.asdf", http.StatusOK},
+		{LoggedIn, "", http.StatusUnauthorized},
+		{Verified, "wrong.token.asdf", http.StatusUnauthorized},
+		{Verified, noSession, http.StatusUnauthorized},
+		{Admin, noSession, http.StatusUnauthorized},
+	}
+	for num, v := range table {
+		isCalled := false
+		handler := Auth(v.policy, func(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+			isCalled = true
+			if currentUserId(r) != 0 {
+				t.Errorf("Case %v. Expect no user for an anonymous request. Got %v", num, currentJwtToken(r))
+			}
+			w.WriteHeader(http.StatusOK)
+		})
+
+		r := httptest.NewRequest("GET", "/", nil)
+		r.Header.Set("token", v.token)
+		w := httptest.NewRecorder()
+		handler(w, r, map[string]string{})
+
+		if w.Code != v.status || isCalled != (v.status == http.StatusOK) {
+			t.Errorf("Case %v. Expect %v. Got %v, called: %v", num, v.status, w.Code, isCalled)
+		}
+	}
+}
+
+func TestIsAllowed(t *testing.T) {
+	table := []struct {
+		policy   Policy
+		jwtToken misc.JwtToken
+		allowed  bool
+	}{
+		{LoggedIn, misc.JwtToken{UserId: 1, Verified: false}, true},
+		{LoggedIn, misc.JwtToken{UserId: 1, Verified: true}, true},
+		{Verified, misc.JwtToken{UserId: 1, Verified: false}, false},
+		{Verified, misc.JwtToken{UserId: 1, Verified: true}, true},
+		{Moderator, misc.JwtToken{UserId: 1, Verified: true, Role: misc.RoleUser}, false},
+		{Moderator, misc.JwtToken{UserId: 1, Verified: false, Role: misc.RoleModerator}, false},
+		{Moderator, misc.JwtToken{UserId: 1, Verified: true, Role: misc.RoleModerator}, true},
+		{Moderator, misc.JwtToken{UserId: 1, Verified: true, Role: misc.RoleAdmin}, true},
+		{Admin, misc.JwtToken{UserId: 1, Verified: true}, false},
+		{Admin, misc.JwtToken{UserId: 1, Verified: true, Role: misc.RoleModerator}, false},
+		{Admin, misc.JwtToken{UserId: 1, Verified: false, Role: misc.RoleAdmin}, false},
+		{Admin, misc.JwtToken{UserId: 1, Verified: true, Role: misc.RoleAdmin}, true},
+	}
+	for num, v := range table {
+		if allowed := isAllowed(v.policy, v.jwtToken); allowed != v.allowed {
+			t.Errorf("Case %v. Expect %v. Got %v", num, v.allowed, allowed)
+		}
+	}
+}
@@ -6,14 +6,24 @@ import (
 	"../imager"
 	"../misc"
 	"../models/brand"
+	"../models/mfa"
+	"../models/outbox"
+	"../models/proposal"
 	"../models/purchase"
+	"../models/question"
+	"../models/session"
 	"../models/tag"
+	"../models/upload"
 	"../models/user"
+	"../throttle"
 	"encoding/json"
 	"io/ioutil"
 	"log"
+	"math"
+	"net"
 	"net/http"
 	"strconv"
+	"strings"
 )
 
 // sendJson sends a JSON back to a client with a status Code. Makes error checking
@@ -43,6 +53,7 @@ func readJson(r *http.Request, w http.ResponseWriter) ([]byte, bool) {
 // written in the log, but a client should not know about them at all
 // In all other reasons server sends just a code number to represent a problem. Codes below 200
 // represents failure to find something. Was looking by a userId/purchaseID and has found nothing.
+// Codes from 500 are failures of the server (the database is down), not mistakes of a client
 func isCodeTrivial(code int, w http.ResponseWriter) bool {
 	if code == misc.NothingToReport {
 		return true
@@ -53,6 +64,11 @@ func isCodeTrivial(code int, w http.ResponseWriter) bool {
 		return false
 	}
 
+	if code >= misc.DbError {
+		sendJson(w, misc.ErrorCode{code}, http.StatusInternalServerError)
+		return false
+	}
+
 	sendJson(w, misc.ErrorCode{code}, http.StatusBadRequest)
 	return false
 }
@@ -68,37 +84,42 @@ func validateNumeric(w http.ResponseWriter, id string) int {
 	return id_valid
 }
 
-// getUserId parses a token header for a JWT token. If found, it is validated and a userId is returned
-// otherwise it returns 0. If ResponseWriter is specified, it additionally sends Unauthorized header
-func getUserId(r *http.Request, w http.ResponseWriter) int {
-	jwt := r.Header.Get("token")
-	if len(jwt) == 0 {
-		if w != nil {
-			w.WriteHeader(http.StatusUnauthorized)
-		}
-		return 0
+// getClient describes who sends a request. It is stored together with a session
+func getClient(r *http.Request) misc.Client {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
 	}
 
-	jwtToken, err := auth.ValidateJWT(jwt)
-	if err != nil {
-		if w != nil {
-			w.WriteHeader(http.StatusUnauthorized)
-		}
-		return 0
+	userAgent := r.UserAgent()
+	if len(userAgent) > misc.MaxLenUserAgent {
+		userAgent = userAgent[:misc.MaxLenUserAgent]
 	}
 
-	if !jwtToken.Verified {
-		w.WriteHeader(http.StatusUnauthorized)
-		return 0
+	return misc.Client{userAgent, ip}
+}
+
+// readPage extracts pagination parameters (cursor, limit) from the query string. If a limit is not
+// specified, a default page size is used. If a limit is not a number, sends a BadRequest
+func readPage(r *http.Request, w http.ResponseWriter) (string, int, bool) {
+	query := r.URL.Query()
+	if query.Get("limit") == "" {
+		return query.Get("cursor"), misc.PageSize, true
+	}
+
+	limit, err := strconv.Atoi(query.Get("limit"))
+	if err != nil {
+		sendJson(w, misc.ErrorCode{misc.WrongPageSize}, http.StatusBadRequest)
+		return "", 0, false
 	}
 
-	return jwtToken.UserId
+	return query.Get("cursor"), limit, true
 }
 
-// extractPurchasesWithId simplifies extracting many purchases knowing some id
-type getPurchasesHelper func(int) ([]*misc.Purchase, int)
+// extractPurchasesWithId simplifies extracting a page of purchases knowing some id
+type getPurchasesHelper func(int, string, int) (misc.PurchasePage, int)
 
-func extractPurchasesHelperSendJson(getData getPurchasesHelper, w http.ResponseWriter, ps map[string]string) {
+func extractPurchasesHelperSendJson(getData getPurchasesHelper, w http.ResponseWriter, r *http.Request, ps map[string]string) {
 	w.Header().Set("Content-Type", "application/javascript")
 
 	id := validateNumeric(w, ps["id"])
@@ -106,7 +127,12 @@ func extractPurchasesHelperSendJson(getData getPurchasesHelper, w http.ResponseW
 		return
 	}
 
-	if data, code := getData(id); isCodeTrivial(code, w) {
+	cursor, limit, ok := readPage(r, w)
+	if !ok {
+		return
+	}
+
+	if data, code := getData(id, cursor, limit); isCodeTrivial(code, w) {
 		sendJson(w, data, http.StatusOK)
 	}
 }
@@ -145,10 +171,6 @@ func CreateBrand(w http.ResponseWriter, r *http.Request, _ map[string]string) {
 		json.Unmarshal(body, &data)
 	}
 
-	if getUserId(r, w) == 0 {
-		return
-	}
-
 	if id, code := brand.Create(data.Name); isCodeTrivial(code, w) {
 		sendJson(w, misc.Id{id}, http.StatusCreated)
 	}
@@ -170,10 +192,6 @@ func UpdateBrand(w http.ResponseWriter, r *http.Request, ps map[string]string) {
 		json.Unmarshal(body, &data)
 	}
 
-	if getUserId(r, w) == 0 {
-		return
-	}
-
 	if code := brand.Update(id, data.Name); isCodeTrivial(code, w) {
 		sendJson(w, nil, http.StatusNoContent)
 	}
@@ -213,10 +231,6 @@ func CreateTag(w http.ResponseWriter, r *http.Request, _ map[string]string) {
 		json.Unmarshal(body, &data)
 	}
 
-	if getUserId(r, w) == 0 {
-		return
-	}
-
 	if id, code := tag.Create(data.Name, data.Descr); isCodeTrivial(code, w) {
 		sendJson(w, misc.Id{id}, http.StatusCreated)
 	}
@@ -238,10 +252,6 @@ func UpdateTag(w http.ResponseWriter, r *http.Request, ps map[string]string) {
 		json.Unmarshal(body, &data)
 	}
 
-	if getUserId(r, w) == 0 {
-		return
-	}
-
 	if code := tag.Update(id, data.Name, data.Descr); isCodeTrivial(code, w) {
 		sendJson(w, nil, http.StatusNoContent)
 	}
@@ -272,12 +282,61 @@ func UpdateUser(w http.ResponseWriter, r *http.Request, ps map[string]string) {
 		json.Unmarshal(body, &data)
 	}
 
-	userId := getUserId(r, w)
-	if userId == 0 {
+	userId := currentUserId(r)
+
+	if code := user.Update(userId, data.Nickname, data.About, data.Avatar); isCodeTrivial(code, w) {
+		sendJson(w, nil, http.StatusNoContent)
+	}
+}
+
+// ChangePassword sets a new password of a current user. Other sessions of the user are revoked
+func ChangePassword(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	w.Header().Set("Content-Type", "application/javascript")
+
+	var data misc.JsonPasswordNewPassword
+	if body, ok := readJson(r, w); !ok {
 		return
+	} else {
+		json.Unmarshal(body, &data)
 	}
 
-	if code := user.Update(userId, data.Nickname, data.About, data.Avatar); isCodeTrivial(code, w) {
+	jwtToken := currentJwtToken(r)
+
+	if code := user.ChangePassword(jwtToken.UserId, jwtToken.SessionId, data.Password, data.NewPassword); isCodeTrivial(code, w) {
+		sendJson(w, nil, http.StatusNoContent)
+	}
+}
+
+// ChangeEmail sends a confirmation code to a new email of a current user
+func ChangeEmail(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	w.Header().Set("Content-Type", "application/javascript")
+
+	var data misc.JsonEmail
+	if body, ok := readJson(r, w); !ok {
+		return
+	} else {
+		json.Unmarshal(body, &data)
+	}
+
+	userId := currentUserId(r)
+
+	if code := user.ChangeEmail(userId, data.Email); isCodeTrivial(code, w) {
+		sendJson(w, nil, http.StatusNoContent)
+	}
+}
+
+// SetLanguage chooses a language of emails of a current user
+func SetLanguage(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	w.Header().Set("Content-Type", "application/javascript")
+
+	var data misc.JsonLanguage
+	if body, ok := readJson(r, w); !ok {
+		return
+	} else {
+		json.Unmarshal(body, &data)
+	}
+
+	if code := user.SetLanguage(currentUserId(r), data.Language); isCodeTrivial(code, w) {
 		sendJson(w, nil, http.StatusNoContent)
 	}
 }
@@ -291,10 +350,7 @@ func Follow(w http.ResponseWriter, r *http.Request, ps map[string]string) {
 		return
 	}
 
-	userId := getUserId(r, w)
-	if userId == 0 {
-		return
-	}
+	userId := currentUserId(r)
 
 	if code := user.Follow(userId, id); isCodeTrivial(code, w) {
 		sendJson(w, nil, http.StatusNoContent)
@@ -310,10 +366,7 @@ func Unfollow(w http.ResponseWriter, r *http.Request, ps map[string]string) {
 		return
 	}
 
-	userId := getUserId(r, w)
-	if userId == 0 {
-		return
-	}
+	userId := currentUserId(r)
 
 	if code := user.Unfollow(userId, id); isCodeTrivial(code, w) {
 		sendJson(w, nil, http.StatusNoContent)
@@ -348,7 +401,82 @@ func GetFollowers(w http.ResponseWriter, r *http.Request, ps map[string]string)
 	}
 }
 
-// Login returns a jwt token if a user passed correct credentials
+// GetPreferences returns all tags and brands which a current user likes or ignores
+func GetPreferences(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	w.Header().Set("Content-Type", "application/javascript")
+
+	userId := currentUserId(r)
+
+	if prefs, code := user.ShowPreferences(userId); isCodeTrivial(code, w) {
+		sendJson(w, prefs, http.StatusOK)
+	}
+}
+
+// changePreferenceHelper adds a tag/brand with id to the preferences of a current user or removes it
+func changePreferenceHelper(pref user.Preference, isAdding bool, w http.ResponseWriter, r *http.Request, ps map[string]string) {
+	w.Header().Set("Content-Type", "application/javascript")
+
+	id := validateNumeric(w, ps["id"])
+	if id <= 0 {
+		return
+	}
+
+	userId := currentUserId(r)
+
+	code := 0
+	if isAdding {
+		code = user.AddPreference(userId, pref, id)
+	} else {
+		code = user.RemovePreference(userId, pref, id)
+	}
+
+	if isCodeTrivial(code, w) {
+		sendJson(w, nil, http.StatusNoContent)
+	}
+}
+
+// LikeTag adds a tag to the liked tags of a current user
+func LikeTag(w http.ResponseWriter, r *http.Request, ps map[string]string) {
+	changePreferenceHelper(user.TagsLike, true, w, r, ps)
+}
+
+// UnlikeTag removes a tag from the liked tags of a current user
+func UnlikeTag(w http.ResponseWriter, r *http.Request, ps map[string]string) {
+	changePreferenceHelper(user.TagsLike, false, w, r, ps)
+}
+
+// IgnoreTag adds a tag to the ignored tags of a current user
+func IgnoreTag(w http.ResponseWriter, r *http.Request, ps map[string]string) {
+	changePreferenceHelper(user.TagsIgnore, true, w, r, ps)
+}
+
+// UnignoreTag removes a tag from the ignored tags of a current user
+func UnignoreTag(w http.ResponseWriter, r *http.Request, ps map[string]string) {
+	changePreferenceHelper(user.TagsIgnore, false, w, r, ps)
+}
+
+// LikeBrand adds a brand to the liked brands of a current user
+func LikeBrand(w http.ResponseWriter, r *http.Request, ps map[string]string) {
+	changePreferenceHelper(user.BrandsLike, true, w, r, ps)
+}
+
+// UnlikeBrand removes a brand from the liked brands of a current user
+func UnlikeBrand(w http.ResponseWriter, r *http.Request, ps map[string]string) {
+	changePreferenceHelper(user.BrandsLike, false, w, r, ps)
+}
+
+// IgnoreBrand adds a brand to the ignored brands of a current user
+func IgnoreBrand(w http.ResponseWriter, r *http.Request, ps map[string]string) {
+	changePreferenceHelper(user.BrandsIgnore, true, w, r, ps)
+}
+
+// UnignoreBrand removes a brand from the ignored brands of a current user
+func UnignoreBrand(w http.ResponseWriter, r *http.Request, ps map[string]string) {
+	changePreferenceHelper(user.BrandsIgnore, false, w, r, ps)
+}
+
+// Login returns a jwt token and a refresh token if a user passed correct credentials. Too many failed
+// attempts for an account or from an IP are answered with TooManyRequests and Retry-After
 func Login(w http.ResponseWriter, r *http.Request, _ map[string]string) {
 	w.Header().Set("Content-Type", "application/javascript")
 
@@ -359,21 +487,181 @@ func Login(w http.ResponseWriter, r *http.Request, _ map[string]string) {
 		json.Unmarshal(body, &data)
 	}
 
-	if jwt, ok := user.Login(data.Email, data.Password); ok {
-		sendJson(w, misc.Jwt{jwt}, http.StatusOK)
+	// emails are case insensitive, so every spelling of an email is counted as one account
+	client, account := getClient(r), strings.ToLower(strings.TrimSpace(data.Email))
+	if wait := throttle.Logins.Blocked(account, client.Ip); wait > 0 {
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+		sendJson(w, misc.ErrorCode{misc.TooManyAttempts}, http.StatusTooManyRequests)
+		return
+	}
+
+	if tokens, ok := user.Login(data.Email, data.Password, client); ok {
+		// with 2FA the login is finished only by LoginMfa, which forgets the failures
+		if tokens.Mfa_token == "" {
+			throttle.Logins.Succeed(account)
+		}
+		sendJson(w, tokens, http.StatusOK)
 	} else {
+		if throttle.Logins.Fail(account, client.Ip) {
+			go user.NotifyLockout(account, int(throttle.AccountLimits.LockoutFor.Minutes()))
+		}
 		w.WriteHeader(http.StatusUnauthorized)
 	}
 }
 
-// ExtendJwt takes a valid JWT token and issues a new one with a full TTL
-func ExtendJwt(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+// RefreshToken exchanges a refresh token for a new jwt token and a new refresh token
+func RefreshToken(w http.ResponseWriter, r *http.Request, _ map[string]string) {
 	w.Header().Set("Content-Type", "application/javascript")
 
-	if jwt, err := auth.ExtendJWT(r.Header.Get("token")); err != nil {
-		w.WriteHeader(http.StatusUnauthorized)
+	var data misc.JsonRefreshToken
+	if body, ok := readJson(r, w); !ok {
+		return
 	} else {
-		sendJson(w, misc.Jwt{jwt}, http.StatusOK)
+		json.Unmarshal(body, &data)
+	}
+
+	if tokens, code := session.Refresh(data.Refresh_token, getClient(r)); isCodeTrivial(code, w) {
+		sendJson(w, tokens, http.StatusOK)
+	}
+}
+
+// Logout revokes the session of a current jwt token
+func Logout(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	w.Header().Set("Content-Type", "application/javascript")
+
+	jwtToken := currentJwtToken(r)
+
+	if code := session.Revoke(jwtToken.SessionId, jwtToken.UserId); isCodeTrivial(code, w) {
+		sendJson(w, nil, http.StatusNoContent)
+	}
+}
+
+// GetSessions returns all places where a current user is logged in
+func GetSessions(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	w.Header().Set("Content-Type", "application/javascript")
+
+	jwtToken := currentJwtToken(r)
+
+	if sessions, code := session.ShowByUserId(jwtToken.UserId, jwtToken.SessionId); isCodeTrivial(code, w) {
+		sendJson(w, sessions, http.StatusOK)
+	}
+}
+
+// DeleteSession revokes one of the sessions of a current user
+func DeleteSession(w http.ResponseWriter, r *http.Request, ps map[string]string) {
+	w.Header().Set("Content-Type", "application/javascript")
+
+	id := validateNumeric(w, ps["id"])
+	if id <= 0 {
+		return
+	}
+
+	userId := currentUserId(r)
+
+	if code := session.Revoke(id, userId); isCodeTrivial(code, w) {
+		sendJson(w, nil, http.StatusNoContent)
+	}
+}
+
+// LoginMfa finishes a login of a user with 2FA. Exchanges a token from Login and a code for tokens.
+// A wrong code is a failed login of the account, so guessing codes ends with a lockout
+func LoginMfa(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	w.Header().Set("Content-Type", "application/javascript")
+
+	var data misc.JsonMfaTokenCode
+	if body, ok := readJson(r, w); !ok {
+		return
+	} else {
+		json.Unmarshal(body, &data)
+	}
+
+	client := getClient(r)
+	tokens, account, code := mfa.Exchange(data.Mfa_token, data.Code, client)
+	switch code {
+	case misc.NothingToReport:
+		throttle.Logins.Succeed(account)
+	case misc.WrongMfaCode:
+		if throttle.Logins.Fail(account, client.Ip) {
+			go user.NotifyLockout(account, int(throttle.AccountLimits.LockoutFor.Minutes()))
+		}
+	}
+
+	if isCodeTrivial(code, w) {
+		sendJson(w, tokens, http.StatusOK)
+	}
+}
+
+// SetupMfa creates a new TOTP secret of a current user. 2FA is enabled only after ConfirmMfa
+func SetupMfa(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	w.Header().Set("Content-Type", "application/javascript")
+
+	if setup, code := mfa.Setup(currentUserId(r)); isCodeTrivial(code, w) {
+		sendJson(w, setup, http.StatusOK)
+	}
+}
+
+// ConfirmMfa enables 2FA of a current user if a code from an app is correct. Returns recovery codes
+func ConfirmMfa(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	w.Header().Set("Content-Type", "application/javascript")
+
+	var data misc.JsonCode
+	if body, ok := readJson(r, w); !ok {
+		return
+	} else {
+		json.Unmarshal(body, &data)
+	}
+
+	if codes, code := mfa.Confirm(currentUserId(r), data.Code); isCodeTrivial(code, w) {
+		sendJson(w, codes, http.StatusOK)
+	}
+}
+
+// DisableMfa turns 2FA of a current user off. Needs a code from an app or a recovery code
+func DisableMfa(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	w.Header().Set("Content-Type", "application/javascript")
+
+	var data misc.JsonCode
+	if body, ok := readJson(r, w); !ok {
+		return
+	} else {
+		json.Unmarshal(body, &data)
+	}
+
+	if code := mfa.Disable(currentUserId(r), data.Code); isCodeTrivial(code, w) {
+		sendJson(w, nil, http.StatusNoContent)
+	}
+}
+
+// ForgotPassword emails a token to reset a password. It always responds with Accepted, so nobody can
+// check whether an email is registered
+func ForgotPassword(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	w.Header().Set("Content-Type", "application/javascript")
+
+	var data misc.JsonEmail
+	if body, ok := readJson(r, w); !ok {
+		return
+	} else {
+		json.Unmarshal(body, &data)
+	}
+
+	// sending an email takes time. Response should not be slower for registered emails
+	go user.RequestPasswordReset(data.Email)
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// ResetPassword sets a new password using a token from ForgotPassword email
+func ResetPassword(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	w.Header().Set("Content-Type", "application/javascript")
+
+	var data misc.JsonTokenPassword
+	if body, ok := readJson(r, w); !ok {
+		return
+	} else {
+		json.Unmarshal(body, &data)
+	}
+
+	if code := user.ResetPassword(data.Token, data.Password); isCodeTrivial(code, w) {
+		sendJson(w, nil, http.StatusNoContent)
 	}
 }
 
@@ -393,7 +681,7 @@ func CreateUser(w http.ResponseWriter, r *http.Request, _ map[string]string) {
 	}
 }
 
-// VerifyEmail verifies a previously unconfirmed user
+// VerifyEmail verifies a previously unconfirmed user or a new email of a user
 func VerifyEmail(w http.ResponseWriter, r *http.Request, ps map[string]string) {
 	w.Header().Set("Content-Type", "application/javascript")
 
@@ -402,35 +690,70 @@ func VerifyEmail(w http.ResponseWriter, r *http.Request, ps map[string]string) {
 		return
 	}
 
-	if jwt, ok := user.VerifyEmail(userId, ps["code"]); ok {
-		sendJson(w, misc.Jwt{jwt}, http.StatusOK)
-	} else {
-		w.WriteHeader(http.StatusBadRequest)
+	if tokens, code := user.VerifyEmail(userId, ps["code"], getClient(r)); isCodeTrivial(code, w) {
+		sendJson(w, tokens, http.StatusOK)
+	}
+}
+
+// ResendConfirmation sends a new confirmation code to an unconfirmed email of a current user
+func ResendConfirmation(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	w.Header().Set("Content-Type", "application/javascript")
+
+	code := user.ResendConfirmation(currentUserId(r))
+	if code == misc.TooManyAttempts {
+		w.Header().Set("Retry-After", strconv.Itoa(misc.ConfCodeResend*60))
+		sendJson(w, misc.ErrorCode{code}, http.StatusTooManyRequests)
+		return
+	}
+
+	if isCodeTrivial(code, w) {
+		sendJson(w, nil, http.StatusNoContent)
 	}
 }
 
-// GetAllPurchases returns all the purchases in reverse order
+// GetAllPurchases returns a page of all the purchases in reverse order
 func GetAllPurchases(w http.ResponseWriter, r *http.Request, ps map[string]string) {
 	w.Header().Set("Content-Type", "application/javascript")
 
-	if purchases, code := purchase.ShowAll(); isCodeTrivial(code, w) {
+	cursor, limit, ok := readPage(r, w)
+	if !ok {
+		return
+	}
+
+	if purchases, code := purchase.ShowAll(cursor, limit); isCodeTrivial(code, w) {
 		sendJson(w, purchases, http.StatusOK)
 	}
 }
 
-// GetUserPurchases returns all the list of all purchases done by this user in reverse order
+// GetUserPurchases returns a page of purchases done by this user in reverse order
 func GetUserPurchases(w http.ResponseWriter, r *http.Request, ps map[string]string) {
-	extractPurchasesHelperSendJson(purchase.ShowByUserId, w, ps)
+	extractPurchasesHelperSendJson(purchase.ShowByUserId, w, r, ps)
 }
 
-// GetAllPurchases returns all the purchases which were tagged with a particular brand
+// GetAllPurchases returns a page of purchases which were tagged with a particular brand
 func GetAllPurchasesWithBrand(w http.ResponseWriter, r *http.Request, ps map[string]string) {
-	extractPurchasesHelperSendJson(purchase.ShowByBrandId, w, ps)
+	extractPurchasesHelperSendJson(purchase.ShowByBrandId, w, r, ps)
 }
 
-// GetAllPurchases returns all the purchases which were tagged with a particular tag
+// GetAllPurchases returns a page of purchases which were tagged with a particular tag
 func GetAllPurchasesWithTag(w http.ResponseWriter, r *http.Request, ps map[string]string) {
-	extractPurchasesHelperSendJson(purchase.ShowByTagId, w, ps)
+	extractPurchasesHelperSendJson(purchase.ShowByTagId, w, r, ps)
+}
+
+// GetFeed returns a page of purchases which might be interesting for a current user
+func GetFeed(w http.ResponseWriter, r *http.Request, ps map[string]string) {
+	w.Header().Set("Content-Type", "application/javascript")
+
+	userId := currentUserId(r)
+
+	cursor, limit, ok := readPage(r, w)
+	if !ok {
+		return
+	}
+
+	if purchases, code := purchase.Feed(userId, cursor, limit); isCodeTrivial(code, w) {
+		sendJson(w, purchases, http.StatusOK)
+	}
 }
 
 // GetPurchase returns full information about a purchase
@@ -458,18 +781,15 @@ func CreatePurchase(w http.ResponseWriter, r *http.Request, ps map[string]string
 		json.Unmarshal(body, &data)
 	}
 
-	userId := getUserId(r, w)
-	if userId == 0 {
-		return
-	}
+	userId := currentUserId(r)
 
 	if id, code := purchase.Create(userId, data.Descr, data.Image, data.BrandId, data.TagIds); isCodeTrivial(code, w) {
 		sendJson(w, misc.Id{id}, http.StatusCreated)
 	}
 }
 
-// LikePurchase allows current user to like a particular purchase
-func LikePurchase(w http.ResponseWriter, r *http.Request, ps map[string]string) {
+// UpdatePurchase allows a current user to change his purchase
+func UpdatePurchase(w http.ResponseWriter, r *http.Request, ps map[string]string) {
 	w.Header().Set("Content-Type", "application/javascript")
 
 	purchaseId := validateNumeric(w, ps["id"])
@@ -477,11 +797,47 @@ func LikePurchase(w http.ResponseWriter, r *http.Request, ps map[string]string)
 		return
 	}
 
-	userId := getUserId(r, w)
-	if userId == 0 {
+	var data misc.JsonDescrImageBrandTag
+	if body, ok := readJson(r, w); !ok {
 		return
+	} else {
+		json.Unmarshal(body, &data)
 	}
 
+	userId := currentUserId(r)
+
+	if code := purchase.Update(purchaseId, userId, data.Descr, data.Image, data.BrandId, data.TagIds); isCodeTrivial(code, w) {
+		sendJson(w, nil, http.StatusNoContent)
+	}
+}
+
+// DeletePurchase allows a current user to delete his purchase
+func DeletePurchase(w http.ResponseWriter, r *http.Request, ps map[string]string) {
+	w.Header().Set("Content-Type", "application/javascript")
+
+	purchaseId := validateNumeric(w, ps["id"])
+	if purchaseId <= 0 {
+		return
+	}
+
+	userId := currentUserId(r)
+
+	if code := purchase.Delete(purchaseId, userId); isCodeTrivial(code, w) {
+		sendJson(w, nil, http.StatusNoContent)
+	}
+}
+
+// LikePurchase allows current user to like a particular purchase
+func LikePurchase(w http.ResponseWriter, r *http.Request, ps map[string]string) {
+	w.Header().Set("Content-Type", "application/javascript")
+
+	purchaseId := validateNumeric(w, ps["id"])
+	if purchaseId <= 0 {
+		return
+	}
+
+	userId := currentUserId(r)
+
 	if code := purchase.Like(purchaseId, userId); isCodeTrivial(code, w) {
 		sendJson(w, nil, http.StatusNoContent)
 	}
@@ -496,10 +852,7 @@ func UnlikePurchase(w http.ResponseWriter, r *http.Request, ps map[string]string
 		return
 	}
 
-	userId := getUserId(r, w)
-	if userId == 0 {
-		return
-	}
+	userId := currentUserId(r)
 
 	if code := purchase.Unlike(purchaseId, userId); isCodeTrivial(code, w) {
 		sendJson(w, nil, http.StatusNoContent)
@@ -522,10 +875,7 @@ func AskQuestion(w http.ResponseWriter, r *http.Request, ps map[string]string) {
 		json.Unmarshal(body, &data)
 	}
 
-	userId := getUserId(r, w)
-	if userId == 0 {
-		return
-	}
+	userId := currentUserId(r)
 
 	if id, code := purchase.AskQuestion(purchaseId, userId, data.Name); isCodeTrivial(code, w) {
 		sendJson(w, misc.Id{id}, http.StatusCreated)
@@ -548,56 +898,279 @@ func AnswerQuestion(w http.ResponseWriter, r *http.Request, ps map[string]string
 		json.Unmarshal(body, &data)
 	}
 
-	userId := getUserId(r, w)
-	if userId == 0 {
-		return
-	}
+	userId := currentUserId(r)
 
 	if id, code := purchase.AnswerQuestion(questionId, userId, data.Name); isCodeTrivial(code, w) {
 		sendJson(w, misc.Id{id}, http.StatusCreated)
 	}
 }
 
-// UploadImageAvatar resizes and stores an avatar on the disk
-func UploadImageAvatar(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+// GetPurchaseQuestions returns all questions about a purchase with their answers
+func GetPurchaseQuestions(w http.ResponseWriter, r *http.Request, ps map[string]string) {
+	w.Header().Set("Content-Type", "application/javascript")
+
+	id := validateNumeric(w, ps["id"])
+	if id <= 0 {
+		return
+	}
+
+	if questions, code := question.ShowByPurchaseId(id, r.URL.Query().Get("sort")); isCodeTrivial(code, w) {
+		sendJson(w, questions, http.StatusOK)
+	}
+}
+
+// GetQuestion returns a question with all its answers
+func GetQuestion(w http.ResponseWriter, r *http.Request, ps map[string]string) {
+	w.Header().Set("Content-Type", "application/javascript")
+
+	id := validateNumeric(w, ps["id"])
+	if id <= 0 {
+		return
+	}
+
+	if q, code := question.ShowById(id); isCodeTrivial(code, w) {
+		sendJson(w, q, http.StatusOK)
+	}
+}
+
+// GetUserQuestions returns all questions asked by a user with their answers
+func GetUserQuestions(w http.ResponseWriter, r *http.Request, ps map[string]string) {
+	w.Header().Set("Content-Type", "application/javascript")
+
+	id := validateNumeric(w, ps["id"])
+	if id <= 0 {
+		return
+	}
+
+	if questions, code := question.ShowByUserId(id, r.URL.Query().Get("sort")); isCodeTrivial(code, w) {
+		sendJson(w, questions, http.StatusOK)
+	}
+}
+
+// GetUserAnswers returns all answers written by a user
+func GetUserAnswers(w http.ResponseWriter, r *http.Request, ps map[string]string) {
 	w.Header().Set("Content-Type", "application/javascript")
 
-	userId := getUserId(r, w)
-	if userId == 0 {
+	id := validateNumeric(w, ps["id"])
+	if id <= 0 {
 		return
 	}
 
+	if answers, code := question.ShowAnswersByUserId(id, r.URL.Query().Get("sort")); isCodeTrivial(code, w) {
+		sendJson(w, answers, http.StatusOK)
+	}
+}
+
+// voteHelper votes up/down for a question/answer with id on behalf of a current user
+func voteHelper(vote func(int, int, bool) int, isUp bool, w http.ResponseWriter, r *http.Request, ps map[string]string) {
+	w.Header().Set("Content-Type", "application/javascript")
+
+	id := validateNumeric(w, ps["id"])
+	if id <= 0 {
+		return
+	}
+
+	userId := currentUserId(r)
+
+	if code := vote(id, userId, isUp); isCodeTrivial(code, w) {
+		sendJson(w, nil, http.StatusNoContent)
+	}
+}
+
+// UpvoteQuestion allows current user to vote up a question
+func UpvoteQuestion(w http.ResponseWriter, r *http.Request, ps map[string]string) {
+	voteHelper(question.VoteQuestion, true, w, r, ps)
+}
+
+// DownvoteQuestion allows current user to vote down a question
+func DownvoteQuestion(w http.ResponseWriter, r *http.Request, ps map[string]string) {
+	voteHelper(question.VoteQuestion, false, w, r, ps)
+}
+
+// UpvoteAnswer allows current user to vote up an answer
+func UpvoteAnswer(w http.ResponseWriter, r *http.Request, ps map[string]string) {
+	voteHelper(question.VoteAnswer, true, w, r, ps)
+}
+
+// DownvoteAnswer allows current user to vote down an answer
+func DownvoteAnswer(w http.ResponseWriter, r *http.Request, ps map[string]string) {
+	voteHelper(question.VoteAnswer, false, w, r, ps)
+}
+
+// UploadImageAvatar resizes and stores an avatar. Only the current user can later use it as an avatar
+func UploadImageAvatar(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	w.Header().Set("Content-Type", "application/javascript")
+
 	ok, fileName, ext := imager.SaveTmpFileFromClient(w, r)
 	if !ok {
 		sendJson(w, misc.ErrorCode{misc.WrongImg}, http.StatusBadRequest)
 		return
 	}
 
-	if ok, file := imager.TmpToAvatar(fileName, ext); !ok {
+	ok, u := imager.TmpToAvatar(fileName, ext)
+	if !ok {
 		sendJson(w, misc.ErrorCode{misc.WrongImg}, http.StatusBadRequest)
-	} else {
-		sendJson(w, misc.Image{file}, http.StatusOK)
+		return
+	}
+
+	// an image which is not recorded can't be used by anyone
+	if !isCodeTrivial(upload.Create(currentUserId(r), u), w) {
+		imager.RemoveAvatar(u.Name)
+		return
 	}
+
+	sendJson(w, misc.Image{u.Name}, http.StatusOK)
 }
 
-// UploadImagePurchase resizes and stores a picture of a purchase on the disk
+// UploadImagePurchase resizes and stores a picture of a purchase. Only the current user can later use it in a purchase
 func UploadImagePurchase(w http.ResponseWriter, r *http.Request, _ map[string]string) {
 	w.Header().Set("Content-Type", "application/javascript")
 
-	userId := getUserId(r, w)
-	if userId == 0 {
+	ok, fileName, ext := imager.SaveTmpFileFromClient(w, r)
+	if !ok {
+		sendJson(w, misc.ErrorCode{misc.WrongImg}, http.StatusBadRequest)
 		return
 	}
 
-	ok, fileName, ext := imager.SaveTmpFileFromClient(w, r)
+	ok, u := imager.TmpToPurchase(fileName, ext)
 	if !ok {
 		sendJson(w, misc.ErrorCode{misc.WrongImg}, http.StatusBadRequest)
 		return
 	}
 
-	if ok, file := imager.TmpToPurchase(fileName, ext); !ok {
-		sendJson(w, misc.ErrorCode{misc.WrongImg}, http.StatusBadRequest)
+	// an image which is not recorded can't be used by anyone
+	if !isCodeTrivial(upload.Create(currentUserId(r), u), w) {
+		imager.RemovePurchase(u.Name)
+		return
+	}
+
+	sendJson(w, misc.Image{u.Name}, http.StatusOK)
+}
+
+// GetAvatarImage sends a big (b) or a small (s) avatar
+func GetAvatarImage(w http.ResponseWriter, r *http.Request, ps map[string]string) {
+	imager.Serve(w, r, "avatars/"+ps["size"]+"/", ps["name"])
+}
+
+// GetPurchaseImage sends a big (b) or a medium (m) picture of a purchase
+func GetPurchaseImage(w http.ResponseWriter, r *http.Request, ps map[string]string) {
+	imager.Serve(w, r, "purchases/"+ps["size"]+"/", ps["name"])
+}
+
+// SetUserRole changes a role of some user. The user has to log in again
+func SetUserRole(w http.ResponseWriter, r *http.Request, ps map[string]string) {
+	w.Header().Set("Content-Type", "application/javascript")
+
+	id := validateNumeric(w, ps["id"])
+	if id <= 0 {
+		return
+	}
+
+	var data misc.JsonRole
+	if body, ok := readJson(r, w); !ok {
+		return
 	} else {
-		sendJson(w, misc.Image{file}, http.StatusOK)
+		json.Unmarshal(body, &data)
+	}
+
+	if code := user.SetRole(id, data.Role); isCodeTrivial(code, w) {
+		sendJson(w, nil, http.StatusNoContent)
+	}
+}
+
+// CreateProposal puts a brand or a tag of a current user in the review queue
+func CreateProposal(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	w.Header().Set("Content-Type", "application/javascript")
+
+	var data misc.JsonKindNameDescr
+	if body, ok := readJson(r, w); !ok {
+		return
+	} else {
+		json.Unmarshal(body, &data)
+	}
+
+	if id, code := proposal.Create(currentUserId(r), data.Kind, data.Name, data.Descr); isCodeTrivial(code, w) {
+		sendJson(w, misc.Id{id}, http.StatusCreated)
+	}
+}
+
+// GetMyProposals returns all proposals of a current user together with their statuses
+func GetMyProposals(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	w.Header().Set("Content-Type", "application/javascript")
+
+	if proposals, code := proposal.ShowByUserId(currentUserId(r)); isCodeTrivial(code, w) {
+		sendJson(w, proposals, http.StatusOK)
+	}
+}
+
+// GetPendingProposals returns the review queue
+func GetPendingProposals(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	w.Header().Set("Content-Type", "application/javascript")
+
+	if proposals, code := proposal.ShowPending(); isCodeTrivial(code, w) {
+		sendJson(w, proposals, http.StatusOK)
+	}
+}
+
+// ApproveProposal creates a brand or a tag from a proposal and returns its id
+func ApproveProposal(w http.ResponseWriter, r *http.Request, ps map[string]string) {
+	w.Header().Set("Content-Type", "application/javascript")
+
+	id := validateNumeric(w, ps["id"])
+	if id <= 0 {
+		return
+	}
+
+	if createdId, code := proposal.Approve(id, currentUserId(r)); isCodeTrivial(code, w) {
+		sendJson(w, misc.Id{createdId}, http.StatusCreated)
+	}
+}
+
+// RejectProposal removes a proposal from the review queue
+func RejectProposal(w http.ResponseWriter, r *http.Request, ps map[string]string) {
+	w.Header().Set("Content-Type", "application/javascript")
+
+	id := validateNumeric(w, ps["id"])
+	if id <= 0 {
+		return
+	}
+
+	if code := proposal.Reject(id, currentUserId(r)); isCodeTrivial(code, w) {
+		sendJson(w, nil, http.StatusNoContent)
+	}
+}
+
+// GetJwks publishes public keys, so other services can validate jwt tokens without a shared secret
+func GetJwks(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=3600")
+	sendJson(w, auth.Keys.Jwks(), http.StatusOK)
+}
+
+// GetFailedEmails returns a page of emails which could not be delivered
+func GetFailedEmails(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	w.Header().Set("Content-Type", "application/javascript")
+
+	cursor, limit, ok := readPage(r, w)
+	if !ok {
+		return
+	}
+
+	if emails, code := outbox.ShowFailed(cursor, limit); isCodeTrivial(code, w) {
+		sendJson(w, emails, http.StatusOK)
+	}
+}
+
+// RetryEmail sends a dead email again
+func RetryEmail(w http.ResponseWriter, r *http.Request, ps map[string]string) {
+	w.Header().Set("Content-Type", "application/javascript")
+
+	id := validateNumeric(w, ps["id"])
+	if id <= 0 {
+		return
+	}
+
+	if code := outbox.Retry(id); isCodeTrivial(code, w) {
+		sendJson(w, nil, http.StatusNoContent)
 	}
 }
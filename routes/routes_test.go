@@ -0,0 +1,28 @@
+package routes
+
+import (
+	"../misc"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestIsCodeTrivial(t *testing.T) {
+	table := []struct {
+		code      int
+		isTrivial bool
+		status    int
+	}{
+		{misc.NothingToReport, true, http.StatusOK},
+		{misc.NoElement, false, http.StatusNotFound},
+		{misc.WrongTags, false, http.StatusBadRequest},
+		{misc.DbDuplicate, false, http.StatusBadRequest},
+		{misc.DbError, false, http.StatusInternalServerError},
+	}
+	for num, v := range table {
+		w := httptest.NewRecorder()
+		if isTrivial := isCodeTrivial(v.code, w); isTrivial != v.isTrivial || w.Code != v.status {
+			t.Errorf("Case %v. Expect %v, %v. Got %v, %v", num, v.isTrivial, v.status, isTrivial, w.Code)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package throttle
+
+import (
+	"sync"
+	"time"
+)
+
+// sweepEvery is how often forgotten keys are removed from a memory tracker
+const sweepEvery = time.Minute
+
+type entry struct {
+	failures     int
+	blockedUntil time.Time
+	lastFailure  time.Time
+}
+
+// MemoryTracker keeps failures in memory. It works only if the service runs as one instance
+type MemoryTracker struct {
+	limits    Limits
+	now       func() time.Time
+	mu        sync.Mutex
+	entries   map[string]*entry
+	lastSweep time.Time
+}
+
+// NewMemoryTracker creates an empty tracker
+func NewMemoryTracker(limits Limits) *MemoryTracker {
+	return &MemoryTracker{limits: limits, now: time.Now, entries: map[string]*entry{}}
+}
+
+// get returns an entry of a key if its failures are not forgotten yet
+func (m *MemoryTracker) get(key string, now time.Time) *entry {
+	e, ok := m.entries[key]
+	if !ok || now.Sub(e.lastFailure) > m.limits.Forget {
+		return nil
+	}
+	return e
+}
+
+// sweep removes forgotten keys, so attempts with random emails do not eat all the memory
+func (m *MemoryTracker) sweep(now time.Time) {
+	if now.Sub(m.lastSweep) < sweepEvery {
+		return
+	}
+
+	for key := range m.entries {
+		if m.get(key, now) == nil {
+			delete(m.entries, key)
+		}
+	}
+	m.lastSweep = now
+}
+
+// Blocked returns for how long the next attempt of a key is not allowed
+func (m *MemoryTracker) Blocked(key string) (time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	if e := m.get(key, now); e != nil && e.blockedUntil.After(now) {
+		return e.blockedUntil.Sub(now), nil
+	}
+	return 0, nil
+}
+
+// Fail registers a failure of a key
+func (m *MemoryTracker) Fail(key string) (int, time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	m.sweep(now)
+
+	e := m.get(key, now)
+	if e == nil {
+		e = &entry{}
+		m.entries[key] = e
+	}
+
+	e.failures++
+	e.lastFailure = now
+	block := m.limits.block(e.failures)
+	e.blockedUntil = now.Add(block)
+	return e.failures, block, nil
+}
+
+// Reset forgets all failures of a key
+func (m *MemoryTracker) Reset(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.entries, key)
+	return nil
+}
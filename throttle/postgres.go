@@ -0,0 +1,93 @@
+package throttle
+
+import (
+	"database/sql"
+	"time"
+)
+
+// PostgresTracker keeps failures in the login_attempts table, so all instances of the service see
+// the same failures. Trackers of different scopes (accounts, IPs) share the table
+type PostgresTracker struct {
+	db     *sql.DB
+	scope  string
+	limits Limits
+	now    func() time.Time
+}
+
+// NewPostgresTracker creates a tracker of a scope
+func NewPostgresTracker(db *sql.DB, scope string, limits Limits) *PostgresTracker {
+	return &PostgresTracker{db, scope, limits, time.Now}
+}
+
+// Blocked returns for how long the next attempt of a key is not allowed
+func (p *PostgresTracker) Blocked(key string) (time.Duration, error) {
+	now := p.now().UTC()
+	var blockedUntil time.Time
+	if err := p.db.QueryRow(`
+		SELECT blocked_until
+		FROM login_attempts
+		WHERE scope = $1 AND key = $2 AND last_failure_at >= $3`, p.scope, key, now.Add(-p.limits.Forget),
+	).Scan(&blockedUntil); err != nil {
+		if err == sql.ErrNoRows {
+			return 0, nil
+		}
+		return 0, err
+	}
+
+	if blockedUntil.After(now) {
+		return blockedUntil.Sub(now), nil
+	}
+	return 0, nil
+}
+
+// Fail registers a failure of a key. Concurrent failures of one key are counted correctly, because
+// the counter is increased by the database
+func (p *PostgresTracker) Fail(key string) (int, time.Duration, error) {
+	now := p.now().UTC()
+	tx, err := p.db.Begin()
+	if err != nil {
+		return 0, 0, err
+	}
+	defer tx.Rollback()
+
+	failures := 0
+	if err := tx.QueryRow(`
+		INSERT INTO login_attempts AS a (scope, key, failures, last_failure_at, blocked_until)
+		VALUES ($1, $2, 1, $3, $3)
+		ON CONFLICT (scope, key) DO UPDATE
+		SET failures = CASE WHEN a.last_failure_at < $4 THEN 1 ELSE a.failures + 1 END, last_failure_at = $3
+		RETURNING failures`, p.scope, key, now, now.Add(-p.limits.Forget),
+	).Scan(&failures); err != nil {
+		return 0, 0, err
+	}
+
+	block := p.limits.block(failures)
+	if _, err := tx.Exec(`
+		UPDATE login_attempts
+		SET blocked_until = $1
+		WHERE scope = $2 AND key = $3`, now.Add(block), p.scope, key,
+	); err != nil {
+		return 0, 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, err
+	}
+
+	// forgotten keys are not needed anymore. Only some calls clean them up to keep logins cheap
+	if failures == 1 {
+		p.db.Exec(`
+			DELETE FROM login_attempts
+			WHERE last_failure_at < $1`, now.Add(-p.limits.Forget))
+	}
+
+	return failures, block, nil
+}
+
+// Reset forgets all failures of a key
+func (p *PostgresTracker) Reset(key string) error {
+	_, err := p.db.Exec(`
+		DELETE FROM login_attempts
+		WHERE scope = $1 AND key = $2`, p.scope, key)
+	return err
+}
@@ -0,0 +1,132 @@
+// Package throttle slows down guessing of passwords. Failed logins are counted per account and per IP.
+// After a couple of free attempts every failure blocks the next attempt for an exponentially growing
+// time and after too many failures the key is locked out. Blocked attempts are rejected before a
+// password is hashed, so brute force can't be used to load the CPU either
+package throttle
+
+import (
+	"../config"
+	"database/sql"
+	"log"
+	"time"
+)
+
+// Tracker counts consecutive failures of keys. Failures of a key are forgotten after a success or
+// after it has not failed for a while
+type Tracker interface {
+	// Blocked returns for how long the next attempt of a key is not allowed. 0 if it is allowed
+	Blocked(key string) (time.Duration, error)
+	// Fail registers a failure of a key. Returns the number of consecutive failures and for how long
+	// the next attempt is blocked
+	Fail(key string) (int, time.Duration, error)
+	// Reset forgets all failures of a key
+	Reset(key string) error
+}
+
+// Limits describes how a tracker reacts to failures
+type Limits struct {
+	Free       int           // number of failures which do not block anything
+	Delay      time.Duration // block after the first not free failure. Doubles after every next one
+	MaxDelay   time.Duration // the longest block before the lockout
+	Lockout    int           // number of failures after which a key is locked out
+	LockoutFor time.Duration // for how long a key is locked out
+	Forget     time.Duration // failures are forgotten if there were none for this time
+}
+
+// block returns for how long a key is blocked after a number of consecutive failures
+func (l Limits) block(failures int) time.Duration {
+	if failures >= l.Lockout {
+		return l.LockoutFor
+	}
+
+	if failures <= l.Free {
+		return 0
+	}
+
+	delay := l.Delay
+	for i := l.Free + 1; i < failures && delay < l.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > l.MaxDelay {
+		return l.MaxDelay
+	}
+	return delay
+}
+
+var (
+	// AccountLimits protect one account from guessing its password
+	AccountLimits = Limits{3, time.Second, time.Minute, 10, 15 * time.Minute, time.Hour}
+	// IpLimits protect from guessing passwords of many accounts. Many users can share an IP, so they
+	// are more lenient
+	IpLimits = Limits{20, time.Second, time.Minute, 100, 15 * time.Minute, time.Hour}
+)
+
+// Logins is a guard of the login route
+var Logins *Guard
+
+// Init creates a guard which stores failures where PROJ_LOGIN_STORE says
+func Init(db *sql.DB) {
+	switch config.Cfg.LoginStore {
+	case "memory":
+		Logins = NewGuard(NewMemoryTracker(AccountLimits), NewMemoryTracker(IpLimits), AccountLimits.Lockout)
+	case "postgres":
+		Logins = NewGuard(NewPostgresTracker(db, "account", AccountLimits), NewPostgresTracker(db, "ip", IpLimits), AccountLimits.Lockout)
+	default:
+		log.Fatal("Unknown PROJ_LOGIN_STORE: ", config.Cfg.LoginStore)
+	}
+}
+
+// Guard tracks failed logins of accounts and IPs
+type Guard struct {
+	accounts Tracker
+	ips      Tracker
+	lockout  int // number of failures after which an account is locked out
+}
+
+// NewGuard creates a guard from trackers of accounts and IPs
+func NewGuard(accounts, ips Tracker, lockout int) *Guard {
+	return &Guard{accounts, ips, lockout}
+}
+
+// Blocked returns for how long a login to an account from an IP is not allowed. If a tracker does not
+// work, a login is allowed: it is better to be brute forced for a while than to lock everyone out
+func (g *Guard) Blocked(account, ip string) time.Duration {
+	accountBlock, err := g.accounts.Blocked(account)
+	if err != nil {
+		log.Println(err)
+	}
+
+	ipBlock, err := g.ips.Blocked(ip)
+	if err != nil {
+		log.Println(err)
+	}
+
+	if accountBlock > ipBlock {
+		return accountBlock
+	}
+	return ipBlock
+}
+
+// Fail registers a failed login. Returns true if the account has just been locked out, so its owner
+// can be notified exactly once
+func (g *Guard) Fail(account, ip string) bool {
+	failures, _, err := g.accounts.Fail(account)
+	if err != nil {
+		log.Println(err)
+	}
+
+	if _, _, err := g.ips.Fail(ip); err != nil {
+		log.Println(err)
+	}
+
+	return failures == g.lockout
+}
+
+// Succeed forgets failures of an account after a successful login. Failures of an IP are kept, otherwise
+// an attacker could reset them by logging in to his own account from time to time
+func (g *Guard) Succeed(account string) {
+	if err := g.accounts.Reset(account); err != nil {
+		log.Println(err)
+	}
+}
@@ -0,0 +1,190 @@
+package throttle
+
+import (
+	"../migrate"
+	"../models/testHelpers"
+	"../psql"
+	"io/ioutil"
+	"log"
+	"os"
+	"testing"
+	"time"
+)
+
+// only TestPostgresTracker needs a database, so it is prepared there
+func TestMain(m *testing.M) {
+	testHelpers.InitAll()
+	log.SetOutput(ioutil.Discard)
+	retCode := m.Run()
+
+	psql.Db.Close()
+	os.Exit(retCode)
+}
+
+var testLimits = Limits{2, time.Second, 10 * time.Second, 8, time.Hour, 2 * time.Hour}
+
+// clock is a fake time which moves only when a test asks
+type clock struct {
+	t time.Time
+}
+
+func (c *clock) now() time.Time {
+	return c.t
+}
+
+func TestBlock(t *testing.T) {
+	table := []struct {
+		failures int
+		block    time.Duration
+	}{
+		{0, 0},
+		{1, 0},
+		{2, 0},
+		{3, time.Second},
+		{4, 2 * time.Second},
+		{5, 4 * time.Second},
+		{6, 8 * time.Second},
+		{7, 10 * time.Second},
+		{8, time.Hour},
+		{20, time.Hour},
+	}
+	for _, v := range table {
+		if block := testLimits.block(v.failures); block != v.block {
+			t.Errorf("Expect %v after %v failures. Got %v", v.block, v.failures, block)
+		}
+	}
+}
+
+// checkTracker runs the same scenario against any tracker. A clock of the tracker is moved by the test
+func checkTracker(t *testing.T, tracker Tracker, c *clock) {
+	for i := 1; i <= 3; i++ {
+		if block, _ := tracker.Blocked("a"); block != 0 {
+			t.Fatalf("Expect attempt %v to be allowed. Got %v", i, block)
+		}
+
+		failures, block, err := tracker.Fail("a")
+		if err != nil || failures != i || block != testLimits.block(i) {
+			t.Fatalf("Expect failure %v. Got %v, %v, %v", i, failures, block, err)
+		}
+	}
+
+	if block, _ := tracker.Blocked("a"); block != time.Second {
+		t.Errorf("Expect to be blocked after 3 failures. Got %v", block)
+	}
+
+	if block, _ := tracker.Blocked("b"); block != 0 {
+		t.Errorf("Expect other keys not to be blocked. Got %v", block)
+	}
+
+	c.t = c.t.Add(time.Second)
+	if block, _ := tracker.Blocked("a"); block != 0 {
+		t.Errorf("Expect a block to end. Got %v", block)
+	}
+
+	for i := 4; i <= testLimits.Lockout; i++ {
+		tracker.Fail("a")
+	}
+
+	c.t = c.t.Add(time.Minute)
+	if block, _ := tracker.Blocked("a"); block != time.Hour-time.Minute {
+		t.Errorf("Expect to be locked out. Got %v", block)
+	}
+
+	if err := tracker.Reset("a"); err != nil {
+		t.Fatal(err)
+	}
+
+	if block, _ := tracker.Blocked("a"); block != 0 {
+		t.Errorf("Expect a key to be allowed after a reset. Got %v", block)
+	}
+
+	// failures are forgotten after a while without failures
+	for i := 0; i < 3; i++ {
+		tracker.Fail("b")
+	}
+
+	c.t = c.t.Add(testLimits.Forget + time.Second)
+	if failures, block, _ := tracker.Fail("b"); failures != 1 || block != 0 {
+		t.Errorf("Expect old failures to be forgotten. Got %v, %v", failures, block)
+	}
+}
+
+func TestMemoryTracker(t *testing.T) {
+	c := &clock{time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	tracker := NewMemoryTracker(testLimits)
+	tracker.now = c.now
+	checkTracker(t, tracker, c)
+
+	// forgotten keys are removed from memory
+	c.t = c.t.Add(testLimits.Forget + time.Second)
+	tracker.Fail("c")
+	if len(tracker.entries) != 1 {
+		t.Errorf("Expect only one key to stay in memory. Got %v", len(tracker.entries))
+	}
+}
+
+func TestPostgresTracker(t *testing.T) {
+	// testHelpers.CleanUpDb expects to be called two levels below the root, so only migrations are applied
+	if _, err := migrate.Up(psql.Db, "../SQL/migrations", migrate.All); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := psql.Db.Exec(`DELETE FROM login_attempts`); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &clock{time.Now().UTC().Truncate(time.Second)}
+	tracker := NewPostgresTracker(psql.Db, "account", testLimits)
+	tracker.now = c.now
+	checkTracker(t, tracker, c)
+
+	// scopes do not see failures of each other
+	other := NewPostgresTracker(psql.Db, "ip", testLimits)
+	other.now = c.now
+	if failures, _, _ := other.Fail("b"); failures != 1 {
+		t.Errorf("Expect scopes to be separate. Got %v", failures)
+	}
+}
+
+func TestGuard(t *testing.T) {
+	c := &clock{time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)}
+	accounts, ips := NewMemoryTracker(testLimits), NewMemoryTracker(Limits{4, time.Minute, time.Minute, 100, time.Hour, time.Hour})
+	accounts.now, ips.now = c.now, c.now
+	guard := NewGuard(accounts, ips, testLimits.Lockout)
+
+	// an attacker tries many accounts from one IP
+	for i := 0; i < 5; i++ {
+		guard.Fail(string(rune('a'+i))+"@gmail.com", "10.0.0.1")
+	}
+
+	if block := guard.Blocked("new@gmail.com", "10.0.0.1"); block != time.Minute {
+		t.Errorf("Expect an IP to be blocked. Got %v", block)
+	}
+
+	if block := guard.Blocked("new@gmail.com", "10.0.0.2"); block != 0 {
+		t.Errorf("Expect another IP not to be blocked. Got %v", block)
+	}
+
+	// an attacker tries one account from many IPs. The owner is notified only once
+	notifications := 0
+	for i := 0; i < testLimits.Lockout+3; i++ {
+		if guard.Fail("victim@gmail.com", "10.0.1."+string(rune('0'+i%10))) {
+			notifications++
+		}
+	}
+
+	if notifications != 1 {
+		t.Errorf("Expect one notification about the lockout. Got %v", notifications)
+	}
+
+	if block := guard.Blocked("victim@gmail.com", "10.0.0.2"); block != time.Hour {
+		t.Errorf("Expect an account to be locked out. Got %v", block)
+	}
+
+	// the owner can log in after the lockout ends and the counter starts over
+	c.t = c.t.Add(time.Hour)
+	guard.Succeed("victim@gmail.com")
+	if block := guard.Blocked("victim@gmail.com", "10.0.0.2"); block != 0 {
+		t.Errorf("Expect an account to be allowed after a success. Got %v", block)
+	}
+}